@@ -0,0 +1,420 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package alert evaluates simple rules against every poll of the modem and
+// notifies JSON webhooks when they start and stop firing, for households
+// without an Alertmanager.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Rule kinds.
+const (
+	// Any downstream channel SNR is below Threshold dB.
+	SNRBelow = "snr_below"
+	// The uncorrectable codeword total grows faster than Threshold per
+	// minute.
+	UncorrectableRate = "uncorrectable_rate"
+	// Fewer than Threshold downstream channels, or if Threshold is 0, fewer
+	// than the most seen since surfer started.
+	DownstreamChannelsBelow = "downstream_channels_below"
+	// As DownstreamChannelsBelow, for upstream channels.
+	UpstreamChannelsBelow = "upstream_channels_below"
+	// The modem status can't be fetched.
+	Unreachable = "unreachable"
+)
+
+// Duration is a time.Duration written in JSON as a string such as "5m".
+type Duration time.Duration
+
+// MarshalJSON encodes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule is a condition to alert on.
+type Rule struct {
+	// Name identifies the rule in notifications, it defaults to Kind.
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold"`
+	// For is how long the condition must hold before the rule fires.
+	For Duration `json:"for"`
+}
+
+// Webhook is an endpoint notifications are POSTed to.
+type Webhook struct {
+	URL string `json:"url"`
+	// Headers are added to every request, e.g. for authorization.
+	Headers map[string]string `json:"headers"`
+}
+
+// Config configures alerting.
+type Config struct {
+	Rules    []Rule    `json:"rules"`
+	Webhooks []Webhook `json:"webhooks"`
+	// RepeatInterval is how often a firing notification is resent while the
+	// rule keeps firing.  Zero only notifies once.
+	RepeatInterval Duration `json:"repeat_interval"`
+}
+
+// LoadConfig reads a JSON encoded Config from path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	names := map[string]bool{}
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		switch r.Kind {
+		case SNRBelow, UncorrectableRate, DownstreamChannelsBelow, UpstreamChannelsBelow, Unreachable:
+		default:
+			return fmt.Errorf("rule %d: unknown kind %q", i, r.Kind)
+		}
+		if r.Name == "" {
+			r.Name = r.Kind
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule %d: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+	}
+	for i, w := range cfg.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("webhook %d: missing url", i)
+		}
+	}
+	return nil
+}
+
+// Notification statuses.
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+// Notification is the JSON body POSTed to webhooks.
+type Notification struct {
+	Status    string  `json:"status"`
+	Rule      string  `json:"rule"`
+	Kind      string  `json:"kind"`
+	Model     string  `json:"model"`
+	Summary   string  `json:"summary"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	// StartsAt is when the condition started to hold.  Together with Rule it
+	// identifies an alert across repeated notifications.
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt is set when Status is Resolved.
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+type ruleState struct {
+	pending  time.Time
+	firing   bool
+	lastSent time.Time
+	value    float64
+	summary  string
+}
+
+// Manager evaluates rules and sends notifications.
+type Manager struct {
+	cfg     *Config
+	model   string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	mu     sync.Mutex
+	states map[string]*ruleState
+	// Previous successful poll, for rates.
+	prevTime          time.Time
+	prevUncorrectable map[modem.Channel]float64
+	// Most channels seen so far.
+	maxDownstream, maxUpstream int
+
+	queue chan Notification
+	done  chan struct{}
+}
+
+// NewManager returns a Manager for the rules in cfg that notifies about the
+// modem named model.  Notifications are sent from a background goroutine
+// stopped by Close.
+func NewManager(cfg *Config, model string) *Manager {
+	m := &Manager{
+		cfg:     cfg,
+		model:   model,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retries: 3,
+		backoff: time.Second,
+		states:  map[string]*ruleState{},
+		queue:   make(chan Notification, 100),
+		done:    make(chan struct{}),
+	}
+	for _, r := range cfg.Rules {
+		m.states[r.Name] = &ruleState{}
+	}
+	go m.sendLoop()
+	return m
+}
+
+// Close sends any queued notifications and stops the Manager.
+func (m *Manager) Close() error {
+	close(m.queue)
+	<-m.done
+	return nil
+}
+
+// Firing returns whether each rule, keyed by name, is firing.
+func (m *Manager) Firing() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := map[string]bool{}
+	for n, st := range m.states {
+		f[n] = st.firing
+	}
+	return f
+}
+
+// Evaluate checks every rule against the result of polling the modem at t.
+// err is the error fetching the status, in which case s is ignored.
+func (m *Manager) Evaluate(t time.Time, s *modem.Signal, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.cfg.Rules {
+		active, value, summary, ok := m.check(r, t, s, err)
+		if !ok {
+			continue
+		}
+		st := m.states[r.Name]
+		if !active {
+			if st.firing {
+				st.firing = false
+				m.notify(r, st, Resolved, t)
+			}
+			st.pending = time.Time{}
+			continue
+		}
+		if st.pending.IsZero() {
+			st.pending = t
+		}
+		st.value, st.summary = value, summary
+		switch {
+		case !st.firing && t.Sub(st.pending) >= time.Duration(r.For):
+			st.firing = true
+			m.notify(r, st, Firing, t)
+		case st.firing && m.cfg.RepeatInterval > 0 && t.Sub(st.lastSent) >= time.Duration(m.cfg.RepeatInterval):
+			m.notify(r, st, Firing, t)
+		}
+	}
+	if err == nil {
+		m.prevTime = t
+		m.prevUncorrectable = map[modem.Channel]float64{}
+		for ch, d := range s.Downstream {
+			m.prevUncorrectable[ch] = d.Uncorrectable
+		}
+		if n := len(s.Downstream); n > m.maxDownstream {
+			m.maxDownstream = n
+		}
+		if n := len(s.Upstream); n > m.maxUpstream {
+			m.maxUpstream = n
+		}
+	}
+}
+
+// check returns whether r's condition holds, the value it was decided on and
+// a description.  ok is false if the poll doesn't tell either way, such as
+// signal rules when the modem couldn't be reached.
+func (m *Manager) check(r Rule, t time.Time, s *modem.Signal, err error) (active bool, value float64, summary string, ok bool) {
+	if r.Kind == Unreachable {
+		if err != nil {
+			return true, 1, fmt.Sprintf("%s unreachable: %v", m.model, err), true
+		}
+		return false, 0, "", true
+	}
+	if err != nil {
+		return false, 0, "", false
+	}
+
+	switch r.Kind {
+	case SNRBelow:
+		min := math.Inf(1)
+		var low []string
+		for _, ch := range s.DownstreamChannels() {
+			d := s.Downstream[ch]
+			min = math.Min(min, d.SNR)
+			if d.SNR < r.Threshold {
+				low = append(low, string(ch))
+			}
+		}
+		if len(low) == 0 {
+			return false, min, "", true
+		}
+		return true, min, fmt.Sprintf("downstream SNR below %g dB on channel %s, lowest %g dB", r.Threshold, strings.Join(low, ", "), min), true
+
+	case UncorrectableRate:
+		if m.prevUncorrectable == nil || !t.After(m.prevTime) {
+			return false, 0, "", false
+		}
+		var growth float64
+		for ch, d := range s.Downstream {
+			last, ok := m.prevUncorrectable[ch]
+			switch {
+			case !ok:
+			case d.Uncorrectable >= last:
+				growth += d.Uncorrectable - last
+			default:
+				// The counter was reset, likely by a modem reboot.
+				growth += d.Uncorrectable
+			}
+		}
+		rate := growth / t.Sub(m.prevTime).Minutes()
+		if rate <= r.Threshold {
+			return false, rate, "", true
+		}
+		return true, rate, fmt.Sprintf("uncorrectable codewords rising %.1f per minute, above %g", rate, r.Threshold), true
+
+	case DownstreamChannelsBelow, UpstreamChannelsBelow:
+		dir, n, want := "downstream", len(s.Downstream), m.maxDownstream
+		if r.Kind == UpstreamChannelsBelow {
+			dir, n, want = "upstream", len(s.Upstream), m.maxUpstream
+		}
+		if r.Threshold > 0 {
+			want = int(r.Threshold)
+		}
+		if n >= want {
+			return false, float64(n), "", true
+		}
+		return true, float64(n), fmt.Sprintf("%d %s channels, expected %d", n, dir, want), true
+	}
+	return false, 0, "", false
+}
+
+func (m *Manager) notify(r Rule, st *ruleState, status string, t time.Time) {
+	n := Notification{
+		Status:    status,
+		Rule:      r.Name,
+		Kind:      r.Kind,
+		Model:     m.model,
+		Summary:   st.summary,
+		Value:     st.value,
+		Threshold: r.Threshold,
+		StartsAt:  st.pending,
+	}
+	if status == Resolved {
+		n.EndsAt = &t
+		n.Summary = "resolved: " + st.summary
+	} else {
+		st.lastSent = t
+	}
+	glog.Infof("Alert %s %s: %s", r.Name, status, n.Summary)
+	select {
+	case m.queue <- n:
+	default:
+		glog.Errorf("Dropping %s notification for %s, send queue is full", status, r.Name)
+	}
+}
+
+func (m *Manager) sendLoop() {
+	defer close(m.done)
+	for n := range m.queue {
+		b, err := json.Marshal(n)
+		if err != nil {
+			glog.Errorf("Failed to encode notification: %v", err)
+			continue
+		}
+		for _, w := range m.cfg.Webhooks {
+			if err := m.post(w, b); err != nil {
+				glog.Errorf("Failed to notify %s of %s %s: %v", w.URL, n.Rule, n.Status, err)
+			}
+		}
+	}
+}
+
+// post sends b to w, retrying failures with exponential backoff.
+func (m *Manager) post(w Webhook, b []byte) error {
+	var err error
+	backoff := m.backoff
+	for i := 0; i < m.retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = m.postOnce(w, b); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (m *Manager) postOnce(w Webhook, b []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
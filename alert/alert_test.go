@@ -0,0 +1,263 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// receiver records notifications POSTed to it.  The first fail requests are
+// answered with an error.
+type receiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	got    []Notification
+	header http.Header
+	fail   int
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.fail > 0 {
+			r.fail--
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var n Notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		r.got = append(r.got, n)
+		r.header = req.Header
+	}))
+	return r
+}
+
+func (r *receiver) notifications() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.got
+}
+
+func newManager(r *receiver, repeat time.Duration, rules ...Rule) *Manager {
+	cfg := &Config{
+		Rules:          rules,
+		Webhooks:       []Webhook{{URL: r.URL, Headers: map[string]string{"Authorization": "Bearer secret"}}},
+		RepeatInterval: Duration(repeat),
+	}
+	if err := cfg.validate(); err != nil {
+		panic(err)
+	}
+	m := NewManager(cfg, "SB8200")
+	m.backoff = time.Millisecond
+	return m
+}
+
+func signal(snr, uncorrectable float64, downstream int) *modem.Signal {
+	s := &modem.Signal{Downstream: map[modem.Channel]*modem.Downstream{}, Upstream: map[modem.Channel]*modem.Upstream{}}
+	for i := 1; i <= downstream; i++ {
+		s.Downstream[modem.Channel(string(rune('0'+i)))] = &modem.Downstream{SNR: snr + float64(i), Uncorrectable: uncorrectable}
+	}
+	s.Upstream["1"] = &modem.Upstream{PowerLevel: 40}
+	return s
+}
+
+type statusRule struct {
+	status, rule string
+}
+
+func statuses(ns []Notification) []statusRule {
+	var got []statusRule
+	for _, n := range ns {
+		got = append(got, statusRule{n.Status, n.Rule})
+	}
+	return got
+}
+
+func checkStatuses(t *testing.T, got []Notification, want ...statusRule) {
+	t.Helper()
+	g := statuses(got)
+	if len(g) != len(want) {
+		t.Fatalf("Got notifications %v, want %v", g, want)
+	}
+	for i := range g {
+		if g[i] != want[i] {
+			t.Fatalf("Got notifications %v, want %v", g, want)
+		}
+	}
+}
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSNRForRepeatAndResolve(t *testing.T) {
+	r := newReceiver(t)
+	defer r.Close()
+	m := newManager(r, 30*time.Minute, Rule{Name: "LowSNR", Kind: SNRBelow, Threshold: 33, For: Duration(10 * time.Minute)})
+
+	// Channel 1 has an SNR of 31 dB from the first poll.
+	for i := 0; i <= 45; i += 5 {
+		m.Evaluate(start.Add(time.Duration(i)*time.Minute), signal(30, 0, 4), nil)
+	}
+	if !m.Firing()["LowSNR"] {
+		t.Errorf("LowSNR isn't firing")
+	}
+	m.Evaluate(start.Add(50*time.Minute), signal(40, 0, 4), nil)
+	m.Evaluate(start.Add(55*time.Minute), signal(40, 0, 4), nil)
+	m.Close()
+
+	got := r.notifications()
+	// Fires 10 minutes in, repeats 30 minutes later and resolves once.
+	checkStatuses(t, got, statusRule{Firing, "LowSNR"}, statusRule{Firing, "LowSNR"}, statusRule{Resolved, "LowSNR"})
+	if want := "downstream SNR below 33 dB on channel 1, 2, lowest 31 dB"; got[0].Summary != want {
+		t.Errorf("Summary got %q, want %q", got[0].Summary, want)
+	}
+	if got[0].Model != "SB8200" || got[0].Value != 31 || got[0].Threshold != 33 {
+		t.Errorf("Got notification %+v", got[0])
+	}
+	for _, n := range got {
+		if !n.StartsAt.Equal(start) {
+			t.Errorf("%s StartsAt got %v, want %v", n.Status, n.StartsAt, start)
+		}
+	}
+	if got[2].EndsAt == nil || !got[2].EndsAt.Equal(start.Add(50*time.Minute)) {
+		t.Errorf("Resolved EndsAt got %v, want %v", got[2].EndsAt, start.Add(50*time.Minute))
+	}
+	if got, want := r.header.Get("Authorization"), "Bearer secret"; got != want {
+		t.Errorf("Authorization header got %q, want %q", got, want)
+	}
+}
+
+func TestBriefConditionDoesNotFire(t *testing.T) {
+	r := newReceiver(t)
+	defer r.Close()
+	m := newManager(r, 0, Rule{Kind: SNRBelow, Threshold: 33, For: Duration(10 * time.Minute)})
+	m.Evaluate(start, signal(30, 0, 4), nil)
+	m.Evaluate(start.Add(5*time.Minute), signal(40, 0, 4), nil)
+	m.Evaluate(start.Add(10*time.Minute), signal(30, 0, 4), nil)
+	m.Evaluate(start.Add(15*time.Minute), signal(30, 0, 4), nil)
+	m.Close()
+	checkStatuses(t, r.notifications())
+}
+
+func TestUncorrectableRate(t *testing.T) {
+	r := newReceiver(t)
+	defer r.Close()
+	m := newManager(r, 0, Rule{Kind: UncorrectableRate, Threshold: 100})
+	// 4 channels each growing by 30 a minute is 120 per minute.
+	m.Evaluate(start, signal(40, 0, 4), nil)
+	m.Evaluate(start.Add(time.Minute), signal(40, 10, 4), nil)
+	m.Evaluate(start.Add(2*time.Minute), signal(40, 40, 4), nil)
+	m.Evaluate(start.Add(3*time.Minute), signal(40, 45, 4), nil)
+	m.Close()
+
+	got := r.notifications()
+	checkStatuses(t, got, statusRule{Firing, UncorrectableRate}, statusRule{Resolved, UncorrectableRate})
+	if got[0].Value != 120 {
+		t.Errorf("Rate got %v, want 120", got[0].Value)
+	}
+}
+
+func TestChannelsDropped(t *testing.T) {
+	r := newReceiver(t)
+	defer r.Close()
+	m := newManager(r, 0,
+		Rule{Kind: DownstreamChannelsBelow},
+		Rule{Name: "FewUpstream", Kind: UpstreamChannelsBelow, Threshold: 2},
+	)
+	m.Evaluate(start, signal(40, 0, 8), nil)
+	m.Evaluate(start.Add(time.Minute), signal(40, 0, 6), nil)
+	m.Close()
+
+	got := r.notifications()
+	checkStatuses(t, got,
+		statusRule{Firing, "FewUpstream"},
+		statusRule{Firing, DownstreamChannelsBelow},
+	)
+	if want := "6 downstream channels, expected 8"; got[1].Summary != want {
+		t.Errorf("Summary got %q, want %q", got[1].Summary, want)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	r := newReceiver(t)
+	defer r.Close()
+	// The first attempt to deliver is rejected and retried.
+	r.fail = 1
+	m := newManager(r, 0,
+		Rule{Kind: Unreachable, For: Duration(2 * time.Minute)},
+		Rule{Kind: SNRBelow, Threshold: 33},
+	)
+	m.Evaluate(start, signal(30, 0, 4), nil)
+	for i := 1; i <= 3; i++ {
+		m.Evaluate(start.Add(time.Duration(i)*time.Minute), nil, errors.New("connection refused"))
+	}
+	m.Evaluate(start.Add(4*time.Minute), signal(30, 0, 4), nil)
+	m.Close()
+
+	// The SNR rule neither resolves nor refires while the modem is
+	// unreachable.
+	checkStatuses(t, r.notifications(),
+		statusRule{Firing, SNRBelow},
+		statusRule{Firing, Unreachable},
+		statusRule{Resolved, Unreachable},
+	)
+}
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+		"rules": [{"kind": "snr_below", "threshold": 33, "for": "10m"}],
+		"webhooks": [{"url": "http://example.com/hook"}],
+		"repeat_interval": "4h"
+	}`)
+	f.Close()
+	cfg, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Rules[0], (Rule{Name: SNRBelow, Kind: SNRBelow, Threshold: 33, For: Duration(10 * time.Minute)}); got != want {
+		t.Errorf("Rule got %+v, want %+v", got, want)
+	}
+	if got, want := time.Duration(cfg.RepeatInterval), 4*time.Hour; got != want {
+		t.Errorf("RepeatInterval got %v, want %v", got, want)
+	}
+
+	for _, bad := range []*Config{
+		{Rules: []Rule{{Kind: "nope"}}},
+		{Rules: []Rule{{Kind: SNRBelow}, {Kind: SNRBelow}}},
+		{Webhooks: []Webhook{{}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded, want error", bad)
+		}
+	}
+}
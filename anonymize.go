@@ -0,0 +1,97 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/wathiede/surfer/anonymize"
+)
+
+// anonymizeCmd implements `surfer anonymize`, which scrubs identifiers from
+// captured modem pages.  It returns the process exit code.
+func anonymizeCmd(args []string) int {
+	fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+	out := fs.String("out", "", "directory to write anonymized files to.  Required when anonymizing more than one file.  (default) write to stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: surfer anonymize [-out dir] file|dir...\n\n")
+		fmt.Fprintf(fs.Output(), "Replaces MAC addresses, serial numbers, IPs, config file names and session\n")
+		fmt.Fprintf(fs.Output(), "cookies in captured modem pages with stable fake values.  Directories, such\n")
+		fmt.Fprintf(fs.Output(), "as those written by -record_dir, are walked recursively.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Walk every argument first so a single file can go to stdout.
+	type file struct{ path, rel string }
+	var files []file
+	for _, arg := range fs.Args() {
+		err := filepath.Walk(arg, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(arg, p)
+			if err != nil || rel == "." {
+				rel = filepath.Base(p)
+			}
+			files = append(files, file{path: p, rel: rel})
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "anonymize: %v\n", err)
+			return 1
+		}
+	}
+	if *out == "" && len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "anonymize: -out is required for %d files\n", len(files))
+		return 2
+	}
+
+	// One Anonymizer so identifiers shared between pages stay consistent.
+	a := anonymize.New()
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "anonymize: %v\n", err)
+			return 1
+		}
+		if anonymize.Format(b) == "" {
+			fmt.Fprintf(os.Stderr, "anonymize: %s: unrecognized modem page, only generic identifiers replaced\n", f.path)
+		}
+		b = a.Anonymize(b)
+		if *out == "" {
+			os.Stdout.Write(b)
+			continue
+		}
+		p := filepath.Join(*out, f.rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "anonymize: %v\n", err)
+			return 1
+		}
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "anonymize: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
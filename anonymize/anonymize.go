@@ -0,0 +1,282 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package anonymize scrubs identifying values from captured modem pages so
+// they can be shared in bug reports or committed as testdata.  Identifiers
+// are replaced in place with fake values of the same shape, and the same
+// identifier always maps to the same fake value, so the result still parses
+// with the driver that produced it.
+package anonymize
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type kind int
+
+const (
+	mac kind = iota
+	ip
+	serial
+	configFile
+	token
+)
+
+// format describes where a modem's pages keep identifiers that can't be
+// found by shape alone.
+type format struct {
+	name   string
+	detect func([]byte) bool
+	// HTML table labels whose value, in the following cell, is replaced.
+	labels []field
+	// JSON keys whose string value is replaced.
+	keys []field
+}
+
+// field names an identifier and its kind.  Fields are kept in slices rather
+// than maps so fake values are numbered in the same order on every run.
+type field struct {
+	name string
+	kind kind
+}
+
+func contains(s string) func([]byte) bool {
+	return func(b []byte) bool { return bytes.Contains(b, []byte(s)) }
+}
+
+// containsAny returns a detect function matching pages containing any of ss.
+func containsAny(ss ...string) func([]byte) bool {
+	return func(b []byte) bool {
+		for _, s := range ss {
+			if bytes.Contains(b, []byte(s)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var formats = []format{
+	{
+		name:   "SB6121",
+		detect: contains(`<META content="Microsoft FrontPage 4.0" name=GENERATOR>`),
+		labels: []field{
+			{"Serial Number", serial},
+			{"Cable Modem MAC Address", mac},
+			{"HFC MAC Address", mac},
+			{"Configuration File", configFile},
+			{"Known CPE MAC Address", mac},
+			{"Cable Modem IP Address", ip},
+			{"Modem's IP Address", ip},
+			{"Obtain Configuration File Name", configFile},
+		},
+	},
+	{
+		name:   "SB6183",
+		detect: contains(`<span id="thisModelNumberIs">SB6183</span>`),
+		labels: []field{
+			{"Serial Number", serial},
+			{"Cable Modem MAC", mac},
+			{"HFC MAC Address", mac},
+			{"Config File", configFile},
+			{"Configuration File", configFile},
+			{"Cable Modem Address", ip},
+		},
+	},
+	{
+		name:   "SB8200",
+		detect: contains(`<span id="thisModelNumberIs">SB8200</span>`),
+		labels: []field{
+			{"Serial Number", serial},
+			{"Cable Modem MAC", mac},
+			{"HFC MAC Address", mac},
+			{"Config File", configFile},
+			{"Configuration File", configFile},
+		},
+	},
+	{
+		name:   "S33",
+		detect: containsAny(`"GetMultipleHNAPs`, `"Login":`, `"LoginResponse":`), // HNAP JSON keys
+		keys: []field{
+			{"Cookie", token},
+			{"PublicKey", token},
+			{"Challenge", token},
+			{"LoginPassword", token},
+			{"StatusSoftwareSerialNum", serial},
+			{"SerialNumber", serial},
+			{"CustomerConnSerialNum", serial},
+			{"StatusSoftwareMac", mac},
+			{"MacAddress", mac},
+			{"CustomerConnConfigFile", configFile},
+			{"ConfigurationFileName", configFile},
+			{"WanIPAddress", ip},
+		},
+	},
+}
+
+var (
+	macRE = regexp.MustCompile(`\b[0-9A-Fa-f]{2}[:-][0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){4}\b`)
+	// Version strings such as D30CM-OSPREY-2.4.0.1-GA look like addresses,
+	// so an address must not be joined to neighbouring word characters.
+	ipRE  = regexp.MustCompile(`(^|[^\w.-])((?:[0-9]{1,3}\.){3}[0-9]{1,3})($|[^\w.-])`)
+	cfgRE = regexp.MustCompile(`\b[\w.-]+\.(?:cfg|bin)\b`)
+	// Session cookies and HNAP authentication found in recorded exchanges.
+	cookieRE = regexp.MustCompile(`\b((?:uid|PrivateKey|credential|sessionId)=)([^;\s"]+)`)
+	hnapRE   = regexp.MustCompile(`(?mi)^(Hnap_auth: )([0-9A-Fa-f]+)`)
+)
+
+// keepIP reports whether s identifies nothing: the well known modem address,
+// the unspecified address and netmasks are kept.
+func keepIP(s string) bool {
+	return s == "192.168.100.1" || s == "0.0.0.0" || strings.HasPrefix(s, "255.")
+}
+
+// Anonymizer replaces identifiers with stable fake values.  Use the same
+// Anonymizer for every file of a capture so that an identifier appearing on
+// several pages is replaced consistently.
+type Anonymizer struct {
+	seen  map[string]string
+	count map[kind]int
+}
+
+// New returns an Anonymizer with no identifiers seen.
+func New() *Anonymizer {
+	return &Anonymizer{
+		seen:  map[string]string{},
+		count: map[kind]int{},
+	}
+}
+
+// Format returns the name of the modem whose page format b is in, or the
+// empty string if it is not recognized.
+func Format(b []byte) string {
+	for _, f := range formats {
+		if f.detect(b) {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// Anonymize returns a copy of b with identifiers replaced.  Channel data is
+// left untouched.
+func (a *Anonymizer) Anonymize(b []byte) []byte {
+	s := string(b)
+	for _, f := range formats {
+		if !f.detect(b) {
+			continue
+		}
+		for _, l := range f.labels {
+			s = a.replaceLabel(s, l.name, l.kind)
+		}
+		for _, k := range f.keys {
+			s = a.replaceKey(s, k.name, k.kind)
+		}
+	}
+	s = cookieRE.ReplaceAllStringFunc(s, func(m string) string {
+		sm := cookieRE.FindStringSubmatch(m)
+		return sm[1] + a.fake(token, sm[2])
+	})
+	s = hnapRE.ReplaceAllStringFunc(s, func(m string) string {
+		sm := hnapRE.FindStringSubmatch(m)
+		return sm[1] + a.fake(token, sm[2])
+	})
+	s = macRE.ReplaceAllStringFunc(s, func(m string) string { return a.fake(mac, m) })
+	s = ipRE.ReplaceAllStringFunc(s, func(m string) string {
+		sm := ipRE.FindStringSubmatch(m)
+		if keepIP(sm[2]) {
+			return m
+		}
+		return sm[1] + a.fake(ip, sm[2]) + sm[3]
+	})
+	s = cfgRE.ReplaceAllStringFunc(s, func(m string) string { return a.fake(configFile, m) })
+	return []byte(s)
+}
+
+func (a *Anonymizer) replaceLabel(s, label string, k kind) string {
+	// The label and value may be wrapped in formatting such as <strong>.
+	re := regexp.MustCompile(`(?is)(>\s*` + regexp.QuoteMeta(label) + `:?\s*(?:</?[a-z]+[^>]*>\s*)*?<t[dh][^>]*>\s*(?:<[a-z]+[^>]*>\s*)*)([^<]*?)(\s*<)`)
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		sm := re.FindStringSubmatch(m)
+		return sm[1] + a.fake(k, sm[2]) + sm[3]
+	})
+}
+
+func (a *Anonymizer) replaceKey(s, key string, k kind) string {
+	re := regexp.MustCompile(`("` + regexp.QuoteMeta(key) + `"\s*:\s*")([^"]*)(")`)
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		sm := re.FindStringSubmatch(m)
+		return sm[1] + a.fake(k, sm[2]) + sm[3]
+	})
+}
+
+// fake returns the replacement for v, creating one if v hasn't been seen.
+// Replacements are never replaced again, so an Anonymizer can safely be run
+// over its own output.
+func (a *Anonymizer) fake(k kind, v string) string {
+	if v == "" {
+		return v
+	}
+	if f, ok := a.seen[v]; ok {
+		return f
+	}
+	for _, f := range a.seen {
+		if f == v {
+			return v
+		}
+	}
+	a.count[k]++
+	n := a.count[k]
+	var f string
+	switch k {
+	case mac:
+		sep := ":"
+		if strings.Contains(v, "-") {
+			sep = "-"
+		}
+		// Locally administered, so it can't collide with a real device.
+		f = strings.Join([]string{"02", "00", "00", "00", fmt.Sprintf("%02X", n>>8&0xff), fmt.Sprintf("%02X", n&0xff)}, sep)
+		if strings.ToLower(v) == v {
+			f = strings.ToLower(f)
+		}
+	case ip:
+		// RFC 5737 documentation ranges.
+		nets := []string{"192.0.2", "198.51.100", "203.0.113"}
+		f = fmt.Sprintf("%s.%d", nets[(n-1)/254%len(nets)], (n-1)%254+1)
+	case serial:
+		f = pad("ANON", n, len(v))
+	case configFile:
+		f = fmt.Sprintf("anon%d", n)
+		if i := strings.LastIndex(v, "."); i != -1 {
+			f += v[i:]
+		}
+	case token:
+		f = pad("", n, len(v))
+	}
+	a.seen[v] = f
+	return f
+}
+
+// pad formats n as prefix followed by a zero padded number that is width
+// long, to preserve the shape of the original value.
+func pad(prefix string, n, width int) string {
+	d := fmt.Sprint(n)
+	if w := width - len(prefix) - len(d); w > 0 {
+		d = strings.Repeat("0", w) + d
+	}
+	return prefix + d
+}
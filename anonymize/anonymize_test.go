@@ -0,0 +1,164 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymize
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/modem/s33"
+	"github.com/wathiede/surfer/modem/sb6121"
+	"github.com/wathiede/surfer/modem/sb6183"
+	"github.com/wathiede/surfer/modem/sb8200"
+)
+
+func TestAnonymize(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		in     string
+		want   []string
+		remove []string
+	}{
+		{
+			name: "SB8200 product info",
+			in: `<span id="thisModelNumberIs">SB8200</span>
+<table><tr><td><strong>Serial Number</strong></td><td>123456789012345</td></tr>
+<tr><td>HFC MAC Address</td><td>A0:B1:C2:D3:E4:F5</td></tr>
+<tr><td>Software Version</td><td>D31CM-PEREGRINE-1.0.0.1-GA-01-NOSH</td></tr></table>
+<p>CM-MAC=a0:b1:c2:d3:e4:f5;CMTS-MAC=00:01:5c:aa:bb:cc;CM-QOS=1.1</p>`,
+			want: []string{
+				"<td>ANON00000000001</td>",
+				"<td>02:00:00:00:00:01</td>",
+				"CM-MAC=02:00:00:00:00:02;CMTS-MAC=02:00:00:00:00:03",
+				"D31CM-PEREGRINE-1.0.0.1-GA-01-NOSH",
+			},
+			remove: []string{"123456789012345", "A0:B1:C2:D3:E4:F5", "00:01:5c:aa:bb:cc"},
+		},
+		{
+			name: "SB6121 address page",
+			in: `<META content="Microsoft FrontPage 4.0" name=GENERATOR>
+<TABLE><TR><TD>Serial Number</TD><TD>399112345678901234</TD></TR>
+<TR><TD>HFC MAC Address</TD><TD>00-1D-CF-12-34-56</TD></TR>
+<TR><TD>Cable Modem IP Address</TD><TD>10.1.2.3</TD></TR>
+<TR><TD>Configuration File</TD><TD>d11_m_sb6121_speedtier_c01.cm</TD></TR></TABLE>
+<a href="http://192.168.100.1/">home</a>`,
+			want: []string{
+				"<TD>ANON00000000000001</TD>",
+				"<TD>02-00-00-00-00-01</TD>",
+				"<TD>192.0.2.1</TD>",
+				"<TD>anon1.cm</TD>",
+				"http://192.168.100.1/",
+			},
+			remove: []string{"399112345678901234", "00-1D-CF-12-34-56", "10.1.2.3", "speedtier"},
+		},
+		{
+			name: "S33 HNAP login exchange",
+			in: `POST /HNAP1/ HTTP/1.1
+Hnap_auth: 0A1B2C3D4E5F60718293A4B5C6D7E8F9 1594233219000
+Cookie: uid=xYz123AbC; PrivateKey=0123456789ABCDEF0123456789ABCDEF
+
+{"LoginResponse":{"Challenge":"ABCDEFABCDEFABCDEFAB","Cookie":"xYz123AbC","PublicKey":"PUBKEY1234","LoginResult":"OK"}}`,
+			want: []string{
+				`"Cookie":"000000001"`,
+				"uid=000000001;",
+				`"LoginResult":"OK"`,
+			},
+			remove: []string{"xYz123AbC", "0123456789ABCDEF0123456789ABCDEF", "ABCDEFABCDEFABCDEFAB", "PUBKEY1234", "0A1B2C3D4E5F60718293A4B5C6D7E8F9"},
+		},
+		{
+			// Mentioning the model isn't enough to be treated as HNAP JSON.
+			name: "SB6183 page mentioning S33 and HNAP",
+			in:   `<p>Upgrade to an S33, HNAP "Cookie":"keep"</p>`,
+			want: []string{`"Cookie":"keep"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := New()
+			got := string(a.Anonymize([]byte(tc.in)))
+			for _, w := range tc.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("Missing %q in:\n%s", w, got)
+				}
+			}
+			for _, r := range tc.remove {
+				if strings.Contains(got, r) {
+					t.Errorf("Identifier %q not removed:\n%s", r, got)
+				}
+			}
+			if again := string(a.Anonymize([]byte(got))); again != got {
+				t.Errorf("Anonymizing twice changed output:\n%s\nWant:\n%s", again, got)
+			}
+			if again := string(New().Anonymize([]byte(tc.in))); again != got {
+				t.Errorf("Output not stable across runs:\n%s\nWant:\n%s", again, got)
+			}
+		})
+	}
+}
+
+// TestFixturesStillParse checks that anonymized fixtures of every driver
+// produce the same signal data as the originals.
+func TestFixturesStillParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		path string
+		new  func(string) (modem.Modem, error)
+	}{
+		{"../modem/s33/testdata/S33-signal.json", s33.NewFakeData},
+		{"../modem/sb6121/testdata/SB6121-signal.html", sb6121.NewFakeData},
+		{"../modem/sb6183/testdata/SB6183.html", sb6183.NewFakeData},
+		{"../modem/sb8200/testdata/SB8200.html", sb8200.NewFakeData},
+	} {
+		b, err := ioutil.ReadFile(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Format(b) == "" {
+			t.Errorf("%s: format not detected", tc.path)
+		}
+		p := filepath.Join(dir, filepath.Base(tc.path))
+		if err := ioutil.WriteFile(p, New().Anonymize(b), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		want := status(t, tc.new, tc.path)
+		got := status(t, tc.new, p)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: anonymized fixture parsed differently", tc.path)
+		}
+	}
+}
+
+func status(t *testing.T, f func(string) (modem.Modem, error), path string) *modem.Signal {
+	m, err := f(path)
+	if err != nil {
+		t.Fatalf("Failed to load %q: %v", path, err)
+	}
+	s, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", path, err)
+	}
+	return s
+}
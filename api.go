@@ -0,0 +1,131 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// apiVersion is bumped whenever a field of the JSON API is removed or changes
+// meaning.  Adding fields doesn't change it.
+const apiVersion = 1
+
+// apiStatus is the response of /api/v1/status.  The signal fields are those
+// of the last successful fetch, and are left out if there hasn't been one.
+type apiStatus struct {
+	APIVersion    int        `json:"api_version"`
+	Model         string     `json:"model"`
+	FetchedAt     *time.Time `json:"fetched_at,omitempty"`
+	FetchDuration float64    `json:"fetch_duration_seconds,omitempty"`
+	// LastError is set if the most recent fetch failed.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	*statusReport
+}
+
+// apiChannel is the response of /api/v1/channels/{id}.  Downstream and
+// upstream channels are numbered independently, so either or both may be
+// set.
+type apiChannel struct {
+	APIVersion int               `json:"api_version"`
+	Model      string            `json:"model"`
+	FetchedAt  time.Time         `json:"fetched_at"`
+	Downstream *downstreamReport `json:"downstream,omitempty"`
+	Upstream   *upstreamReport   `json:"upstream,omitempty"`
+}
+
+// apiHandler serves the JSON API from the poller's latest results, only
+// fetching when the modem isn't being polled in the background.
+type apiHandler struct {
+	p       *poller
+	profile *quality.Profile
+}
+
+func (h *apiHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/status", h.status)
+	mux.HandleFunc("/api/v1/channels/", h.channel)
+}
+
+// newAPIStatus returns the status of the modem called name given its latest
+// poll and latest successful poll, either of which may be nil.
+func newAPIStatus(name string, last, lastOK *poll, profile *quality.Profile) *apiStatus {
+	st := &apiStatus{APIVersion: apiVersion, Model: name}
+	if last != nil && last.Err != nil {
+		st.LastError = last.Err.Error()
+		st.LastErrorAt = &last.Time
+	}
+	if lastOK != nil {
+		st.FetchedAt = &lastOK.Time
+		st.FetchDuration = lastOK.Duration.Seconds()
+		st.statusReport = newStatusReport(name, lastOK.Signal, profile.Evaluate(lastOK.Signal))
+	}
+	return st
+}
+
+func (h *apiHandler) report() (*apiStatus, *poll) {
+	// A fetch is shared with other callers, so it isn't tied to this
+	// request's context.
+	last, lastOK := h.p.cached(context.Background())
+	return newAPIStatus(h.p.m.Name(), last, lastOK, h.profile), lastOK
+}
+
+func (h *apiHandler) status(w http.ResponseWriter, r *http.Request) {
+	st, lastOK := h.report()
+	code := http.StatusOK
+	if lastOK == nil {
+		code = http.StatusServiceUnavailable
+	}
+	writeAPI(w, code, st)
+}
+
+func (h *apiHandler) channel(w http.ResponseWriter, r *http.Request) {
+	id := modem.Channel(strings.TrimPrefix(r.URL.Path, "/api/v1/channels/"))
+	st, lastOK := h.report()
+	if lastOK == nil {
+		http.Error(w, st.LastError, http.StatusServiceUnavailable)
+		return
+	}
+	ch := &apiChannel{APIVersion: apiVersion, Model: st.Model, FetchedAt: lastOK.Time}
+	for i := range st.Downstream {
+		if st.Downstream[i].Channel == id {
+			ch.Downstream = &st.Downstream[i]
+		}
+	}
+	for i := range st.Upstream {
+		if st.Upstream[i].Channel == id {
+			ch.Upstream = &st.Upstream[i]
+		}
+	}
+	if ch.Downstream == nil && ch.Upstream == nil {
+		http.Error(w, "no channel "+string(id), http.StatusNotFound)
+		return
+	}
+	writeAPI(w, http.StatusOK, ch)
+}
+
+func writeAPI(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
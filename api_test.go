@@ -0,0 +1,170 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// fakeModem returns its signal, or err if set, and counts calls to Status.
+type fakeModem struct {
+	mu    sync.Mutex
+	s     *modem.Signal
+	err   error
+	calls int
+}
+
+func (f *fakeModem) Name() string { return "FAKE" }
+
+func (f *fakeModem) Status(context.Context) (*modem.Signal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.s, nil
+}
+
+func newTestPoller(t *testing.T, m modem.Modem) *poller {
+	return newPoller(m, 0)
+}
+
+func testSignal() *modem.Signal {
+	return &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Frequency: "573000000", Modulation: "QAM256", PowerLevel: 2.4, SNR: 40},
+			"2": {Frequency: "579000000", Modulation: "QAM256", PowerLevel: 2.5, SNR: 29},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Frequency: "36000000", Modulation: "ATDMA", Status: "Locked", PowerLevel: 42},
+		},
+	}
+}
+
+// decodedStatus mirrors apiStatus, whose embedded pointer can't be decoded
+// into.
+type decodedStatus struct {
+	APIVersion int        `json:"api_version"`
+	Model      string     `json:"model"`
+	FetchedAt  *time.Time `json:"fetched_at"`
+	LastError  string     `json:"last_error"`
+	statusReport
+}
+
+func get(t *testing.T, h http.Handler, path string, v interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if v != nil && w.Code != http.StatusNotFound {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v\n%s", path, err, w.Body)
+		}
+	}
+	return w.Code
+}
+
+func TestAPIStatus(t *testing.T) {
+	fm := &fakeModem{s: testSignal()}
+	p := newTestPoller(t, fm)
+	mux := http.NewServeMux()
+	(&apiHandler{p: p, profile: quality.Profiles["docsis"]}).register(mux)
+
+	var st decodedStatus
+	if code := get(t, mux, "/api/v1/status", &st); code != http.StatusOK {
+		t.Fatalf("Got status %d", code)
+	}
+	if st.APIVersion != 1 || st.Model != "FAKE" || st.FetchedAt == nil || st.LastError != "" {
+		t.Errorf("Got %+v", st)
+	}
+	if len(st.Downstream) != 2 || st.Downstream[1].Quality != quality.Bad || st.Upstream[0].PowerLevel != 42 {
+		t.Errorf("Got channels %+v %+v", st.Downstream, st.Upstream)
+	}
+
+	// A recent fetch is reused.
+	get(t, mux, "/api/v1/status", &st)
+	if fm.calls != 1 {
+		t.Errorf("Got %d fetches, want 1", fm.calls)
+	}
+	// Without background polling, older fetches are refreshed.
+	p.maxAge = 0
+	get(t, mux, "/api/v1/status", &st)
+	if fm.calls != 2 {
+		t.Errorf("Got %d fetches, want 2", fm.calls)
+	}
+	// While polling, the latest poll is served.
+	p.interval = 1
+	get(t, mux, "/api/v1/status", &st)
+	if fm.calls != 2 {
+		t.Errorf("Got %d fetches while polling, want 2", fm.calls)
+	}
+
+	// A failed fetch still returns the last good signal.
+	p.interval = 0
+	fm.err = errors.New("connection refused")
+	st = decodedStatus{}
+	if code := get(t, mux, "/api/v1/status", &st); code != http.StatusOK {
+		t.Fatalf("Got status %d", code)
+	}
+	if st.LastError != "connection refused" || len(st.Downstream) != 2 {
+		t.Errorf("Got %+v", st)
+	}
+}
+
+func TestAPIUnreachable(t *testing.T) {
+	p := newTestPoller(t, &fakeModem{err: errors.New("connection refused")})
+	mux := http.NewServeMux()
+	(&apiHandler{p: p, profile: quality.Profiles["docsis"]}).register(mux)
+
+	var st decodedStatus
+	if code := get(t, mux, "/api/v1/status", &st); code != http.StatusServiceUnavailable {
+		t.Errorf("Got status %d, want 503", code)
+	}
+	if st.LastError != "connection refused" || st.Downstream != nil {
+		t.Errorf("Got %+v", st)
+	}
+}
+
+func TestAPIChannel(t *testing.T) {
+	p := newTestPoller(t, &fakeModem{s: testSignal()})
+	mux := http.NewServeMux()
+	(&apiHandler{p: p, profile: quality.Profiles["docsis"]}).register(mux)
+
+	var ch apiChannel
+	if code := get(t, mux, "/api/v1/channels/1", &ch); code != http.StatusOK {
+		t.Fatalf("Got status %d", code)
+	}
+	if ch.Downstream == nil || ch.Downstream.SNR != 40 || ch.Upstream == nil || ch.Upstream.PowerLevel != 42 {
+		t.Errorf("Got %+v", ch)
+	}
+	ch = apiChannel{}
+	get(t, mux, "/api/v1/channels/2", &ch)
+	if ch.Downstream == nil || ch.Upstream != nil {
+		t.Errorf("Got %+v", ch)
+	}
+	if code := get(t, mux, "/api/v1/channels/99", nil); code != http.StatusNotFound {
+		t.Errorf("Got status %d, want 404", code)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/check"
+)
+
+// rangeFlag is a flag.Value holding a check.Range.
+type rangeFlag struct{ r *check.Range }
+
+func (f rangeFlag) String() string {
+	if f.r == nil {
+		return ""
+	}
+	return f.r.String()
+}
+
+func (f rangeFlag) Set(s string) error {
+	r, err := check.ParseRange(s)
+	if err != nil {
+		return err
+	}
+	*f.r = r
+	return nil
+}
+
+func thresholdFlags(fs *flag.FlagSet, t *check.Threshold, name, warn, crit, desc string) {
+	t.Warn, _ = check.ParseRange(warn)
+	t.Crit, _ = check.ParseRange(crit)
+	fs.Var(rangeFlag{&t.Warn}, name+"_warn", "warning range for "+desc)
+	fs.Var(rangeFlag{&t.Crit}, name+"_crit", "critical range for "+desc)
+}
+
+// checkCmd implements `surfer check`, a Nagios/Icinga plugin.  It returns the
+// plugin exit code.
+func checkCmd(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	stateFile := fs.String("state_file", "", "file to keep counters in between runs, required to check uncorrectable codeword growth")
+	fs.StringVar(fakeDataPath, "fake", *fakeDataPath, "path to fake HTML data instead of fetching over HTTP")
+	fs.StringVar(model, "model", *model, "cable modem model to use instead of autodetecting it")
+	fs.DurationVar(timeout, "timeout", *timeout, "timeout for the HTTP GET to cable modem")
+	var t check.Thresholds
+	thresholdFlags(fs, &t.DownstreamPower, "ds_power", "-7:7", "-15:15", "downstream power in dBmV")
+	thresholdFlags(fs, &t.SNR, "ds_snr", "33:", "30:", "downstream SNR in dB")
+	thresholdFlags(fs, &t.UpstreamPower, "us_power", "38:48", "35:51", "upstream power in dBmV")
+	thresholdFlags(fs, &t.UncorrectableGrowth, "uncorrectable", "100", "1000", "uncorrectable codewords since the last run")
+	thresholdFlags(fs, &t.LockedDownstream, "ds_locked", "", "1:", "the number of locked downstream channels")
+	thresholdFlags(fs, &t.LockedUpstream, "us_locked", "", "1:", "the number of locked upstream channels")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: surfer check [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Nagios/Icinga plugin.  Prints a status line with perfdata and exits 0 for OK,\n")
+		fmt.Fprintf(fs.Output(), "1 for WARNING, 2 for CRITICAL and 3 for UNKNOWN.  Ranges use the plugin\n")
+		fmt.Fprintf(fs.Output(), "guideline syntax, e.g. 10: alerts below 10 and @5:10 alerts between 5 and 10.\n")
+		fmt.Fprintf(fs.Output(), "An empty range disables the check.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		// Plugins report bad arguments as UNKNOWN.
+		return int(check.Unknown)
+	}
+
+	r := runCheck(*stateFile, t)
+	fmt.Println(r)
+	return int(r.Status)
+}
+
+func runCheck(stateFile string, t check.Thresholds) *check.Result {
+	ctx := context.Background()
+	m, err := newModem(ctx)
+	if err != nil {
+		return check.Fail(err)
+	}
+	if m == nil {
+		return check.Fail(errors.New("no modem found"))
+	}
+	defer closeModem(m)
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	s, err := m.Status(ctx)
+	if err != nil {
+		return check.Fail(fmt.Errorf("failed to fetch %s status: %v", m.Name(), err))
+	}
+
+	var prev *check.State
+	if stateFile != "" {
+		if prev, err = check.LoadState(stateFile); err != nil {
+			// A corrupt state file only costs one run of growth checking.
+			glog.Errorf("Ignoring state: %v", err)
+			prev = nil
+		}
+	}
+	r := check.Evaluate(s, prev, t)
+	if stateFile != "" {
+		if err := check.NewState(time.Now(), s).Save(stateFile); err != nil {
+			fmt.Fprintf(os.Stderr, "check: failed to save state: %v\n", err)
+		}
+	}
+	return r
+}
@@ -0,0 +1,360 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package check evaluates modem signal data against thresholds and formats
+// the result as Nagios/Icinga plugin output.
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Status is a plugin result.  Its value is the plugin exit code.
+type Status int
+
+const (
+	OK Status = iota
+	Warning
+	Critical
+	Unknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Warning:
+		return "WARNING"
+	case Critical:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// Range is a Nagios plugin threshold range.  A value alerts if it is outside
+// of [Start, End], or inside of it if Inside is set.  The zero value never
+// alerts.
+type Range struct {
+	Start, End float64
+	Inside     bool
+	set        bool
+}
+
+// ParseRange parses the threshold syntax of the Nagios plugin guidelines:
+// "10" is 0 to 10, "10:" is 10 or more, "~:10" is 10 or less, "10:20" is
+// between 10 and 20, and a leading "@" alerts inside the range instead of
+// outside it.  The empty string returns a Range that never alerts.
+func ParseRange(s string) (Range, error) {
+	if s == "" {
+		return Range{}, nil
+	}
+	r := Range{Start: 0, End: math.Inf(1), set: true}
+	v := s
+	if strings.HasPrefix(v, "@") {
+		r.Inside = true
+		v = v[1:]
+	}
+	start, end := "", v
+	if i := strings.Index(v, ":"); i != -1 {
+		start, end = v[:i], v[i+1:]
+	}
+	var err error
+	switch start {
+	case "":
+	case "~":
+		r.Start = math.Inf(-1)
+	default:
+		if r.Start, err = strconv.ParseFloat(start, 64); err != nil {
+			return Range{}, fmt.Errorf("invalid range %q: %v", s, err)
+		}
+	}
+	if end != "" {
+		if r.End, err = strconv.ParseFloat(end, 64); err != nil {
+			return Range{}, fmt.Errorf("invalid range %q: %v", s, err)
+		}
+	}
+	if r.Start > r.End {
+		return Range{}, fmt.Errorf("invalid range %q: start is greater than end", s)
+	}
+	return r, nil
+}
+
+// Alert reports whether v should raise an alert.
+func (r Range) Alert(v float64) bool {
+	if !r.set {
+		return false
+	}
+	in := v >= r.Start && v <= r.End
+	return in == r.Inside
+}
+
+// String returns r in the syntax accepted by ParseRange.
+func (r Range) String() string {
+	if !r.set {
+		return ""
+	}
+	var s string
+	if r.Inside {
+		s = "@"
+	}
+	switch {
+	case math.IsInf(r.Start, -1):
+		s += "~:"
+	case r.Start != 0 || math.IsInf(r.End, 1):
+		// A start of 0 is implied unless the end is unbounded too.
+		s += strconv.FormatFloat(r.Start, 'f', -1, 64) + ":"
+	}
+	if !math.IsInf(r.End, 1) {
+		s += strconv.FormatFloat(r.End, 'f', -1, 64)
+	}
+	return s
+}
+
+// Threshold is a pair of warning and critical ranges.
+type Threshold struct {
+	Warn, Crit Range
+}
+
+func (t Threshold) status(v float64) Status {
+	switch {
+	case t.Crit.Alert(v):
+		return Critical
+	case t.Warn.Alert(v):
+		return Warning
+	}
+	return OK
+}
+
+// Thresholds configures every value that is checked.
+type Thresholds struct {
+	// Per channel downstream power in dBmV.
+	DownstreamPower Threshold
+	// Per channel downstream SNR in dB.
+	SNR Threshold
+	// Per channel upstream power in dBmV.
+	UpstreamPower Threshold
+	// Growth of the uncorrectable codeword total since the previous check.
+	UncorrectableGrowth Threshold
+	// Number of downstream channels the modem is locked to.
+	LockedDownstream Threshold
+	// Number of upstream channels the modem is locked to.
+	LockedUpstream Threshold
+}
+
+// State is kept between checks to compute counter growth.
+type State struct {
+	Time          time.Time
+	Uncorrectable map[modem.Channel]float64
+}
+
+// LoadState reads the state saved by a previous check from path.  A missing
+// file returns nil and no error.
+func LoadState(path string) (*State, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return st, nil
+}
+
+// Save writes st to path, replacing it atomically.
+func (st *State) Save(path string) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// NewState returns the state to save after checking s at time t.
+func NewState(t time.Time, s *modem.Signal) *State {
+	st := &State{Time: t, Uncorrectable: map[modem.Channel]float64{}}
+	for ch, d := range s.Downstream {
+		st.Uncorrectable[ch] = d.Uncorrectable
+	}
+	return st
+}
+
+// Perf is a single perfdata value.
+type Perf struct {
+	Label string
+	Value float64
+	UOM   string
+	Threshold
+}
+
+func (p Perf) String() string {
+	return fmt.Sprintf("'%s'=%s%s;%s;%s", p.Label, strconv.FormatFloat(p.Value, 'f', -1, 64), p.UOM, p.Warn, p.Crit)
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Status Status
+	// Summary describes the modem when nothing is wrong.
+	Summary string
+	// Problems lists every value that raised a warning or critical, worst
+	// first.
+	Problems []string
+	Perf     []Perf
+
+	// Number of critical problems at the start of Problems.
+	crit int
+}
+
+func (r *Result) problem(st Status, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if st == Critical {
+		r.Problems = append(r.Problems, "")
+		copy(r.Problems[r.crit+1:], r.Problems[r.crit:])
+		r.Problems[r.crit] = msg
+		r.crit++
+	} else {
+		r.Problems = append(r.Problems, msg)
+	}
+	if st > r.Status {
+		r.Status = st
+	}
+}
+
+// maxProblems is how many problems are listed in the status line, the rest
+// are only counted.
+const maxProblems = 5
+
+// String formats r as a single line of plugin output with perfdata.
+func (r *Result) String() string {
+	msg := r.Summary
+	switch n := len(r.Problems); {
+	case n > maxProblems:
+		msg = fmt.Sprintf("%s and %d more", strings.Join(r.Problems[:maxProblems], ", "), n-maxProblems)
+	case n > 0:
+		msg = strings.Join(r.Problems, ", ")
+	}
+	var perf []string
+	for _, p := range r.Perf {
+		perf = append(perf, p.String())
+	}
+	s := fmt.Sprintf("SURFER %s - %s", r.Status, msg)
+	if len(perf) > 0 {
+		s += " | " + strings.Join(perf, " ")
+	}
+	return s
+}
+
+// Fail returns an UNKNOWN result for when the status couldn't be fetched.
+func Fail(err error) *Result {
+	return &Result{Status: Unknown, Summary: err.Error()}
+}
+
+// Evaluate checks s against t.  prev is the state saved by the previous check
+// and may be nil, in which case uncorrectable growth isn't checked.
+func Evaluate(s *modem.Signal, prev *State, t Thresholds) *Result {
+	r := &Result{}
+
+	dsPower := Perf{Label: "ds_power_min", Threshold: t.DownstreamPower, Value: math.Inf(1)}
+	dsPowerMax := Perf{Label: "ds_power_max", Threshold: t.DownstreamPower, Value: math.Inf(-1)}
+	snr := Perf{Label: "ds_snr_min", Threshold: t.SNR, Value: math.Inf(1)}
+	var growth float64
+	for _, ch := range s.DownstreamChannels() {
+		d := s.Downstream[ch]
+		if st := t.DownstreamPower.status(d.PowerLevel); st != OK {
+			r.problem(st, "downstream %s power %g dBmV", ch, d.PowerLevel)
+		}
+		if st := t.SNR.status(d.SNR); st != OK {
+			r.problem(st, "downstream %s SNR %g dB", ch, d.SNR)
+		}
+		dsPower.Value = math.Min(dsPower.Value, d.PowerLevel)
+		dsPowerMax.Value = math.Max(dsPowerMax.Value, d.PowerLevel)
+		snr.Value = math.Min(snr.Value, d.SNR)
+		if prev != nil {
+			last, ok := prev.Uncorrectable[ch]
+			switch {
+			case !ok:
+			case d.Uncorrectable >= last:
+				growth += d.Uncorrectable - last
+			default:
+				// The counter was reset, likely by a modem reboot.
+				growth += d.Uncorrectable
+			}
+		}
+	}
+
+	usPower := Perf{Label: "us_power_min", Threshold: t.UpstreamPower, Value: math.Inf(1)}
+	usPowerMax := Perf{Label: "us_power_max", Threshold: t.UpstreamPower, Value: math.Inf(-1)}
+	var usLocked float64
+	for _, ch := range s.UpstreamChannels() {
+		u := s.Upstream[ch]
+		if st := t.UpstreamPower.status(u.PowerLevel); st != OK {
+			r.problem(st, "upstream %s power %g dBmV", ch, u.PowerLevel)
+		}
+		usPower.Value = math.Min(usPower.Value, u.PowerLevel)
+		usPowerMax.Value = math.Max(usPowerMax.Value, u.PowerLevel)
+		if u.Status == "" || modem.IsLocked(u.Status) {
+			usLocked++
+		}
+	}
+
+	var dsLocked float64
+	for _, d := range s.Downstream {
+		if d.Status == "" || modem.IsLocked(d.Status) {
+			dsLocked++
+		}
+	}
+	if st := t.LockedDownstream.status(dsLocked); st != OK {
+		r.problem(st, "%g downstream channels locked", dsLocked)
+	}
+	if st := t.LockedUpstream.status(usLocked); st != OK {
+		r.problem(st, "%g upstream channels locked", usLocked)
+	}
+	if prev != nil {
+		if st := t.UncorrectableGrowth.status(growth); st != OK {
+			r.problem(st, "%g new uncorrectable codewords", growth)
+		}
+	}
+
+	if len(s.Downstream) > 0 {
+		r.Perf = append(r.Perf, dsPower, dsPowerMax, snr)
+	}
+	if len(s.Upstream) > 0 {
+		r.Perf = append(r.Perf, usPower, usPowerMax)
+	}
+	r.Perf = append(r.Perf,
+		Perf{Label: "ds_locked", Value: dsLocked, Threshold: t.LockedDownstream},
+		Perf{Label: "us_locked", Value: usLocked, Threshold: t.LockedUpstream},
+	)
+	if prev != nil {
+		r.Perf = append(r.Perf, Perf{Label: "uncorrectable_growth", Value: growth, Threshold: t.UncorrectableGrowth})
+	}
+	r.Summary = fmt.Sprintf("%g downstream and %g upstream channels locked", dsLocked, usLocked)
+	return r
+}
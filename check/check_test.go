@@ -0,0 +1,182 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+func TestRange(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		alerts []float64
+		ok     []float64
+	}{
+		{"10", []float64{-1, 11}, []float64{0, 5, 10}},
+		{"10:", []float64{9.9}, []float64{10, 1000}},
+		{"0:", []float64{-0.1}, []float64{0, 1000}},
+		{"@0:", []float64{0, 1000}, []float64{-0.1}},
+		{"~:10", []float64{10.1}, []float64{-1000, 10}},
+		{"-7:7", []float64{-7.1, 7.1}, []float64{-7, 0, 7}},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}},
+		{"", nil, []float64{-1000, 0, 1000}},
+	} {
+		r, err := ParseRange(tc.in)
+		if err != nil {
+			t.Fatalf("ParseRange(%q): %v", tc.in, err)
+		}
+		if got := r.String(); got != tc.in {
+			t.Errorf("ParseRange(%q).String() = %q", tc.in, got)
+		}
+		for _, v := range tc.alerts {
+			if !r.Alert(v) {
+				t.Errorf("%q: %v did not alert", tc.in, v)
+			}
+		}
+		for _, v := range tc.ok {
+			if r.Alert(v) {
+				t.Errorf("%q: %v alerted", tc.in, v)
+			}
+		}
+	}
+	for _, in := range []string{"a", "10:5", "1:b"} {
+		if _, err := ParseRange(in); err == nil {
+			t.Errorf("ParseRange(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func mustRange(s string) Range {
+	r, err := ParseRange(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func thresholds() Thresholds {
+	return Thresholds{
+		DownstreamPower:     Threshold{mustRange("-7:7"), mustRange("-15:15")},
+		SNR:                 Threshold{mustRange("33:"), mustRange("30:")},
+		UpstreamPower:       Threshold{mustRange("38:48"), mustRange("35:51")},
+		UncorrectableGrowth: Threshold{mustRange("100"), mustRange("1000")},
+		LockedDownstream:    Threshold{Crit: mustRange("2:")},
+		LockedUpstream:      Threshold{Crit: mustRange("1:")},
+	}
+}
+
+func signal() *modem.Signal {
+	return &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {PowerLevel: 2, SNR: 38, Uncorrectable: 50},
+			"2": {PowerLevel: 3, SNR: 37, Uncorrectable: 70},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {PowerLevel: 42, Status: "Locked"},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	r := Evaluate(signal(), nil, thresholds())
+	want := "SURFER OK - 2 downstream and 1 upstream channels locked | " +
+		"'ds_power_min'=2;-7:7;-15:15 'ds_power_max'=3;-7:7;-15:15 'ds_snr_min'=37;33:;30: " +
+		"'us_power_min'=42;38:48;35:51 'us_power_max'=42;38:48;35:51 'ds_locked'=2;;2: 'us_locked'=1;;1:"
+	if got := r.String(); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+
+	s := signal()
+	s.Downstream["1"].SNR = 32
+	s.Downstream["2"].SNR = 29
+	s.Upstream["1"].PowerLevel = 49
+	r = Evaluate(s, nil, thresholds())
+	if r.Status != Critical {
+		t.Errorf("Status got %v, want %v", r.Status, Critical)
+	}
+	wantProblems := []string{"downstream 2 SNR 29 dB", "downstream 1 SNR 32 dB", "upstream 1 power 49 dBmV"}
+	if !reflect.DeepEqual(r.Problems, wantProblems) {
+		t.Errorf("Problems got %q, want %q", r.Problems, wantProblems)
+	}
+
+	s = signal()
+	s.Upstream["1"].Status = "Not Locked"
+	delete(s.Downstream, "2")
+	if r := Evaluate(s, nil, thresholds()); r.Status != Critical || len(r.Problems) != 2 {
+		t.Errorf("Lost channels got %v %q, want CRITICAL with 2 problems", r.Status, r.Problems)
+	}
+
+	// Channels still listed but no longer locked don't count.
+	s = signal()
+	s.Downstream["1"].Status = "Locked"
+	s.Downstream["2"].Status = "Not Locked"
+	want = "SURFER CRITICAL - 1 downstream channels locked"
+	if got := Evaluate(s, nil, thresholds()).String(); !strings.HasPrefix(got, want) {
+		t.Errorf("Got:\n%s\nWant prefix:\n%s", got, want)
+	}
+}
+
+func TestUncorrectableGrowth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "state.json")
+
+	prev, err := LoadState(p)
+	if err != nil || prev != nil {
+		t.Fatalf("LoadState of missing file got %v, %v, want nil, nil", prev, err)
+	}
+	if err := NewState(time.Now(), signal()).Save(p); err != nil {
+		t.Fatal(err)
+	}
+	if prev, err = LoadState(p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		ch1, ch2   float64
+		wantGrowth float64
+		want       Status
+	}{
+		{"unchanged", 50, 70, 0, OK},
+		{"warning", 100, 130, 110, Warning},
+		{"critical", 1051, 70, 1001, Critical},
+		// A reset counter counts from zero.
+		{"reset", 10, 70, 10, OK},
+	} {
+		s := signal()
+		s.Downstream["1"].Uncorrectable = tc.ch1
+		s.Downstream["2"].Uncorrectable = tc.ch2
+		r := Evaluate(s, prev, thresholds())
+		if r.Status != tc.want {
+			t.Errorf("%s: status got %v, want %v: %s", tc.name, r.Status, tc.want, r)
+		}
+		last := r.Perf[len(r.Perf)-1]
+		if last.Label != "uncorrectable_growth" || last.Value != tc.wantGrowth {
+			t.Errorf("%s: perf got %v, want uncorrectable_growth=%v", tc.name, last, tc.wantGrowth)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/wathiede/surfer/check"
+)
+
+func TestCheckCmdBadFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-colour"},
+		{"-ds_snr_warn", "high"},
+	} {
+		if got, want := checkCmd(args), int(check.Unknown); got != want {
+			t.Errorf("checkCmd(%q) got %d, want %d", args, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/record"
+)
+
+// controlTimeout bounds a control request, which may log in first.
+const controlTimeout = 30 * time.Second
+
+// controlRestart is how long a modem is taken to be restarting after a
+// reboot or reset, during which it isn't sent another.
+const controlRestart = 3 * time.Minute
+
+// controlTokenEnv is the environment variable -control_token can be set by
+// instead, keeping it out of the process list.
+const controlTokenEnv = "SURFER_CONTROL_TOKEN"
+
+var (
+	errUnknownAction   = errors.New("unknown action, must be reboot or reset")
+	errResetNotAllowed = errors.New("reset isn't allowed without -allow_reset")
+	errNotController   = errors.New("modem can't be controlled")
+	errControlBusy     = errors.New("the modem is already being rebooted or reset")
+)
+
+// controlGuard lets one control request at a time through to the modem, and
+// none while the modem is still restarting from the last one, so the API and
+// remediation don't reboot a modem that is already rebooting.  A nil
+// controlGuard lets every request through.
+type controlGuard struct {
+	mu    sync.Mutex
+	busy  bool
+	until time.Time
+	now   func() time.Time
+}
+
+func newControlGuard() *controlGuard {
+	return &controlGuard{now: time.Now}
+}
+
+// acquire returns errControlBusy if the modem is being controlled, otherwise
+// the caller must call release when done.
+func (g *controlGuard) acquire() error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.busy || g.now().Before(g.until) {
+		return errControlBusy
+	}
+	g.busy = true
+	return nil
+}
+
+// release ends a control request, which restarted the modem if ok.
+func (g *controlGuard) release(ok bool) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.busy = false
+	if ok {
+		g.until = g.now().Add(controlRestart)
+	}
+}
+
+// guardedController is a modem.Controller whose requests go through g.
+type guardedController struct {
+	modem.Controller
+	g *controlGuard
+}
+
+func (c guardedController) Reboot(ctx context.Context) error {
+	return c.g.do(ctx, c.Controller.Reboot)
+}
+
+func (c guardedController) ResetToDefaults(ctx context.Context) error {
+	return c.g.do(ctx, c.Controller.ResetToDefaults)
+}
+
+// do calls f with ctx if g lets it through.
+func (g *controlGuard) do(ctx context.Context, f func(context.Context) error) error {
+	if err := g.acquire(); err != nil {
+		return err
+	}
+	err := f(ctx)
+	g.release(err == nil)
+	return err
+}
+
+// readControlToken returns the token set by -control_token,
+// -control_token_file or $SURFER_CONTROL_TOKEN, in that order.
+func readControlToken() (string, error) {
+	if *controlToken != "" {
+		return *controlToken, nil
+	}
+	if *controlTokenFile != "" {
+		b, err := ioutil.ReadFile(*controlTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return os.Getenv(controlTokenEnv), nil
+}
+
+// control performs action, reboot or reset, on m through g.  With dryRun it
+// only checks that it could.
+func control(ctx context.Context, m modem.Modem, g *controlGuard, action string, allowReset, dryRun bool) error {
+	c, ok := m.(modem.Controller)
+	var do func(context.Context) error
+	switch action {
+	case "reboot":
+		if ok {
+			do = c.Reboot
+		}
+	case "reset":
+		if !allowReset {
+			return errResetNotAllowed
+		}
+		if ok {
+			do = c.ResetToDefaults
+		}
+	default:
+		return errUnknownAction
+	}
+	if !ok {
+		return fmt.Errorf("%s %w", m.Name(), errNotController)
+	}
+	if dryRun {
+		glog.Infof("Dry run, not sending %s to %s", action, m.Name())
+		return nil
+	}
+	glog.Warningf("Sending %s to %s", action, m.Name())
+	ctx, cancel := context.WithTimeout(record.Scrape(ctx, action), controlTimeout)
+	defer cancel()
+	return g.do(ctx, do)
+}
+
+// controlResult is the response of /api/v1/control/{action}.
+type controlResult struct {
+	APIVersion int    `json:"api_version"`
+	Model      string `json:"model"`
+	Action     string `json:"action"`
+	DryRun     bool   `json:"dry_run,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// controlHandler serves POST /api/v1/control/reboot and
+// /api/v1/control/reset, authenticated with a bearer token.  ?dry_run=true
+// checks the request without acting on it.
+type controlHandler struct {
+	m          modem.Modem
+	token      string
+	allowReset bool
+	// g is shared with remediation.
+	g *controlGuard
+}
+
+func (h *controlHandler) register(mux *http.ServeMux) {
+	mux.Handle("/api/v1/control/", h)
+}
+
+func (h *controlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "control requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if h.token == "" || token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="surfer"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	res := &controlResult{
+		APIVersion: apiVersion,
+		Model:      h.m.Name(),
+		Action:     strings.TrimPrefix(r.URL.Path, "/api/v1/control/"),
+	}
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if res.DryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid dry_run: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	glog.Infof("Control request %q from %s", res.Action, r.RemoteAddr)
+	// The modem is controlled even if the client goes away.
+	err := control(context.Background(), h.m, h.g, res.Action, h.allowReset, res.DryRun)
+	code := http.StatusOK
+	switch {
+	case err == errUnknownAction:
+		code = http.StatusNotFound
+	case err == errResetNotAllowed:
+		code = http.StatusForbidden
+	case errors.Is(err, errNotController):
+		code = http.StatusNotImplemented
+	case err == errControlBusy:
+		code = http.StatusConflict
+	case err != nil:
+		code = http.StatusBadGateway
+	}
+	if err != nil {
+		glog.Errorf("Control request %q failed: %v", res.Action, err)
+		res.Error = err.Error()
+	}
+	writeAPI(w, code, res)
+}
+
+// controlCmd implements `surfer control`, which reboots or resets the modem.
+// It returns the process exit code.
+func controlCmd(args []string) int {
+	fs := flag.NewFlagSet("control", flag.ExitOnError)
+	dryRun := fs.Bool("dry_run", false, "find the modem and check it can be controlled, without acting on it")
+	fs.BoolVar(allowReset, "allow_reset", *allowReset, "allow resetting the modem to factory defaults")
+	fs.StringVar(fakeDataPath, "fake", *fakeDataPath, "path to fake HTML data instead of fetching over HTTP")
+	fs.StringVar(model, "model", *model, "cable modem model to use instead of autodetecting it")
+	fs.DurationVar(timeout, "timeout", *timeout, "timeout for finding the cable modem")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: surfer control [flags] reboot|reset\n\n")
+		fmt.Fprintf(fs.Output(), "Reboots the modem, or with -allow_reset, resets it to factory defaults.  The\n")
+		fmt.Fprintf(fs.Output(), "connection drops while the modem restarts.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	action := fs.Arg(0)
+
+	ctx := context.Background()
+	m, err := newModem(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "control: %v\n", err)
+		return 1
+	}
+	if m == nil {
+		fmt.Fprintf(os.Stderr, "control: no modem found\n")
+		return 1
+	}
+	defer closeModem(m)
+	if err := control(ctx, m, nil, action, *allowReset, *dryRun); err != nil {
+		fmt.Fprintf(os.Stderr, "control: %s: %v\n", action, err)
+		if err == errUnknownAction || err == errResetNotAllowed {
+			return 2
+		}
+		return 1
+	}
+	if *dryRun {
+		fmt.Printf("Would send %s to %s\n", action, m.Name())
+	} else {
+		fmt.Printf("Sent %s to %s\n", action, m.Name())
+	}
+	return 0
+}
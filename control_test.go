@@ -0,0 +1,197 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// fakeController is a fakeModem that records control requests.
+type fakeController struct {
+	fakeModem
+	err     error
+	actions []string
+}
+
+func (f *fakeController) Reboot(context.Context) error {
+	f.actions = append(f.actions, "reboot")
+	return f.err
+}
+
+func (f *fakeController) ResetToDefaults(context.Context) error {
+	f.actions = append(f.actions, "reset")
+	return f.err
+}
+
+func TestControlHandler(t *testing.T) {
+	fc := &fakeController{}
+	for _, tc := range []struct {
+		name       string
+		m          modem.Modem
+		allowReset bool
+		method     string
+		path       string
+		token      string
+		// auth, if set, is the Authorization header instead of the
+		// bearer token.
+		auth       string
+		err        error
+		wantCode   int
+		wantAction []string
+	}{
+		{name: "GET", method: "GET", path: "/api/v1/control/reboot", token: "secret", wantCode: http.StatusMethodNotAllowed},
+		{name: "no token", method: "POST", path: "/api/v1/control/reboot", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", method: "POST", path: "/api/v1/control/reboot", token: "guess", wantCode: http.StatusUnauthorized},
+		{name: "no scheme", method: "POST", path: "/api/v1/control/reboot", auth: "secret", wantCode: http.StatusUnauthorized},
+		{name: "basic", method: "POST", path: "/api/v1/control/reboot", auth: "Basic secret", wantCode: http.StatusUnauthorized},
+		{name: "dry run", method: "POST", path: "/api/v1/control/reboot?dry_run=true", token: "secret", wantCode: http.StatusOK},
+		{name: "reboot", method: "POST", path: "/api/v1/control/reboot", token: "secret", wantCode: http.StatusOK, wantAction: []string{"reboot"}},
+		{name: "reset not allowed", method: "POST", path: "/api/v1/control/reset", token: "secret", wantCode: http.StatusForbidden},
+		{name: "reset", allowReset: true, method: "POST", path: "/api/v1/control/reset", token: "secret", wantCode: http.StatusOK, wantAction: []string{"reset"}},
+		{name: "unknown", method: "POST", path: "/api/v1/control/explode", token: "secret", wantCode: http.StatusNotFound},
+		{name: "modem error", method: "POST", path: "/api/v1/control/reboot", token: "secret", err: errors.New("refused"), wantCode: http.StatusBadGateway, wantAction: []string{"reboot"}},
+		{name: "not a controller", m: &fakeModem{}, method: "POST", path: "/api/v1/control/reboot", token: "secret", wantCode: http.StatusNotImplemented},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc.actions, fc.err = nil, tc.err
+			h := &controlHandler{m: fc, token: "secret", allowReset: tc.allowReset}
+			if tc.m != nil {
+				h.m = tc.m
+			}
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.wantCode {
+				t.Errorf("%s %s got %d, want %d: %s", tc.method, tc.path, w.Code, tc.wantCode, w.Body)
+			}
+			if !reflect.DeepEqual(fc.actions, tc.wantAction) {
+				t.Errorf("%s %s did %q, want %q", tc.method, tc.path, fc.actions, tc.wantAction)
+			}
+		})
+	}
+
+	// Results are reported as JSON.
+	h := &controlHandler{m: fc, token: "secret"}
+	req := httptest.NewRequest("POST", "/api/v1/control/reboot?dry_run=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var got controlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := controlResult{APIVersion: apiVersion, Model: "FAKE", Action: "reboot", DryRun: true}
+	if got != want {
+		t.Errorf("Dry run got %+v, want %+v", got, want)
+	}
+}
+
+func TestControlGuard(t *testing.T) {
+	fc := &fakeController{}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newControlGuard()
+	g.now = func() time.Time { return now }
+	h := &controlHandler{m: fc, token: "secret", g: g}
+	post := func() int {
+		req := httptest.NewRequest("POST", "/api/v1/control/reboot", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if got := post(); got != http.StatusOK {
+		t.Errorf("First reboot got %d, want %d", got, http.StatusOK)
+	}
+	// Neither the API nor remediation reboot a modem that is restarting.
+	if got := post(); got != http.StatusConflict {
+		t.Errorf("Reboot while restarting got %d, want %d", got, http.StatusConflict)
+	}
+	if err := (guardedController{fc, g}).Reboot(context.Background()); err != errControlBusy {
+		t.Errorf("Remediation reboot while restarting got %v, want %v", err, errControlBusy)
+	}
+	now = now.Add(controlRestart)
+	if got := post(); got != http.StatusOK {
+		t.Errorf("Reboot after restarting got %d, want %d", got, http.StatusOK)
+	}
+	if want := []string{"reboot", "reboot"}; !reflect.DeepEqual(fc.actions, want) {
+		t.Errorf("Did %q, want %q", fc.actions, want)
+	}
+
+	// A request in progress holds off others.
+	now = now.Add(controlRestart)
+	if err := g.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if got := post(); got != http.StatusConflict {
+		t.Errorf("Reboot during another got %d, want %d", got, http.StatusConflict)
+	}
+	// A failed request doesn't hold off the next.
+	g.release(false)
+	fc.err = errors.New("refused")
+	post()
+	fc.err = nil
+	if got := post(); got != http.StatusOK {
+		t.Errorf("Reboot after a failure got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestReadControlToken(t *testing.T) {
+	defer func(token, file string) { *controlToken, *controlTokenFile = token, file }(*controlToken, *controlTokenFile)
+	defer os.Setenv(controlTokenEnv, os.Getenv(controlTokenEnv))
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\n")
+	f.Close()
+
+	os.Setenv(controlTokenEnv, "from-env")
+	for _, tc := range []struct {
+		flag, file, want string
+	}{
+		{"", "", "from-env"},
+		{"", f.Name(), "from-file"},
+		{"from-flag", f.Name(), "from-flag"},
+	} {
+		*controlToken, *controlTokenFile = tc.flag, tc.file
+		got, err := readControlToken()
+		if err != nil || got != tc.want {
+			t.Errorf("readControlToken with -control_token=%q -control_token_file=%q got %q, %v, want %q", tc.flag, tc.file, got, err, tc.want)
+		}
+	}
+	*controlToken, *controlTokenFile = "", f.Name()+".missing"
+	if _, err := readControlToken(); err == nil {
+		t.Errorf("readControlToken of a missing file succeeded, want error")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dashboard serves a self-contained status page for the modem.  All
+// assets are embedded so the page works on the LAN while the WAN is down.
+//
+// The page reads the current channels from /api/v1/status and recent values
+// for its sparklines from a Ring served at /api/v1/recent.
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard at / and its assets under /static/.  Any
+// other path is not found.
+func Handler() http.Handler {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.FileServer(http.FS(sub))
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", files))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		b, err := static.ReadFile("static/index.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	})
+	return mux
+}
+
+type entry struct {
+	time time.Time
+	s    *modem.Signal
+	err  error
+}
+
+// Ring keeps the most recent fetches in memory.
+type Ring struct {
+	mu      sync.Mutex
+	entries []entry
+	next    int
+	full    bool
+}
+
+// NewRing returns a Ring holding the last n fetches.
+func NewRing(n int) *Ring {
+	if n < 1 {
+		n = 1
+	}
+	return &Ring{entries: make([]entry, n)}
+}
+
+// Add records the result of a fetch at t.  s is ignored if err is set.
+func (r *Ring) Add(t time.Time, s *modem.Signal, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = entry{time: t, s: s, err: err}
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// ordered returns the entries oldest first.
+func (r *Ring) ordered() []entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]entry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]entry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// Series of a single channel.  Values are aligned with Recent.Times and are
+// nil where the channel wasn't reported.
+type Series map[string][]*float64
+
+// Recent is the JSON served by Ring.
+type Recent struct {
+	// Times of each fetch in milliseconds since the epoch, oldest first.
+	Times []int64 `json:"times"`
+	// Errors holds the error of each fetch, empty if it succeeded.
+	Errors     []string                 `json:"errors"`
+	Downstream map[modem.Channel]Series `json:"downstream"`
+	Upstream   map[modem.Channel]Series `json:"upstream"`
+}
+
+// Recent returns the fetches in r as series per channel.
+func (r *Ring) Recent() *Recent {
+	es := r.ordered()
+	rec := &Recent{
+		Times:      []int64{},
+		Errors:     []string{},
+		Downstream: map[modem.Channel]Series{},
+		Upstream:   map[modem.Channel]Series{},
+	}
+	set := func(chs map[modem.Channel]Series, ch modem.Channel, field string, i int, v float64) {
+		ser, ok := chs[ch]
+		if !ok {
+			ser = Series{}
+			chs[ch] = ser
+		}
+		vs, ok := ser[field]
+		if !ok {
+			vs = make([]*float64, len(es))
+			ser[field] = vs
+		}
+		vs[i] = &v
+	}
+	for i, e := range es {
+		rec.Times = append(rec.Times, e.time.UnixNano()/int64(time.Millisecond))
+		if e.err != nil {
+			rec.Errors = append(rec.Errors, e.err.Error())
+			continue
+		}
+		rec.Errors = append(rec.Errors, "")
+		for ch, d := range e.s.Downstream {
+			set(rec.Downstream, ch, "snr", i, d.SNR)
+			set(rec.Downstream, ch, "power", i, d.PowerLevel)
+			set(rec.Downstream, ch, "correctable", i, d.Correctable)
+			set(rec.Downstream, ch, "uncorrectable", i, d.Uncorrectable)
+		}
+		for ch, u := range e.s.Upstream {
+			set(rec.Upstream, ch, "power", i, u.PowerLevel)
+		}
+	}
+	return rec
+}
+
+// ServeHTTP writes Recent as JSON.
+func (r *Ring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Recent())
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler()
+	for _, tc := range []struct {
+		path, contains string
+		code           int
+	}{
+		{"/", "<title>surfer</title>", http.StatusOK},
+		{"/static/dashboard.js", "function sparkline", http.StatusOK},
+		{"/static/dashboard.css", ".marginal", http.StatusOK},
+		{"/nope", "", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s got status %d, want %d", tc.path, w.Code, tc.code)
+		}
+		if !strings.Contains(w.Body.String(), tc.contains) {
+			t.Errorf("%s doesn't contain %q", tc.path, tc.contains)
+		}
+	}
+}
+
+func signal(snr float64, channels ...modem.Channel) *modem.Signal {
+	s := &modem.Signal{Downstream: map[modem.Channel]*modem.Downstream{}, Upstream: map[modem.Channel]*modem.Upstream{}}
+	for _, ch := range channels {
+		s.Downstream[ch] = &modem.Downstream{SNR: snr}
+	}
+	return s
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.Add(start, signal(30, "1", "2"), nil)
+	r.Add(start.Add(time.Minute), nil, errors.New("timeout"))
+	r.Add(start.Add(2*time.Minute), signal(32, "1"), nil)
+	r.Add(start.Add(3*time.Minute), signal(33, "1", "2"), nil)
+
+	rec := r.Recent()
+	// The first fetch was overwritten.
+	if len(rec.Times) != 3 || rec.Times[0] != start.Add(time.Minute).UnixNano()/1e6 {
+		t.Fatalf("Got times %v", rec.Times)
+	}
+	if rec.Errors[0] != "timeout" || rec.Errors[1] != "" {
+		t.Errorf("Got errors %q", rec.Errors)
+	}
+	snr := rec.Downstream["1"]["snr"]
+	if snr[0] != nil || *snr[1] != 32 || *snr[2] != 33 {
+		t.Errorf("Channel 1 SNR got %v", snr)
+	}
+	snr = rec.Downstream["2"]["snr"]
+	if snr[0] != nil || snr[1] != nil || *snr[2] != 33 {
+		t.Errorf("Channel 2 SNR got %v", snr)
+	}
+}
@@ -0,0 +1,161 @@
+// Renders /api/v1/status and /api/v1/recent.  No dependencies so the page
+// works without internet access.
+'use strict';
+
+const refreshMillis = 10000;
+
+function el(tag, text, cls) {
+  const e = document.createElement(tag);
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  if (cls) {
+    e.className = cls;
+  }
+  return e;
+}
+
+// sparkline returns an SVG polyline of the non-null values.
+function sparkline(values) {
+  const ns = 'http://www.w3.org/2000/svg';
+  const w = 100, h = 20;
+  const svg = document.createElementNS(ns, 'svg');
+  svg.setAttribute('width', w);
+  svg.setAttribute('height', h);
+  const pts = (values || []).map((v, i) => [i, v]).filter((p) => p[1] !== null);
+  if (pts.length < 2) {
+    return svg;
+  }
+  const ys = pts.map((p) => p[1]);
+  const min = Math.min(...ys), max = Math.max(...ys);
+  const n = values.length - 1;
+  const line = document.createElementNS(ns, 'polyline');
+  line.setAttribute('points', pts.map((p) => {
+    const x = (p[0] / n) * (w - 2) + 1;
+    const y = max === min ? h / 2 : h - 1 - ((p[1] - min) / (max - min)) * (h - 2);
+    return x.toFixed(1) + ',' + y.toFixed(1);
+  }).join(' '));
+  svg.appendChild(line);
+  const title = document.createElementNS(ns, 'title');
+  title.textContent = 'min ' + min + ', max ' + max;
+  svg.appendChild(title);
+  return svg;
+}
+
+// deltas turns counter values into the increase since the previous value,
+// treating a decrease as a counter reset.
+function deltas(values) {
+  if (!values) {
+    return [];
+  }
+  let prev = null;
+  return values.map((v) => {
+    if (v === null) {
+      return null;
+    }
+    const d = prev === null ? null : (v >= prev ? v - prev : v);
+    prev = v;
+    return d;
+  });
+}
+
+function sum(a, b) {
+  return a.map((v, i) => (v === null && b[i] === null) ? null : (v || 0) + (b[i] || 0));
+}
+
+function cell(row, text, outOfSpec, field) {
+  const td = el('td', text);
+  if (outOfSpec && outOfSpec.includes(field)) {
+    td.className = 'oos';
+  }
+  row.appendChild(td);
+}
+
+function sparkCell(row, values) {
+  const td = el('td', undefined, 'spark');
+  td.appendChild(sparkline(values));
+  row.appendChild(td);
+}
+
+function renderDownstream(channels, recent) {
+  const body = document.querySelector('#downstream tbody');
+  body.replaceChildren();
+  for (const d of channels || []) {
+    const r = el('tr', undefined, d.quality);
+    const ser = recent.downstream[d.channel] || {};
+    cell(r, d.channel);
+    cell(r, d.frequency);
+    cell(r, d.modulation);
+    cell(r, d.power_dbmv, d.out_of_spec, 'power');
+    sparkCell(r, ser.power);
+    cell(r, d.snr_db, d.out_of_spec, 'snr');
+    sparkCell(r, ser.snr);
+    cell(r, d.correctable);
+    cell(r, d.uncorrectable, d.out_of_spec, 'codeword_error_ratio');
+    sparkCell(r, sum(deltas(ser.correctable), deltas(ser.uncorrectable)));
+    body.appendChild(r);
+  }
+}
+
+function renderUpstream(channels, recent) {
+  const body = document.querySelector('#upstream tbody');
+  body.replaceChildren();
+  for (const u of channels || []) {
+    const r = el('tr', undefined, u.quality);
+    const ser = recent.upstream[u.channel] || {};
+    cell(r, u.channel);
+    cell(r, u.frequency);
+    cell(r, u.modulation);
+    cell(r, u.status);
+    cell(r, u.symbol_rate);
+    cell(r, u.power_dbmv, u.out_of_spec, 'power');
+    sparkCell(r, ser.power);
+    body.appendChild(r);
+  }
+}
+
+function text(id, t, cls) {
+  const e = document.getElementById(id);
+  e.textContent = t;
+  e.className = cls || '';
+}
+
+function renderInfo(st, recent) {
+  text('model', st.model || '');
+  const score = st.health_score;
+  if (score === undefined) {
+    text('health', 'unreachable', 'bad');
+    text('score', '-');
+  } else {
+    const level = score >= 90 ? 'good' : (score >= 60 ? 'marginal' : 'bad');
+    text('health', Math.round(score) + '/100', level);
+    text('score', score.toFixed(1));
+  }
+  text('profile', st.quality_profile || '-');
+  text('fetched', st.fetched_at ? new Date(st.fetched_at).toLocaleString() : 'never');
+  text('duration', st.fetch_duration_seconds ? (st.fetch_duration_seconds * 1000).toFixed(0) + ' ms' : '-');
+  const failed = recent.errors.filter((e) => e !== '').length;
+  text('fetches', (recent.errors.length - failed) + ' of ' + recent.errors.length + ' succeeded', failed ? 'error' : '');
+  if (st.last_error) {
+    text('error', st.last_error + ' at ' + new Date(st.last_error_at).toLocaleString(), 'error');
+  } else {
+    text('error', 'none');
+  }
+}
+
+async function refresh() {
+  try {
+    const [st, recent] = await Promise.all([
+      fetch('/api/v1/status').then((r) => r.json()),
+      fetch('/api/v1/recent').then((r) => r.json()),
+    ]);
+    renderInfo(st, recent);
+    renderDownstream(st.downstream, recent);
+    renderUpstream(st.upstream, recent);
+  } catch (e) {
+    text('health', 'surfer unreachable', 'bad');
+  }
+}
+
+refresh();
+setInterval(refresh, refreshMillis);
@@ -0,0 +1,219 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventlog forwards new entries of the modem's event log to syslog
+// and Loki.
+package eventlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Sink receives new events.
+type Sink interface {
+	// Name identifies the sink, and so what was forwarded to it, in the
+	// state file.
+	Name() string
+	// Send forwards events, oldest first, and returns how many of them
+	// were sent before any error.  Events without a time are sent as
+	// fetched at fetched.
+	Send(events []modem.Event, fetched time.Time) (int, error)
+	Close() error
+}
+
+// Forwarder fetches the event log whenever polled and sends the events each
+// sink hasn't seen yet.  The log each sink was last sent is kept in a state
+// file, so restarting doesn't send anything twice.
+type Forwarder struct {
+	l         modem.EventLogger
+	sinks     []Sink
+	stateFile string
+	timeout   time.Duration
+	now       func() time.Time
+
+	// state maps sink names to the keys of the log last sent to them.  It
+	// is only used by the forwarding goroutine once started.
+	state map[string][]string
+
+	trigger chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// New returns a Forwarder fetching from l with timeout and sending to sinks.
+// If stateFile is empty what was sent is only remembered until exit.
+func New(l modem.EventLogger, stateFile string, timeout time.Duration, sinks ...Sink) (*Forwarder, error) {
+	f := &Forwarder{
+		l:         l,
+		sinks:     sinks,
+		stateFile: stateFile,
+		timeout:   timeout,
+		now:       time.Now,
+		state:     map[string][]string{},
+		trigger:   make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if stateFile != "" {
+		b, err := ioutil.ReadFile(stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &f.state); err != nil {
+				return nil, fmt.Errorf("eventlog: corrupt state file %s: %v", stateFile, err)
+			}
+		}
+	}
+	go f.loop()
+	return f, nil
+}
+
+// Poll asks for the event log to be fetched and forwarded.  It never blocks;
+// polls while a fetch is in progress are coalesced.
+func (f *Forwarder) Poll() {
+	select {
+	case f.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops forwarding and closes the sinks.
+func (f *Forwarder) Close() error {
+	close(f.quit)
+	<-f.done
+	var err error
+	for _, s := range f.sinks {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (f *Forwarder) loop() {
+	defer close(f.done)
+	for {
+		select {
+		case <-f.quit:
+			return
+		case <-f.trigger:
+		}
+		if err := f.forward(); err != nil {
+			glog.Errorf("Failed to forward event log: %v", err)
+		}
+	}
+}
+
+// forward fetches the event log and sends the new events to every sink.  A
+// sink that fails is sent the new events it didn't get again on the next
+// forward.
+func (f *Forwarder) forward() error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	events, err := f.l.Events(ctx)
+	if err != nil {
+		return err
+	}
+	fetched := f.now()
+	keys := make([]string, len(events))
+	for i, e := range events {
+		keys[i] = key(e)
+	}
+	changed := false
+	for _, s := range f.sinks {
+		prev, ok := f.state[s.Name()]
+		n := newSince(prev, keys)
+		if n == 0 && ok && len(prev) == len(keys) {
+			continue
+		}
+		sent := keys
+		if n > 0 {
+			m, err := s.Send(events[len(events)-n:], fetched)
+			if err != nil {
+				glog.Errorf("Failed to forward %d of %d events to %s: %v", n-m, n, s.Name(), err)
+				if m == 0 {
+					continue
+				}
+				// The log up to the last event sent is what was sent,
+				// so the next forward picks up after it.
+				sent = keys[:len(keys)-n+m]
+			}
+		}
+		f.state[s.Name()] = sent
+		changed = true
+	}
+	if changed {
+		return f.save()
+	}
+	return nil
+}
+
+// key identifies an event in the log.
+func key(e modem.Event) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s", e.Time.Unix(), e.Priority, e.ID, e.Text)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// newSince returns how many events at the end of the log with keys cur were
+// added since the log with keys prev.  Modems keep a fixed number of events,
+// so events are added at the end and drop off the front: the new events
+// follow the longest suffix of prev that cur starts with.  If there is none,
+// such as after the log was cleared, every event is new.
+func newSince(prev, cur []string) int {
+	for d := 0; d < len(prev); d++ {
+		overlap := prev[d:]
+		if len(overlap) > len(cur) {
+			continue
+		}
+		match := true
+		for i := range overlap {
+			if overlap[i] != cur[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return len(cur) - len(overlap)
+		}
+	}
+	return len(cur)
+}
+
+func (f *Forwarder) save() error {
+	if f.stateFile == "" {
+		return nil
+	}
+	b, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(f.stateFile), "."+filepath.Base(f.stateFile)+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.stateFile)
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+type fakeLogger struct {
+	events []modem.Event
+}
+
+func (l *fakeLogger) Events(context.Context) ([]modem.Event, error) {
+	return l.events, nil
+}
+
+type fakeSink struct {
+	name string
+	err  error
+	// sends is how many events are sent before err, if set.
+	sends int
+	sent  []string
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Send(events []modem.Event, fetched time.Time) (int, error) {
+	for i, e := range events {
+		if s.err != nil && i == s.sends {
+			return i, s.err
+		}
+		s.sent = append(s.sent, e.Text)
+	}
+	return len(events), nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func events(texts ...string) []modem.Event {
+	var es []modem.Event
+	for _, t := range texts {
+		es = append(es, modem.Event{Priority: modem.PriorityCritical, Text: t})
+	}
+	return es
+}
+
+func TestNewSince(t *testing.T) {
+	for _, tc := range []struct {
+		prev, cur string
+		want      int
+	}{
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"abc", "abcd", 1},
+		{"abc", "bcde", 2},
+		{"abc", "cde", 2},
+		{"abc", "xyz", 3},
+		{"abc", "", 0},
+		// Repeated events are told apart by their position.
+		{"aaa", "aaaa", 1},
+		{"aab", "abab", 2},
+	} {
+		split := func(s string) []string {
+			var ks []string
+			for _, c := range s {
+				ks = append(ks, string(c))
+			}
+			return ks
+		}
+		if got := newSince(split(tc.prev), split(tc.cur)); got != tc.want {
+			t.Errorf("newSince(%q, %q) got %d, want %d", tc.prev, tc.cur, got, tc.want)
+		}
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+
+	l := &fakeLogger{events: events("a", "b")}
+	good := &fakeSink{name: "good"}
+	bad := &fakeSink{name: "bad", err: errors.New("unreachable")}
+	f, err := New(l, stateFile, time.Second, good, bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(s *fakeSink, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(s.sent, want) {
+			t.Errorf("%s sent %q, want %q", s.name, s.sent, want)
+		}
+	}
+
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(good, "a", "b")
+	check(bad)
+
+	// Unchanged, so nothing is sent again.
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(good, "a", "b")
+
+	// Once it recovers, the sink that failed gets everything it missed that
+	// is still in the log.
+	l.events = events("b", "c", "d")
+	bad.err = nil
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(good, "a", "b", "c", "d")
+	check(bad, "b", "c", "d")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart picks up where the last run left off.
+	l.events = events("b", "c", "d", "e")
+	good.sent, bad.sent = nil, nil
+	f, err = New(l, stateFile, time.Second, good, bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(good, "e")
+	check(bad, "e")
+
+	// A sink that fails part way through is only sent the events it
+	// didn't get.
+	l.events = events("d", "e", "f", "g", "h")
+	bad.err, bad.sends = errors.New("connection reset"), 1
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(bad, "e", "f")
+	bad.err = nil
+	if err := f.forward(); err != nil {
+		t.Fatal(err)
+	}
+	check(bad, "e", "f", "g", "h")
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// LokiOptions configures a Loki sink.
+type LokiOptions struct {
+	// URL is the Loki server, e.g. http://loki:3100.  The
+	// /loki/api/v1/push path is added unless it is already there.
+	URL string
+	// Username and Password set basic auth.
+	Username, Password string
+	// Tenant, if set, is sent as X-Scope-OrgID.
+	Tenant string
+	// Labels are added to every stream, typically job, model and site.
+	// Each event is also labeled with its priority as level.
+	Labels map[string]string
+	// Timeout bounds each request.  Zero defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Loki sends events to the Loki push API, one stream per priority.
+type Loki struct {
+	opts   LokiOptions
+	url    string
+	client *http.Client
+}
+
+// NewLoki returns a sink pushing to opts.URL.
+func NewLoki(opts LokiOptions) (*Loki, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("eventlog: no Loki URL")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	u := strings.TrimSuffix(opts.URL, "/")
+	if !strings.HasSuffix(u, "/loki/api/v1/push") {
+		u += "/loki/api/v1/push"
+	}
+	return &Loki{opts: opts, url: u, client: &http.Client{Timeout: opts.Timeout}}, nil
+}
+
+// Name implements Sink.
+func (l *Loki) Name() string {
+	return "loki " + l.url
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Send implements Sink.  Events are pushed in a single request, so either all
+// or none of them are sent.
+func (l *Loki) Send(events []modem.Event, fetched time.Time) (int, error) {
+	if err := l.push(events, fetched); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// push sends events to Loki.  Requests Loki rejects, such as for entries too
+// old to ingest, are logged and dropped rather than retried.
+func (l *Loki) push(events []modem.Event, fetched time.Time) error {
+	var req lokiPush
+	streams := map[modem.Priority]int{}
+	for _, e := range events {
+		i, ok := streams[e.Priority]
+		if !ok {
+			labels := map[string]string{"level": e.Priority.String()}
+			for k, v := range l.opts.Labels {
+				if v != "" {
+					labels[k] = v
+				}
+			}
+			i = len(req.Streams)
+			streams[e.Priority] = i
+			req.Streams = append(req.Streams, lokiStream{Stream: labels})
+		}
+		t := e.Time
+		if t.IsZero() {
+			t = fetched
+		}
+		line := e.Text
+		if e.ID != "" {
+			line = e.ID + " " + line
+		}
+		req.Streams[i].Values = append(req.Streams[i].Values, [2]string{strconv.FormatInt(t.UnixNano(), 10), line})
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequest("POST", l.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	hr.Header.Set("User-Agent", "surfer")
+	if l.opts.Username != "" {
+		hr.SetBasicAuth(l.opts.Username, l.opts.Password)
+	}
+	if l.opts.Tenant != "" {
+		hr.Header.Set("X-Scope-OrgID", l.opts.Tenant)
+	}
+	resp, err := l.client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			glog.Errorf("Dropping %d events rejected by %s: %v", len(events), l.url, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (l *Loki) Close() error { return nil }
@@ -0,0 +1,245 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+var (
+	testTime   = time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)
+	testEvents = []modem.Event{
+		{Priority: modem.PriorityCritical, ID: "82000400", Text: `Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out;CM-MAC=00:00:00:00:00:00;CMTS-MAC=00:00:00:00:00:00;CM-QOS=1.1;CM-VER=3.1;`},
+		{Time: testTime, Priority: modem.PriorityNotice, Text: `Honoring MDD; IP provisioning mode = IPv6 "quoted"]`},
+	}
+	wantSyslog = []string{
+		`<130>1 2020-03-14T16:09:26.000000Z host surfer - 82000400 [surfer@32473 model="SB8200" site="home" priority="critical"] Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out;CM-MAC=00:00:00:00:00:00;CMTS-MAC=00:00:00:00:00:00;CM-QOS=1.1;CM-VER=3.1;`,
+		`<133>1 2020-03-14T15:09:26.000000Z host surfer - - [surfer@32473 model="SB8200" site="home" priority="notice"] Honoring MDD; IP provisioning mode = IPv6 "quoted"]`,
+	}
+)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := NewSyslog(SyslogOptions{Addr: "udp://" + pc.LocalAddr().String(), Facility: 16, Hostname: "host", Model: "SB8200", Site: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n, err := s.Send(testEvents, testTime.Add(time.Hour)); err != nil || n != len(testEvents) {
+		t.Fatalf("Send got %d, %v, want %d, nil", n, err, len(testEvents))
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got []string
+	buf := make([]byte, 2048)
+	for range wantSyslog {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if !reflect.DeepEqual(got, wantSyslog) {
+		t.Errorf("UDP syslog got\n%q\nwant\n%q", got, wantSyslog)
+	}
+}
+
+// readFramed reads n octet counted messages from c.
+func readFramed(c net.Conn, n int) ([]string, error) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+	var msgs []string
+	for i := 0; i < n; i++ {
+		l, err := r.ReadString(' ')
+		if err != nil {
+			return msgs, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(l))
+		if err != nil {
+			return msgs, err
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, string(b))
+	}
+	return msgs, nil
+}
+
+func testSyslogStream(t *testing.T, l net.Listener, opts SyslogOptions) {
+	t.Helper()
+	got := make(chan []string, 1)
+	errc := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer c.Close()
+		msgs, err := readFramed(c, len(wantSyslog))
+		if err != nil {
+			errc <- err
+			return
+		}
+		got <- msgs
+	}()
+	s, err := NewSyslog(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n, err := s.Send(testEvents, testTime.Add(time.Hour)); err != nil || n != len(testEvents) {
+		t.Fatalf("Send got %d, %v, want %d, nil", n, err, len(testEvents))
+	}
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	case msgs := <-got:
+		if !reflect.DeepEqual(msgs, wantSyslog) {
+			t.Errorf("%s syslog got\n%q\nwant\n%q", opts.Addr, msgs, wantSyslog)
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	testSyslogStream(t, l, SyslogOptions{Addr: "tcp://" + l.Addr().String(), Facility: 16, Hostname: "host", Model: "SB8200", Site: "home"})
+}
+
+func TestSyslogTLS(t *testing.T) {
+	// Borrow httptest's self-signed certificate.
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", ts.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tc := ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tc.ServerName = "example.com"
+	testSyslogStream(t, l, SyslogOptions{Addr: "tls://" + l.Addr().String(), TLSConfig: tc, Facility: 16, Hostname: "host", Model: "SB8200", Site: "home"})
+}
+
+func TestNewSyslog(t *testing.T) {
+	for addr, want := range map[string]string{
+		"udp://logs":       "syslog udp://logs:514",
+		"tcp://logs":       "syslog tcp://logs:601",
+		"tls://logs":       "syslog tls://logs:6514",
+		"tls://logs:10514": "syslog tls://logs:10514",
+		"logs:514":         "",
+		"http://logs":      "",
+	} {
+		s, err := NewSyslog(SyslogOptions{Addr: addr})
+		if want == "" {
+			if err == nil {
+				t.Errorf("NewSyslog(%q) succeeded, want error", addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewSyslog(%q): %v", addr, err)
+			continue
+		}
+		if got := s.Name(); got != want {
+			t.Errorf("NewSyslog(%q) got %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestLoki(t *testing.T) {
+	var got lokiPush
+	var header http.Header
+	status := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			http.NotFound(w, r)
+			return
+		}
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	l, err := NewLoki(LokiOptions{
+		URL:      ts.URL + "/",
+		Username: "user",
+		Password: "pass",
+		Tenant:   "tenant",
+		Labels:   map[string]string{"job": "surfer", "model": "SB8200", "site": "home"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := testTime.Add(time.Hour)
+	if _, err := l.Send(testEvents, fetched); err != nil {
+		t.Fatal(err)
+	}
+	want := lokiPush{Streams: []lokiStream{
+		{
+			Stream: map[string]string{"job": "surfer", "model": "SB8200", "site": "home", "level": "critical"},
+			Values: [][2]string{{fmt.Sprint(fetched.UnixNano()), "82000400 " + testEvents[0].Text}},
+		},
+		{
+			Stream: map[string]string{"job": "surfer", "model": "SB8200", "site": "home", "level": "notice"},
+			Values: [][2]string{{fmt.Sprint(testTime.UnixNano()), testEvents[1].Text}},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Loki push got\n%+v\nwant\n%+v", got, want)
+	}
+	if u, p, _ := (&http.Request{Header: header}).BasicAuth(); u != "user" || p != "pass" {
+		t.Errorf("Loki basic auth got %q:%q, want user:pass", u, p)
+	}
+	if got, want := header.Get("X-Scope-OrgID"), "tenant"; got != want {
+		t.Errorf("X-Scope-OrgID got %q, want %q", got, want)
+	}
+
+	// Rejected pushes are dropped, server errors are retried.
+	status = http.StatusBadRequest
+	if _, err := l.Send(testEvents, fetched); err != nil {
+		t.Errorf("Send rejected got %v, want nil", err)
+	}
+	status = http.StatusServiceUnavailable
+	if _, err := l.Send(testEvents, fetched); err == nil {
+		t.Errorf("Send to unavailable Loki succeeded, want error")
+	}
+}
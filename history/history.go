@@ -0,0 +1,479 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps a local record of modem signal data, for deployments
+// without a Prometheus server that still want to know when a problem
+// started.
+//
+// Samples are appended as JSON lines to one file per UTC day.  Once a day is
+// older than the raw retention its file is rewritten with one averaged sample
+// per resolution interval, and days older than the retention are deleted.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Directions.
+const (
+	Downstream = "downstream"
+	Upstream   = "upstream"
+)
+
+// Fields that can be queried.  Downstream channels have every field but
+// SymbolRate, upstream channels only Power and SymbolRate.
+const (
+	Power         = "power"
+	SNR           = "snr"
+	Unerrored     = "unerrored"
+	Correctable   = "correctable"
+	Uncorrectable = "uncorrectable"
+	SymbolRate    = "symbol_rate"
+)
+
+// counters are downsampled by keeping the last value instead of the mean.
+var counters = map[string]bool{
+	Unerrored:     true,
+	Correctable:   true,
+	Uncorrectable: true,
+}
+
+const (
+	dayFormat         = "2006-01-02"
+	rawSuffix         = ".jsonl"
+	downsampledSuffix = ".downsampled.jsonl"
+)
+
+type values map[string]float64
+
+// sample is a single line of a day file.
+type sample struct {
+	Time       int64                    `json:"t"`
+	Downstream map[modem.Channel]values `json:"ds,omitempty"`
+	Upstream   map[modem.Channel]values `json:"us,omitempty"`
+}
+
+func (s *sample) channels(direction string) map[modem.Channel]values {
+	if direction == Upstream {
+		return s.Upstream
+	}
+	return s.Downstream
+}
+
+func newSample(t time.Time, sig *modem.Signal) *sample {
+	s := &sample{
+		Time:       t.Unix(),
+		Downstream: map[modem.Channel]values{},
+		Upstream:   map[modem.Channel]values{},
+	}
+	for ch, d := range sig.Downstream {
+		s.Downstream[ch] = values{
+			Power:         d.PowerLevel,
+			SNR:           d.SNR,
+			Unerrored:     d.Unerrored,
+			Correctable:   d.Correctable,
+			Uncorrectable: d.Uncorrectable,
+		}
+	}
+	for ch, u := range sig.Upstream {
+		s.Upstream[ch] = values{
+			Power:      u.PowerLevel,
+			SymbolRate: u.SymbolRate,
+		}
+	}
+	return s
+}
+
+// Options configures a Store.
+type Options struct {
+	// Retention is how long samples are kept.
+	Retention time.Duration
+	// RawRetention is how long every sample is kept before being downsampled.
+	RawRetention time.Duration
+	// Resolution is the interval samples are averaged over when downsampling.
+	// Less than a second disables downsampling.
+	Resolution time.Duration
+}
+
+// DefaultOptions keeps a week of history, with full resolution for the last
+// day.
+var DefaultOptions = Options{
+	Retention:    7 * 24 * time.Hour,
+	RawRetention: 24 * time.Hour,
+	Resolution:   5 * time.Minute,
+}
+
+// Store is a directory of day files.
+type Store struct {
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	mu  sync.Mutex
+	f   *os.File
+	day string
+}
+
+// Open returns a Store writing to dir, creating it if needed, and compacts
+// any files already there.
+func Open(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, opts: opts, now: time.Now}
+	if err := s.Compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the current day file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Add appends sig, fetched at t, to the store.
+func (s *Store) Add(t time.Time, sig *modem.Signal) error {
+	b, err := json.Marshal(newSample(t, sig))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	day := t.UTC().Format(dayFormat)
+	rolled := false
+	if s.f == nil || day != s.day {
+		if s.f != nil {
+			s.f.Close()
+			rolled = true
+		}
+		s.f, err = os.OpenFile(filepath.Join(s.dir, day+rawSuffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			s.f = nil
+			s.mu.Unlock()
+			return err
+		}
+		s.day = day
+	}
+	_, err = s.f.Write(b)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if rolled {
+		return s.Compact()
+	}
+	return nil
+}
+
+// dayFile is a file in the store directory.
+type dayFile struct {
+	day         time.Time
+	path        string
+	downsampled bool
+}
+
+func (s *Store) files() ([]dayFile, error) {
+	fis, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []dayFile
+	for _, fi := range fis {
+		name := fi.Name()
+		f := dayFile{path: filepath.Join(s.dir, name)}
+		switch {
+		case strings.HasSuffix(name, downsampledSuffix):
+			name = strings.TrimSuffix(name, downsampledSuffix)
+			f.downsampled = true
+		case strings.HasSuffix(name, rawSuffix):
+			name = strings.TrimSuffix(name, rawSuffix)
+		default:
+			continue
+		}
+		if f.day, err = time.Parse(dayFormat, name); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].day.Before(files[j].day) })
+	return files, nil
+}
+
+// Compact deletes days older than the retention and downsamples days older
+// than the raw retention.  It is called by Open and whenever Add starts a new
+// day.
+func (s *Store) Compact() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	for _, f := range files {
+		end := f.day.Add(24 * time.Hour)
+		switch {
+		case s.opts.Retention > 0 && now.Sub(end) > s.opts.Retention:
+			glog.V(1).Infof("Removing expired history %q", f.path)
+			if err := os.Remove(f.path); err != nil {
+				return err
+			}
+		case !f.downsampled && s.opts.Resolution >= time.Second && now.Sub(end) > s.opts.RawRetention:
+			if err := s.downsample(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// downsample replaces the raw file f with one sample per resolution interval.
+func (s *Store) downsample(f dayFile) error {
+	type acc struct {
+		sum map[string]float64
+		n   map[string]int
+	}
+	type bucket map[string]map[modem.Channel]*acc
+	buckets := map[int64]bucket{}
+	res := int64(s.opts.Resolution / time.Second)
+	err := readFile(f.path, func(smp *sample) {
+		t := smp.Time - smp.Time%res
+		b, ok := buckets[t]
+		if !ok {
+			b = bucket{Downstream: {}, Upstream: {}}
+			buckets[t] = b
+		}
+		for _, dir := range []string{Downstream, Upstream} {
+			for ch, vs := range smp.channels(dir) {
+				a, ok := b[dir][ch]
+				if !ok {
+					a = &acc{sum: map[string]float64{}, n: map[string]int{}}
+					b[dir][ch] = a
+				}
+				for k, v := range vs {
+					if counters[k] {
+						a.sum[k], a.n[k] = v, 1
+						continue
+					}
+					a.sum[k] += v
+					a.n[k]++
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var times []int64
+	for t := range buckets {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	out := filepath.Join(s.dir, f.day.Format(dayFormat)+downsampledSuffix)
+	tmp := out + ".tmp"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, t := range times {
+		smp := &sample{Time: t, Downstream: map[modem.Channel]values{}, Upstream: map[modem.Channel]values{}}
+		for dir, chs := range buckets[t] {
+			for ch, a := range chs {
+				vs := values{}
+				for k, sum := range a.sum {
+					vs[k] = sum / float64(a.n[k])
+				}
+				smp.channels(dir)[ch] = vs
+			}
+		}
+		if err := enc.Encode(smp); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return err
+	}
+	glog.V(1).Infof("Downsampled %q to %d samples", f.path, len(times))
+	return os.Remove(f.path)
+}
+
+// readFile calls fn with every sample in path.  Lines that can't be decoded,
+// such as one cut short by a crash, are skipped.
+func readFile(path string, fn func(*sample)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		smp := &sample{}
+		if err := json.Unmarshal(sc.Bytes(), smp); err != nil {
+			glog.Warningf("Skipping bad line in %q: %v", path, err)
+			continue
+		}
+		fn(smp)
+	}
+	return sc.Err()
+}
+
+// Point is a single value in a query result.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Query selects one field of one channel over a time range.
+type Query struct {
+	Direction string
+	Channel   modem.Channel
+	Field     string
+	// Start and End are inclusive.
+	Start, End time.Time
+}
+
+func (q Query) validate() error {
+	switch q.Direction {
+	case Downstream:
+		switch q.Field {
+		case Power, SNR, Unerrored, Correctable, Uncorrectable:
+		default:
+			return fmt.Errorf("unknown downstream field %q", q.Field)
+		}
+	case Upstream:
+		switch q.Field {
+		case Power, SymbolRate:
+		default:
+			return fmt.Errorf("unknown upstream field %q", q.Field)
+		}
+	default:
+		return fmt.Errorf("unknown direction %q", q.Direction)
+	}
+	if q.Channel == "" {
+		return fmt.Errorf("missing channel")
+	}
+	if q.End.Before(q.Start) {
+		return fmt.Errorf("end is before start")
+	}
+	return nil
+}
+
+// Query returns the points matching q in time order.
+func (s *Store) Query(q Query) ([]Point, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	start, end := q.Start.Unix(), q.End.Unix()
+	first := q.Start.UTC().Truncate(24 * time.Hour)
+	points := []Point{}
+	for _, f := range files {
+		if f.day.Before(first) || f.day.After(q.End) {
+			continue
+		}
+		err := readFile(f.path, func(smp *sample) {
+			if smp.Time < start || smp.Time > end {
+				return
+			}
+			if v, ok := smp.channels(q.Direction)[q.Channel][q.Field]; ok {
+				points = append(points, Point{Time: time.Unix(smp.Time, 0).UTC(), Value: v})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+// ServeHTTP answers queries of the form
+//
+//	?direction=downstream&channel=5&field=snr&start=2020-01-01T00:00:00Z&end=2020-01-02T00:00:00Z
+//
+// with a JSON array of points.  direction defaults to downstream, end to now
+// and start to a day before end.  start and end are RFC 3339 times.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q := Query{
+		Direction: v.Get("direction"),
+		Channel:   modem.Channel(v.Get("channel")),
+		Field:     v.Get("field"),
+		End:       s.now(),
+	}
+	if q.Direction == "" {
+		q.Direction = Downstream
+	}
+	var err error
+	if e := v.Get("end"); e != "" {
+		if q.End, err = time.Parse(time.RFC3339, e); err != nil {
+			http.Error(w, fmt.Sprintf("invalid end: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	q.Start = q.End.Add(-24 * time.Hour)
+	if st := v.Get("start"); st != "" {
+		if q.Start, err = time.Parse(time.RFC3339, st); err != nil {
+			http.Error(w, fmt.Sprintf("invalid start: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := q.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	points, err := s.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func signal(snr, uncorrectable float64) *modem.Signal {
+	return &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {SNR: snr, PowerLevel: 1, Uncorrectable: uncorrectable},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {PowerLevel: 40, SymbolRate: 5120},
+		},
+	}
+}
+
+func open(t *testing.T, now *time.Time) (*Store, string) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir, Options{Retention: 3 * 24 * time.Hour, RawRetention: 24 * time.Hour, Resolution: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	return s, dir
+}
+
+func pointValues(ps []Point) []float64 {
+	var vs []float64
+	for _, p := range ps {
+		vs = append(vs, p.Value)
+	}
+	return vs
+}
+
+func TestAddQuery(t *testing.T) {
+	now := start
+	s, dir := open(t, &now)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := s.Add(start.Add(time.Duration(i)*time.Minute), signal(float64(30+i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Query(Query{Direction: Downstream, Channel: "1", Field: SNR, Start: start.Add(time.Minute), End: start.Add(3 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{31, 32, 33}; !reflect.DeepEqual(pointValues(got), want) {
+		t.Errorf("Query got %v, want %v", pointValues(got), want)
+	}
+	if !got[0].Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("First point at %v, want %v", got[0].Time, start.Add(time.Minute))
+	}
+
+	got, err = s.Query(Query{Direction: Upstream, Channel: "1", Field: SymbolRate, Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 5 || got[0].Value != 5120 {
+		t.Errorf("Upstream query got %v", got)
+	}
+
+	for _, bad := range []Query{
+		{Direction: Upstream, Channel: "1", Field: SNR},
+		{Direction: "sideways", Channel: "1", Field: SNR},
+		{Direction: Downstream, Field: SNR},
+		{Direction: Downstream, Channel: "1", Field: SNR, Start: start.Add(time.Hour), End: start},
+	} {
+		if _, err := s.Query(bad); err == nil {
+			t.Errorf("Query(%+v) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	now := start
+	s, dir := open(t, &now)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	// Four days of a sample a minute for the first ten minutes of each day.
+	for day := 0; day < 4; day++ {
+		for i := 0; i < 10; i++ {
+			ts := start.Add(time.Duration(day)*24*time.Hour + time.Duration(i)*time.Minute)
+			now = ts
+			if err := s.Add(ts, signal(float64(30+i), float64(day*100+i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Compacting on day 5 expires day 1 and downsamples days 2 to 3.
+	now = start.Add(4*24*time.Hour + time.Hour)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	want := []string{"2020-01-02.downsampled.jsonl", "2020-01-03.downsampled.jsonl", "2020-01-04.jsonl"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Files got %v, want %v", names, want)
+	}
+
+	day2 := start.Add(24 * time.Hour)
+	got, err := s.Query(Query{Direction: Downstream, Channel: "1", Field: SNR, Start: day2, End: day2.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Gauges are averaged per five minutes.
+	if want := []float64{32, 37}; !reflect.DeepEqual(pointValues(got), want) {
+		t.Errorf("Downsampled SNR got %v, want %v", pointValues(got), want)
+	}
+	got, err = s.Query(Query{Direction: Downstream, Channel: "1", Field: Uncorrectable, Start: day2, End: day2.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Counters keep the last value.
+	if want := []float64{104, 109}; !reflect.DeepEqual(pointValues(got), want) {
+		t.Errorf("Downsampled uncorrectable got %v, want %v", pointValues(got), want)
+	}
+}
+
+func TestBadLineSkipped(t *testing.T) {
+	now := start
+	s, dir := open(t, &now)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	if err := s.Add(start, signal(30, 0)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "2020-01-01.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"t":157783`)
+	f.Close()
+	got, err := s.Query(Query{Direction: Downstream, Channel: "1", Field: SNR, Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("Query got %v, want 1 point", got)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	now := start.Add(time.Hour)
+	s, dir := open(t, &now)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := s.Add(start.Add(time.Duration(i)*time.Minute), signal(float64(30+i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?channel=1&field=snr&start=2020-01-01T00:01:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %s", resp.Status)
+	}
+	var got []Point
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{31, 32}; !reflect.DeepEqual(pointValues(got), want) {
+		t.Errorf("Got %v, want %v", pointValues(got), want)
+	}
+
+	resp, err = http.Get(srv.URL + "?channel=1&field=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Bad field got status %s, want 400", resp.Status)
+	}
+}
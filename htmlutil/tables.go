@@ -0,0 +1,94 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package htmlutil
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// ParseInfoTable parses the name and value rows of the .simpleTable tables of
+// an ARRIS software or product information page, as served by the SB6183 and
+// SB8200.
+func ParseInfoTable(r io.Reader) (*modem.Info, error) {
+	n, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	info := &modem.Info{}
+	found := false
+	for _, row := range cascadia.MustCompile(".simpleTable tr").MatchAll(n) {
+		cols := cascadia.MustCompile("td").MatchAll(row)
+		if len(cols) != 2 {
+			continue
+		}
+		v := GetText(cols[1])
+		switch GetText(cols[0]) {
+		case "Hardware Version":
+			info.HardwareVersion = v
+		case "Software Version":
+			info.SoftwareVersion = v
+		case "Cable Modem MAC Address":
+			info.MACAddress = v
+		case "Cable Modem Serial Number":
+			info.SerialNumber = v
+		case "Up Time":
+			d, err := modem.ParseUptime(v)
+			if err != nil {
+				return nil, err
+			}
+			info.Uptime = d
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no modem information found")
+	}
+	return info, nil
+}
+
+// ParseEventTable parses an ARRIS event log page, as served by the SB6183 and
+// SB8200.  Its single .simpleTable lists events oldest first with time,
+// priority and description columns, below column headings in bold.
+func ParseEventTable(r io.Reader) ([]modem.Event, error) {
+	n, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	tables := cascadia.MustCompile(".simpleTable").MatchAll(n)
+	if len(tables) != 1 {
+		return nil, fmt.Errorf("Found %d simpleTables, expected 1", len(tables))
+	}
+	var events []modem.Event
+	for _, row := range cascadia.MustCompile("tr").MatchAll(tables[0]) {
+		cols := cascadia.MustCompile("td").MatchAll(row)
+		if len(cols) != 3 || cascadia.MustCompile("strong").MatchFirst(row) != nil {
+			continue
+		}
+		events = append(events, modem.Event{
+			Time:     modem.ParseEventTime(GetText(cols[0])),
+			Priority: modem.ParsePriority(GetText(cols[1])),
+			Text:     GetText(cols[2]),
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package htmlutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+func TestParseInfoTable(t *testing.T) {
+	page := `<table class="simpleTable">
+<tr><th colspan=2><strong>Information</strong></th></tr>
+<tr><td>Hardware Version</td><td> V1.0 </td></tr>
+<tr><td>Cable Modem Serial Number</td><td>1234</td></tr>
+<tr><td>Up Time</td><td>0 days 01h:02m:03s</td></tr>
+<tr><td>Unknown</td><td>ignored</td></tr>
+</table>`
+	got, err := ParseInfoTable(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{HardwareVersion: "V1.0", SerialNumber: "1234", Uptime: time.Hour + 2*time.Minute + 3*time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInfoTable got %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseInfoTable(strings.NewReader(`<table class="simpleTable"><tr><td>Unknown</td><td>x</td></tr></table>`)); err == nil {
+		t.Errorf("ParseInfoTable without known rows succeeded, want error")
+	}
+}
+
+func TestParseEventTable(t *testing.T) {
+	page := `<table class="simpleTable">
+<tr><th colspan=3><strong>Event Log</strong></th></tr>
+<tr><td><strong>Time</strong></td><td><strong>Priority</strong></td><td><strong>Description</strong></td></tr>
+<tr><td>Time Not Established</td><td>Critical (3)</td><td>First</td></tr>
+<tr><td>Second</td><td>Notice (6)</td><td>Second</td></tr>
+</table>`
+	got, err := ParseEventTable(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []modem.Event{
+		{Priority: modem.PriorityCritical, Text: "First"},
+		{Priority: modem.PriorityNotice, Text: "Second"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEventTable got %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseEventTable(strings.NewReader("<html></html>")); err == nil {
+		t.Errorf("ParseEventTable without a table succeeded, want error")
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Options configures a Client.
+type Options struct {
+	// URL is the base URL of the InfluxDB server, e.g. http://localhost:8086.
+	URL string
+
+	// Bucket selects the v2 write API, /api/v2/write, authenticated with
+	// Token.
+	Org, Bucket, Token string
+
+	// Database is written to with the v1 write API, /write, when Bucket is
+	// empty.  Username and Password are optional.
+	Database, RetentionPolicy string
+	Username, Password        string
+
+	// BatchSize is the number of lines that triggers a write before
+	// FlushInterval passes.  Zero defaults to 5000.
+	BatchSize int
+	// FlushInterval is the longest lines wait before being written.  Zero
+	// defaults to 10 seconds.
+	FlushInterval time.Duration
+
+	// BufferDir, if set, is where batches that couldn't be written are kept
+	// until the server is reachable again.  Otherwise they are dropped.
+	BufferDir string
+	// MaxBufferSize is the most bytes kept in BufferDir, the oldest batches
+	// are dropped past it.  Zero defaults to 100 MiB.
+	MaxBufferSize int64
+}
+
+// DefaultOptions holds the defaults used for zero Options fields.
+var DefaultOptions = Options{
+	BatchSize:     5000,
+	FlushInterval: 10 * time.Second,
+	MaxBufferSize: 100 << 20,
+}
+
+// Client batches line protocol and writes it to InfluxDB in the background.
+type Client struct {
+	opts    Options
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	mu      sync.Mutex
+	pending bytes.Buffer
+	lines   int
+	seq     int
+
+	// sendMu serializes flushes.
+	sendMu sync.Mutex
+	full   chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewClient returns a Client writing to the server in opts.  Close it to
+// write anything pending.
+func NewClient(opts Options) (*Client, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("influx: no URL")
+	}
+	if opts.Bucket == "" && opts.Database == "" {
+		return nil, fmt.Errorf("influx: one of bucket or database is required")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultOptions.FlushInterval
+	}
+	if opts.MaxBufferSize <= 0 {
+		opts.MaxBufferSize = DefaultOptions.MaxBufferSize
+	}
+	if opts.BufferDir != "" {
+		if err := os.MkdirAll(opts.BufferDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	c := &Client{
+		opts:    opts,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retries: 3,
+		backoff: time.Second,
+		full:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.loop()
+	return c, nil
+}
+
+// Add queues s to be written.  It never blocks on the network.
+func (c *Client) Add(t time.Time, model string, s *modem.Signal) {
+	b := Encode(t, model, s)
+	c.mu.Lock()
+	c.pending.Write(b)
+	c.lines += bytes.Count(b, []byte{'\n'})
+	full := c.lines >= c.opts.BatchSize
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close writes anything pending and stops the background writer.
+func (c *Client) Close() error {
+	close(c.quit)
+	<-c.done
+	return c.Flush()
+}
+
+func (c *Client) loop() {
+	defer close(c.done)
+	t := time.NewTicker(c.opts.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-t.C:
+		case <-c.full:
+		}
+		if err := c.Flush(); err != nil {
+			glog.Errorf("Failed to write to InfluxDB: %v", err)
+		}
+	}
+}
+
+// Flush writes buffered batches, oldest first, then pending lines.  A batch
+// that can't be written is kept in BufferDir, if set.
+func (c *Client) Flush() error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Lock()
+	batch := append([]byte(nil), c.pending.Bytes()...)
+	c.pending.Reset()
+	c.lines = 0
+	c.mu.Unlock()
+
+	if err := c.replay(); err != nil {
+		// The server is still unreachable, don't bother trying batch.
+		if len(batch) > 0 {
+			c.buffer(batch)
+		}
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	err := c.post(batch)
+	if err != nil && isRetryable(err) {
+		c.buffer(batch)
+	}
+	return err
+}
+
+// replay writes the batches in BufferDir, deleting each once written.  It
+// stops at the first batch that fails with a retryable error.
+func (c *Client) replay() error {
+	files, err := c.buffered()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if err := c.post(b); err != nil {
+			if isRetryable(err) {
+				return err
+			}
+			glog.Errorf("Dropping buffered InfluxDB batch %s: %v", f, err)
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	if len(files) > 0 {
+		glog.Infof("Wrote %d buffered batches to InfluxDB", len(files))
+	}
+	return nil
+}
+
+// buffered returns the batch files in BufferDir, oldest first.
+func (c *Client) buffered() ([]string, error) {
+	if c.opts.BufferDir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(c.opts.BufferDir, "*.lp"))
+	if err != nil {
+		return nil, err
+	}
+	// Names sort in the order written.
+	sort.Strings(files)
+	return files, nil
+}
+
+// buffer saves b in BufferDir, dropping the oldest batches if the buffer
+// grows past MaxBufferSize.
+func (c *Client) buffer(b []byte) {
+	if c.opts.BufferDir == "" {
+		glog.Warningf("Dropping %d bytes of InfluxDB lines, no buffer dir", len(b))
+		return
+	}
+	c.seq++
+	name := filepath.Join(c.opts.BufferDir, fmt.Sprintf("%020d-%06d.lp", time.Now().UnixNano(), c.seq%1000000))
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		glog.Errorf("Failed to buffer InfluxDB batch: %v", err)
+		return
+	}
+	files, err := c.buffered()
+	if err != nil {
+		glog.Errorf("Failed to list InfluxDB buffer: %v", err)
+		return
+	}
+	var sizes []int64
+	var total int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			sizes = append(sizes, 0)
+			continue
+		}
+		sizes = append(sizes, fi.Size())
+		total += fi.Size()
+	}
+	for i := 0; total > c.opts.MaxBufferSize && i < len(files)-1; i++ {
+		glog.Warningf("InfluxDB buffer over %d bytes, dropping %s", c.opts.MaxBufferSize, files[i])
+		os.Remove(files[i])
+		total -= sizes[i]
+	}
+}
+
+// httpError is a non-2xx response from the server.
+type httpError struct {
+	status int
+	body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.status, e.body)
+}
+
+// isRetryable returns false for errors that will fail the same way again,
+// such as a malformed batch or bad credentials.
+func isRetryable(err error) bool {
+	if he, ok := err.(*httpError); ok {
+		return he.status >= 500 || he.status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// post writes b, retrying retryable failures with exponential backoff.
+func (c *Client) post(b []byte) error {
+	var err error
+	backoff := c.backoff
+	for i := 0; i < c.retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = c.postOnce(b); err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) writeURL() string {
+	v := url.Values{}
+	path := "/write"
+	if c.opts.Bucket != "" {
+		path = "/api/v2/write"
+		v.Set("bucket", c.opts.Bucket)
+		if c.opts.Org != "" {
+			v.Set("org", c.opts.Org)
+		}
+	} else {
+		v.Set("db", c.opts.Database)
+		if c.opts.RetentionPolicy != "" {
+			v.Set("rp", c.opts.RetentionPolicy)
+		}
+	}
+	v.Set("precision", "ns")
+	return strings.TrimSuffix(c.opts.URL, "/") + path + "?" + v.Encode()
+}
+
+func (c *Client) postOnce(b []byte) error {
+	req, err := http.NewRequest("POST", c.writeURL(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case c.opts.Token != "":
+		req.Header.Set("Authorization", "Token "+c.opts.Token)
+	case c.opts.Username != "":
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &httpError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a stand-in InfluxDB recording every write.
+type server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	reqs   []*http.Request
+	bodies []string
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{status: http.StatusNoContent}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.reqs = append(s.reqs, r)
+		if s.status == http.StatusNoContent {
+			s.bodies = append(s.bodies, string(b))
+		}
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) setStatus(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+}
+
+func newTestClient(t *testing.T, opts Options) *Client {
+	opts.FlushInterval = time.Hour
+	c, err := NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.backoff = time.Millisecond
+	return c
+}
+
+func TestClientV2(t *testing.T) {
+	s := newServer(t)
+	c := newTestClient(t, Options{URL: s.URL, Org: "home", Bucket: "modem", Token: "secret"})
+	c.Add(testTime, "SB8200", testSignal)
+	c.Add(testTime.Add(time.Minute), "SB8200", testSignal)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.reqs) != 1 {
+		t.Fatalf("Got %d writes, want 1", len(s.reqs))
+	}
+	r := s.reqs[0]
+	if got, want := r.URL.String(), "/api/v2/write?bucket=modem&org=home&precision=ns"; got != want {
+		t.Errorf("URL got %q, want %q", got, want)
+	}
+	if got, want := r.Header.Get("Authorization"), "Token secret"; got != want {
+		t.Errorf("Authorization got %q, want %q", got, want)
+	}
+	if got, want := strings.Count(s.bodies[0], "\n"), 6; got != want {
+		t.Errorf("Got %d lines, want %d", got, want)
+	}
+}
+
+func TestClientV1(t *testing.T) {
+	s := newServer(t)
+	c := newTestClient(t, Options{URL: s.URL + "/", Database: "telegraf", RetentionPolicy: "week", Username: "user", Password: "pass"})
+	c.Add(testTime, "SB8200", testSignal)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r := s.reqs[0]
+	if got, want := r.URL.String(), "/write?db=telegraf&precision=ns&rp=week"; got != want {
+		t.Errorf("URL got %q, want %q", got, want)
+	}
+	if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+		t.Errorf("Basic auth got %q %q %v", u, p, ok)
+	}
+	// Nothing pending, nothing written.
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.reqs) != 1 {
+		t.Errorf("Got %d writes, want 1", len(s.reqs))
+	}
+	c.Close()
+}
+
+func TestClientBatchSize(t *testing.T) {
+	s := newServer(t)
+	c := newTestClient(t, Options{URL: s.URL, Database: "db", BatchSize: 3})
+	defer c.Close()
+	c.Add(testTime, "SB8200", testSignal)
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.bodies)
+		s.mu.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("A full batch wasn't written before FlushInterval")
+}
+
+func TestClientBuffer(t *testing.T) {
+	s := newServer(t)
+	dir := t.TempDir()
+	c := newTestClient(t, Options{URL: s.URL, Database: "db", BufferDir: dir})
+	defer c.Close()
+
+	s.setStatus(http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		c.Add(testTime.Add(time.Duration(i)*time.Minute), "SB8200", testSignal)
+		if err := c.Flush(); err == nil {
+			t.Fatalf("Flush during outage succeeded")
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.lp"))
+	if len(files) != 3 {
+		t.Fatalf("Got %d buffered batches, want 3", len(files))
+	}
+
+	// Once the server is back the buffered batches are written in order,
+	// followed by the new one.
+	s.setStatus(http.StatusNoContent)
+	c.Add(testTime.Add(3*time.Minute), "SB8200", testSignal)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.bodies) != 4 {
+		t.Fatalf("Got %d writes, want 4", len(s.bodies))
+	}
+	for i, b := range s.bodies {
+		ts := testTime.Add(time.Duration(i) * time.Minute).UnixNano()
+		if !strings.Contains(b, " "+strconv.FormatInt(ts, 10)+"\n") {
+			t.Errorf("Write %d doesn't have timestamp %d:\n%s", i, ts, b)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.lp")); len(files) != 0 {
+		t.Errorf("Buffer not emptied, got %v", files)
+	}
+}
+
+func TestClientBufferLimit(t *testing.T) {
+	s := newServer(t)
+	s.setStatus(http.StatusBadGateway)
+	dir := t.TempDir()
+	batch := len(Encode(testTime, "SB8200", testSignal))
+	c := newTestClient(t, Options{URL: s.URL, Database: "db", BufferDir: dir, MaxBufferSize: int64(2 * batch)})
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.Add(testTime, "SB8200", testSignal)
+		c.Flush()
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.lp")); len(files) != 2 {
+		t.Errorf("Got %d buffered batches, want 2", len(files))
+	}
+}
+
+func TestClientBadRequestNotBuffered(t *testing.T) {
+	s := newServer(t)
+	s.setStatus(http.StatusBadRequest)
+	dir := t.TempDir()
+	c := newTestClient(t, Options{URL: s.URL, Database: "db", BufferDir: dir})
+	defer c.Close()
+	c.Add(testTime, "SB8200", testSignal)
+	if err := c.Flush(); err == nil {
+		t.Errorf("Flush succeeded")
+	}
+	if len(s.reqs) != 1 {
+		t.Errorf("Got %d attempts, want 1", len(s.reqs))
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.lp")); len(files) != 0 {
+		t.Errorf("Bad batch was buffered: %v", files)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package influx writes modem signal data as InfluxDB line protocol, to an
+// InfluxDB v1 or v2 HTTP write endpoint or any io.Writer.
+package influx
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Measurement is the name of the measurement every channel is written to.
+const Measurement = "cable_modem_channel"
+
+var (
+	tagEscaper    = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	stringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`)
+)
+
+type line struct {
+	buf    bytes.Buffer
+	fields int
+}
+
+func newLine(tags ...string) *line {
+	l := &line{}
+	l.buf.WriteString(Measurement)
+	for i := 0; i+1 < len(tags); i += 2 {
+		// Empty tag values aren't allowed.
+		if tags[i+1] == "" {
+			continue
+		}
+		l.buf.WriteByte(',')
+		l.buf.WriteString(tags[i])
+		l.buf.WriteByte('=')
+		l.buf.WriteString(tagEscaper.Replace(tags[i+1]))
+	}
+	l.buf.WriteByte(' ')
+	return l
+}
+
+func (l *line) sep() {
+	if l.fields > 0 {
+		l.buf.WriteByte(',')
+	}
+	l.fields++
+}
+
+func (l *line) float(key string, v float64) {
+	l.sep()
+	l.buf.WriteString(key)
+	l.buf.WriteByte('=')
+	l.buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func (l *line) string(key, v string) {
+	if v == "" {
+		return
+	}
+	l.sep()
+	l.buf.WriteString(key)
+	l.buf.WriteString(`="`)
+	l.buf.WriteString(stringEscaper.Replace(v))
+	l.buf.WriteByte('"')
+}
+
+func (l *line) end(t time.Time) []byte {
+	l.buf.WriteByte(' ')
+	l.buf.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	l.buf.WriteByte('\n')
+	return l.buf.Bytes()
+}
+
+// Encode returns s as line protocol, one line per channel with nanosecond
+// timestamps.  Every line is tagged with model, channel, direction,
+// frequency and modulation.
+func Encode(t time.Time, model string, s *modem.Signal) []byte {
+	var b []byte
+	for _, ch := range s.DownstreamChannels() {
+		d := s.Downstream[ch]
+		l := newLine("channel", string(ch), "direction", string(modem.DirectionDownstream), "frequency", d.Frequency, "model", model, "modulation", d.Modulation)
+		l.float("power", d.PowerLevel)
+		l.float("snr", d.SNR)
+		l.float("unerrored", d.Unerrored)
+		l.float("correctable", d.Correctable)
+		l.float("uncorrectable", d.Uncorrectable)
+		l.string("status", d.Status)
+		b = append(b, l.end(t)...)
+	}
+	for _, ch := range s.UpstreamChannels() {
+		u := s.Upstream[ch]
+		l := newLine("channel", string(ch), "direction", string(modem.DirectionUpstream), "frequency", u.Frequency, "model", model, "modulation", u.Modulation)
+		l.float("power", u.PowerLevel)
+		l.float("symbol_rate", u.SymbolRate)
+		l.string("status", u.Status)
+		b = append(b, l.end(t)...)
+	}
+	return b
+}
+
+// Write writes s as line protocol to w.
+func Write(w io.Writer, t time.Time, model string, s *modem.Signal) error {
+	_, err := w.Write(Encode(t, model, s))
+	return err
+}
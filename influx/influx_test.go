@@ -0,0 +1,55 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+var (
+	testTime   = time.Unix(1577836800, 0)
+	testSignal = &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"10": {Frequency: "483000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2.5, SNR: 40.1, Correctable: 10, Uncorrectable: 2},
+			"2":  {Frequency: "465000000 Hz", Modulation: "Other", Status: `Not "Locked"`, PowerLevel: -1},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Frequency: "36000000 Hz", Modulation: "ATDMA", PowerLevel: 45, SymbolRate: 5120},
+		},
+	}
+)
+
+func TestEncode(t *testing.T) {
+	got := string(Encode(testTime, "SB8200", testSignal))
+	want := `cable_modem_channel,channel=2,direction=downstream,frequency=465000000\ Hz,model=SB8200,modulation=Other power=-1,snr=0,unerrored=0,correctable=0,uncorrectable=0,status="Not \"Locked\"" 1577836800000000000
+cable_modem_channel,channel=10,direction=downstream,frequency=483000000\ Hz,model=SB8200,modulation=QAM256 power=2.5,snr=40.1,unerrored=0,correctable=10,uncorrectable=2,status="Locked" 1577836800000000000
+cable_modem_channel,channel=1,direction=upstream,frequency=36000000\ Hz,model=SB8200,modulation=ATDMA power=45,symbol_rate=5120 1577836800000000000
+`
+	if got != want {
+		t.Errorf("Encode got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEncodeEmptyTag(t *testing.T) {
+	s := &modem.Signal{Upstream: map[modem.Channel]*modem.Upstream{"1": {PowerLevel: 40}}}
+	got := string(Encode(testTime, "S33", s))
+	want := "cable_modem_channel,channel=1,direction=upstream,model=S33 power=40,symbol_rate=0 1577836800000000000\n"
+	if got != want {
+		t.Errorf("Encode got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/record"
+)
+
+// apiInfo is the response of /api/v1/info.  Fields the modem doesn't report
+// are left out.
+type apiInfo struct {
+	APIVersion      int        `json:"api_version"`
+	Model           string     `json:"model"`
+	FetchedAt       *time.Time `json:"fetched_at,omitempty"`
+	HardwareVersion string     `json:"hardware_version,omitempty"`
+	SoftwareVersion string     `json:"software_version,omitempty"`
+	SerialNumber    string     `json:"serial_number,omitempty"`
+	MACAddress      string     `json:"mac_address,omitempty"`
+	ConfigFile      string     `json:"config_file,omitempty"`
+	UptimeSeconds   float64    `json:"uptime_seconds,omitempty"`
+	BootTime        *time.Time `json:"boot_time,omitempty"`
+	// SystemTime is the modem's clock as of FetchedAt.
+	SystemTime *time.Time `json:"system_time,omitempty"`
+	// Provisioning is the outcome of the modem's startup sequence.
+	Provisioning *modem.Provisioning `json:"provisioning,omitempty"`
+	// Timeouts counts the events in Events by the DOCSIS timer that
+	// expired, e.g. T3.
+	Timeouts map[string]int `json:"timeouts,omitempty"`
+	Events   []modem.Event  `json:"events,omitempty"`
+	// LastError is set if the most recent fetch failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// infoFetcher fetches the modem's product information and event log, which
+// change slowly and take extra requests, on their own interval rather than
+// every poll.
+type infoFetcher struct {
+	m       modem.Modem
+	timeout time.Duration
+
+	mu     sync.Mutex
+	latest *apiInfo
+}
+
+// newInfoFetcher returns a fetcher for m, or nil if m can fetch neither its
+// product information nor its event log.
+func newInfoFetcher(m modem.Modem, timeout time.Duration) *infoFetcher {
+	_, d := m.(modem.Describer)
+	_, l := m.(modem.EventLogger)
+	if !d && !l {
+		return nil
+	}
+	return &infoFetcher{m: m, timeout: timeout, latest: &apiInfo{APIVersion: apiVersion, Model: m.Name()}}
+}
+
+// fetch fetches whatever the modem supports, each page within f.timeout, and
+// updates the metrics.  Parts that fail keep their previous value.
+func (f *infoFetcher) fetch(ctx context.Context, now time.Time) {
+	f.mu.Lock()
+	r := *f.latest
+	f.mu.Unlock()
+	var errs []string
+	if d, ok := f.m.(modem.Describer); ok {
+		ctx, cancel := context.WithTimeout(record.Scrape(ctx, "info"), f.timeout)
+		info, err := d.Info(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, "info: "+err.Error())
+		} else {
+			r.FetchedAt = &now
+			r.HardwareVersion = info.HardwareVersion
+			r.SoftwareVersion = info.SoftwareVersion
+			r.SerialNumber = info.SerialNumber
+			r.MACAddress = info.MACAddress
+			r.ConfigFile = info.ConfigFile
+			r.Provisioning = info.Provisioning
+			r.UptimeSeconds, r.BootTime, r.SystemTime = 0, nil, nil
+			if !info.SystemTime.IsZero() {
+				r.SystemTime = &info.SystemTime
+			}
+			if info.Uptime > 0 {
+				boot := now.Add(-info.Uptime).Truncate(time.Second)
+				r.UptimeSeconds = info.Uptime.Seconds()
+				r.BootTime = &boot
+			}
+		}
+	}
+	if l, ok := f.m.(modem.EventLogger); ok {
+		ctx, cancel := context.WithTimeout(record.Scrape(ctx, "events"), f.timeout)
+		events, err := l.Events(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, "event log: "+err.Error())
+		} else {
+			r.FetchedAt = &now
+			r.Events = events
+			r.Timeouts = map[string]int{"T3": 0, "T4": 0}
+			for _, e := range events {
+				if t := e.Timeout(); t != "" {
+					r.Timeouts[t]++
+				}
+			}
+		}
+	}
+	r.LastError = strings.Join(errs, "; ")
+	if r.LastError != "" {
+		glog.Warningf("Failed to fetch %s %s", f.m.Name(), r.LastError)
+	}
+	f.mu.Lock()
+	f.latest = &r
+	f.mu.Unlock()
+
+	if r.HardwareVersion != "" || r.SoftwareVersion != "" {
+		modemInfoMetric.Reset()
+		modemInfoMetric.WithLabelValues(r.HardwareVersion, r.SoftwareVersion).Set(1)
+	}
+	if r.BootTime != nil {
+		modemBootTimeMetric.Set(float64(r.BootTime.Unix()))
+	}
+	eventLogTimeoutsMetric.Reset()
+	for t, n := range r.Timeouts {
+		eventLogTimeoutsMetric.WithLabelValues(t).Set(float64(n))
+	}
+}
+
+// run fetches every interval until ctx is done.
+func (f *infoFetcher) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		f.fetch(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ServeHTTP serves /api/v1/info from the latest fetch.
+func (f *infoFetcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	latest := f.latest
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(latest)
+}
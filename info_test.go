@@ -0,0 +1,112 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// fakeDescriber is a fakeModem that reports info and an event log.
+type fakeDescriber struct {
+	fakeModem
+	info      *modem.Info
+	events    []modem.Event
+	eventsErr error
+}
+
+func (f *fakeDescriber) Info(context.Context) (*modem.Info, error) { return f.info, nil }
+
+func (f *fakeDescriber) Events(context.Context) ([]modem.Event, error) {
+	return f.events, f.eventsErr
+}
+
+func TestInfoFetcher(t *testing.T) {
+	if f := newInfoFetcher(&fakeModem{}, time.Second); f != nil {
+		t.Errorf("newInfoFetcher of a modem without info got %v, want nil", f)
+	}
+
+	m := &fakeDescriber{
+		info: &modem.Info{
+			HardwareVersion: "6",
+			SoftwareVersion: "1.2.3",
+			Uptime:          90 * time.Minute,
+			SystemTime:      time.Date(2020, 6, 27, 17, 10, 45, 0, time.UTC),
+			ConfigFile:      "modem.cm",
+			Provisioning: &modem.Provisioning{
+				Status: "Operational",
+				Steps:  []modem.ProvisioningStep{{Name: "Establish Time Of Day", Status: "Done"}},
+			},
+		},
+		events: []modem.Event{
+			{Priority: modem.PriorityCritical, Text: "No Ranging Response received - T3 time-out"},
+			{Priority: modem.PriorityNotice, Text: "Honoring MDD"},
+			{Priority: modem.PriorityCritical, Text: "No Ranging Response received - T3 time-out"},
+		},
+	}
+	f := newInfoFetcher(m, time.Second)
+	now := time.Date(2020, 6, 27, 17, 10, 41, 0, time.UTC)
+	f.fetch(context.Background(), now)
+
+	boot := now.Add(-90 * time.Minute)
+	want := &apiInfo{
+		APIVersion:      apiVersion,
+		Model:           "FAKE",
+		FetchedAt:       &now,
+		HardwareVersion: "6",
+		SoftwareVersion: "1.2.3",
+		ConfigFile:      "modem.cm",
+		UptimeSeconds:   5400,
+		BootTime:        &boot,
+		SystemTime:      &m.info.SystemTime,
+		Provisioning:    m.info.Provisioning,
+		Timeouts:        map[string]int{"T3": 2, "T4": 0},
+		Events:          m.events,
+	}
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/info", nil))
+	var got apiInfo
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("/api/v1/info got %+v, want %+v", got, want)
+	}
+
+	// A failed fetch keeps the last event log.
+	m.eventsErr = errors.New("unreachable")
+	f.fetch(context.Background(), now.Add(time.Minute))
+	if got := f.latest; len(got.Events) != 3 || got.LastError != "event log: unreachable" {
+		t.Errorf("After a failed fetch got %d events and error %q, want 3 and the error", len(got.Events), got.LastError)
+	}
+}
+
+func TestModemSerial(t *testing.T) {
+	ctx := context.Background()
+	if got := modemSerial(ctx, &fakeModem{}); got != "" {
+		t.Errorf("modemSerial of a modem without info got %q, want \"\"", got)
+	}
+	m := &fakeDescriber{info: &modem.Info{SerialNumber: "123456789"}}
+	if got, want := modemSerial(ctx, m), "123456789"; got != want {
+		t.Errorf("modemSerial got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package latest hands the most recent of a stream of values to a background
+// worker.  Exporters use it so a slow or unreachable server never holds up
+// fetching from the modem, and only the newest fetch waits to be sent.
+package latest
+
+import "sync"
+
+// Queue holds at most one value for a worker.  A value put before the worker
+// got the previous one replaces it.
+type Queue struct {
+	mu     sync.Mutex
+	v      interface{}
+	full   bool
+	closed bool
+	// ready is sent to when a value is put and closed by Close.
+	ready chan struct{}
+}
+
+// New returns an empty Queue.
+func New() *Queue {
+	return &Queue{ready: make(chan struct{}, 1)}
+}
+
+// Put queues v, replacing any value still waiting.  It never blocks.  Once
+// the Queue is closed v is dropped and Put returns false.
+func (q *Queue) Put(v interface{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.v, q.full = v, true
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Get waits for a value.  It returns false once the Queue is closed and any
+// value put before Close was returned.
+func (q *Queue) Get() (interface{}, bool) {
+	for {
+		q.mu.Lock()
+		if q.full {
+			v := q.v
+			q.v, q.full = nil, false
+			q.mu.Unlock()
+			return v, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+// Close stops the Queue accepting values.  It may be called more than once.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ready)
+	}
+}
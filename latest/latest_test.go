@@ -0,0 +1,61 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package latest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	q := New()
+	for i := 0; i < 3; i++ {
+		if !q.Put(i) {
+			t.Fatalf("Put(%d) on an open Queue failed", i)
+		}
+	}
+	if v, ok := q.Get(); !ok || v != 2 {
+		t.Errorf("Get got %v, %v, want only the latest value 2", v, ok)
+	}
+
+	got := make(chan interface{})
+	go func() {
+		v, _ := q.Get()
+		got <- v
+	}()
+	q.Put(3)
+	select {
+	case v := <-got:
+		if v != 3 {
+			t.Errorf("Waiting Get got %v, want 3", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting Get never returned")
+	}
+
+	// A value put before Close is still handed over.
+	q.Put(4)
+	q.Close()
+	q.Close()
+	if q.Put(5) {
+		t.Errorf("Put after Close succeeded")
+	}
+	if v, ok := q.Get(); !ok || v != 4 {
+		t.Errorf("Get after Close got %v, %v, want 4", v, ok)
+	}
+	if v, ok := q.Get(); ok {
+		t.Errorf("Get of a drained closed Queue got %v", v)
+	}
+}
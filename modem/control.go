@@ -0,0 +1,37 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"context"
+	"errors"
+)
+
+// Controller is implemented by modems that can be restarted remotely.
+type Controller interface {
+	// Reboot restarts the modem.  It returns once the modem accepted the
+	// request; the connection drops while the modem restarts, typically for
+	// a few minutes.
+	Reboot(context.Context) error
+	// ResetToDefaults restores the modem's factory settings, including any
+	// admin password, and restarts it.  Callers should guard it separately
+	// from Reboot.
+	ResetToDefaults(context.Context) error
+}
+
+// ErrFakeData is returned by a modem reading its status from a file for
+// anything besides its status, such as control or its event log, as there is
+// no modem to ask.
+var ErrFakeData = errors.New("not available for a modem read from a file")
@@ -0,0 +1,168 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ChangeType is the kind of a Change.
+type ChangeType string
+
+const (
+	ChannelAdded      ChangeType = "channel_added"
+	ChannelRemoved    ChangeType = "channel_removed"
+	FrequencyChanged  ChangeType = "frequency_changed"
+	ModulationChanged ChangeType = "modulation_changed"
+	LockChanged       ChangeType = "lock_changed"
+	// Power level moved by more than Differ.PowerJump dB.
+	PowerJump ChangeType = "power_jump"
+	// A codeword counter went down, usually because the modem rebooted.
+	CounterReset ChangeType = "counter_reset"
+)
+
+// Direction is the direction of a channel.
+type Direction string
+
+const (
+	DirectionDownstream Direction = "downstream"
+	DirectionUpstream   Direction = "upstream"
+)
+
+// Change is a difference between two Signals.
+type Change struct {
+	Type      ChangeType `json:"type"`
+	Direction Direction  `json:"direction"`
+	Channel   Channel    `json:"channel"`
+	// Field is the counter that was reset.
+	Field string `json:"field,omitempty"`
+	// From and To are the old and new values, if any.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s channel %s", c.Direction, c.Channel)
+	switch c.Type {
+	case ChannelAdded:
+		return s + " added"
+	case ChannelRemoved:
+		return s + " removed"
+	case CounterReset:
+		return fmt.Sprintf("%s %s counter reset from %s to %s", s, c.Field, c.From, c.To)
+	}
+	return fmt.Sprintf("%s %s from %q to %q", s, strings.Replace(string(c.Type), "_", " ", -1), c.From, c.To)
+}
+
+// DefaultPowerJump is the power level change in dB reported by Diff.
+const DefaultPowerJump = 3
+
+// Differ compares Signals.
+type Differ struct {
+	// PowerJump is the smallest power level change in dB that is reported.
+	// Zero or less disables power jump changes.
+	PowerJump float64
+}
+
+// Diff returns the changes from prev to cur using DefaultPowerJump.
+func Diff(prev, cur *Signal) []Change {
+	return Differ{PowerJump: DefaultPowerJump}.Diff(prev, cur)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Diff returns the changes from prev to cur.  Changes are ordered by
+// direction, downstream first, then by channel with removed channels before
+// all others.  There are no changes if either Signal is nil.
+func (d Differ) Diff(prev, cur *Signal) []Change {
+	if prev == nil || cur == nil {
+		return nil
+	}
+	var cs []Change
+	add := func(dir Direction, ch Channel, t ChangeType, from, to string) {
+		cs = append(cs, Change{Type: t, Direction: dir, Channel: ch, From: from, To: to})
+	}
+	power := func(dir Direction, ch Channel, from, to float64) {
+		if d.PowerJump > 0 && math.Abs(to-from) > d.PowerJump {
+			add(dir, ch, PowerJump, ftoa(from), ftoa(to))
+		}
+	}
+
+	for _, ch := range prev.DownstreamChannels() {
+		if _, ok := cur.Downstream[ch]; !ok {
+			add(DirectionDownstream, ch, ChannelRemoved, "", "")
+		}
+	}
+	for _, ch := range cur.DownstreamChannels() {
+		c := cur.Downstream[ch]
+		p, ok := prev.Downstream[ch]
+		if !ok {
+			add(DirectionDownstream, ch, ChannelAdded, "", "")
+			continue
+		}
+		if p.Frequency != c.Frequency {
+			add(DirectionDownstream, ch, FrequencyChanged, p.Frequency, c.Frequency)
+		}
+		if p.Modulation != c.Modulation {
+			add(DirectionDownstream, ch, ModulationChanged, p.Modulation, c.Modulation)
+		}
+		if p.Status != c.Status {
+			add(DirectionDownstream, ch, LockChanged, p.Status, c.Status)
+		}
+		power(DirectionDownstream, ch, p.PowerLevel, c.PowerLevel)
+		for _, f := range []struct {
+			name     string
+			from, to float64
+		}{
+			{"unerrored", p.Unerrored, c.Unerrored},
+			{"correctable", p.Correctable, c.Correctable},
+			{"uncorrectable", p.Uncorrectable, c.Uncorrectable},
+		} {
+			if f.to < f.from {
+				cs = append(cs, Change{Type: CounterReset, Direction: DirectionDownstream, Channel: ch, Field: f.name, From: ftoa(f.from), To: ftoa(f.to)})
+			}
+		}
+	}
+
+	for _, ch := range prev.UpstreamChannels() {
+		if _, ok := cur.Upstream[ch]; !ok {
+			add(DirectionUpstream, ch, ChannelRemoved, "", "")
+		}
+	}
+	for _, ch := range cur.UpstreamChannels() {
+		c := cur.Upstream[ch]
+		p, ok := prev.Upstream[ch]
+		if !ok {
+			add(DirectionUpstream, ch, ChannelAdded, "", "")
+			continue
+		}
+		if p.Frequency != c.Frequency {
+			add(DirectionUpstream, ch, FrequencyChanged, p.Frequency, c.Frequency)
+		}
+		if p.Modulation != c.Modulation {
+			add(DirectionUpstream, ch, ModulationChanged, p.Modulation, c.Modulation)
+		}
+		if p.Status != c.Status {
+			add(DirectionUpstream, ch, LockChanged, p.Status, c.Status)
+		}
+		power(DirectionUpstream, ch, p.PowerLevel, c.PowerLevel)
+	}
+	return cs
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	prev := &Signal{
+		Downstream: map[Channel]*Downstream{
+			"1":  {Frequency: "459000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2, Uncorrectable: 100},
+			"2":  {Frequency: "465000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2},
+			"3":  {Frequency: "471000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2},
+			"10": {Frequency: "477000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2},
+		},
+		Upstream: map[Channel]*Upstream{
+			"1": {Frequency: "36000000 Hz", Modulation: "ATDMA", Status: "Locked", PowerLevel: 45},
+			"2": {Frequency: "30000000 Hz", Modulation: "ATDMA", Status: "Locked", PowerLevel: 45},
+		},
+	}
+	cur := &Signal{
+		Downstream: map[Channel]*Downstream{
+			"1":  {Frequency: "459000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2.5, Uncorrectable: 5},
+			"2":  {Frequency: "465000000 Hz", Modulation: "QAM64", Status: "Not Locked", PowerLevel: -2},
+			"10": {Frequency: "483000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2},
+			"11": {Frequency: "489000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2},
+		},
+		Upstream: map[Channel]*Upstream{
+			"1": {Frequency: "36000000 Hz", Modulation: "ATDMA", Status: "Locked", PowerLevel: 51},
+		},
+	}
+	got := Diff(prev, cur)
+	want := []Change{
+		{Type: ChannelRemoved, Direction: DirectionDownstream, Channel: "3"},
+		{Type: CounterReset, Direction: DirectionDownstream, Channel: "1", Field: "uncorrectable", From: "100", To: "5"},
+		{Type: ModulationChanged, Direction: DirectionDownstream, Channel: "2", From: "QAM256", To: "QAM64"},
+		{Type: LockChanged, Direction: DirectionDownstream, Channel: "2", From: "Locked", To: "Not Locked"},
+		{Type: PowerJump, Direction: DirectionDownstream, Channel: "2", From: "2", To: "-2"},
+		{Type: FrequencyChanged, Direction: DirectionDownstream, Channel: "10", From: "477000000 Hz", To: "483000000 Hz"},
+		{Type: ChannelAdded, Direction: DirectionDownstream, Channel: "11"},
+		{Type: ChannelRemoved, Direction: DirectionUpstream, Channel: "2"},
+		{Type: PowerJump, Direction: DirectionUpstream, Channel: "1", From: "45", To: "51"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff got\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := (Differ{}).Diff(prev, prev); len(got) != 0 {
+		t.Errorf("Diff of identical signals got %+v", got)
+	}
+	if got := Diff(nil, cur); len(got) != 0 {
+		t.Errorf("Diff without a previous signal got %+v", got)
+	}
+	if got := (Differ{PowerJump: 5}).Diff(prev, cur); len(got) != len(want)-1 {
+		t.Errorf("Diff with 5 dB power jump got %d changes, want %d", len(got), len(want)-1)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	for _, tc := range []struct {
+		c    Change
+		want string
+	}{
+		{Change{Type: ChannelAdded, Direction: DirectionDownstream, Channel: "5"}, "downstream channel 5 added"},
+		{Change{Type: ModulationChanged, Direction: DirectionUpstream, Channel: "1", From: "ATDMA", To: "TDMA"}, `upstream channel 1 modulation changed from "ATDMA" to "TDMA"`},
+		{Change{Type: CounterReset, Direction: DirectionDownstream, Channel: "2", Field: "uncorrectable", From: "10", To: "0"}, "downstream channel 2 uncorrectable counter reset from 10 to 0"},
+	} {
+		if got := tc.c.String(); got != tc.want {
+			t.Errorf("String got %q, want %q", got, tc.want)
+		}
+	}
+}
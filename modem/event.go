@@ -0,0 +1,155 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority is the DOCSIS event priority, from PriorityEmergency (1) to
+// PriorityDebug (8).  Each is one more than the syslog severity of the same
+// name.
+type Priority int
+
+const (
+	PriorityUnknown Priority = iota
+	PriorityEmergency
+	PriorityAlert
+	PriorityCritical
+	PriorityError
+	PriorityWarning
+	PriorityNotice
+	PriorityInformation
+	PriorityDebug
+)
+
+var priorityNames = []string{"unknown", "emergency", "alert", "critical", "error", "warning", "notice", "information", "debug"}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return priorityNames[0]
+	}
+	return priorityNames[p]
+}
+
+// MarshalText encodes p as its name.
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes p from anything ParsePriority accepts.
+func (p *Priority) UnmarshalText(b []byte) error {
+	*p = ParsePriority(string(b))
+	return nil
+}
+
+// Severity returns the syslog severity of p, 0 for emergency to 7 for debug.
+// Unknown priorities are treated as notices.
+func (p Priority) Severity() int {
+	if p < PriorityEmergency || p > PriorityDebug {
+		return int(PriorityNotice) - 1
+	}
+	return int(p) - 1
+}
+
+var priorityNumber = regexp.MustCompile(`\d+`)
+
+// ParsePriority parses the priority column of a modem's event log, such as
+// "Critical (3)", "3" or "critical".
+func ParsePriority(s string) Priority {
+	if n := priorityNumber.FindString(s); n != "" {
+		if p, err := strconv.Atoi(n); err == nil && p >= int(PriorityEmergency) && p <= int(PriorityDebug) {
+			return Priority(p)
+		}
+	}
+	s = strings.ToLower(strings.TrimSpace(s))
+	for p, name := range priorityNames {
+		if p > 0 && (s == name || (len(s) >= 4 && strings.HasPrefix(name, s))) {
+			return Priority(p)
+		}
+	}
+	return PriorityUnknown
+}
+
+// Event is an entry of the modem's event log.
+type Event struct {
+	// Time is when the modem logged the event, zero if the modem didn't know
+	// the time yet, which it reports as e.g. "Time Not Established".
+	Time     time.Time `json:"time,omitempty"`
+	Priority Priority  `json:"priority"`
+	// ID is the DOCSIS event ID, if the modem reports it.
+	ID   string `json:"id,omitempty"`
+	Text string `json:"text"`
+}
+
+// EventLogger is implemented by modems that can fetch their event log.
+type EventLogger interface {
+	// Events returns the event log, oldest first.
+	Events(context.Context) ([]Event, error)
+}
+
+// eventTimeLayouts are the ways modems display event times.
+var eventTimeLayouts = []string{
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"Mon Jan 2 15:04:05 2006",
+	"Jan 2 2006 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+var eventLocation = time.Local
+
+// SetEventLocation sets the time zone ParseEventTime parses in, time.Local by
+// default.  Modems get their clock from the CMTS, usually set to the ISP's
+// local time, and the times they display don't say which zone they are in.  It
+// should be called before any modem is probed.
+func SetEventLocation(loc *time.Location) {
+	eventLocation = loc
+}
+
+// ParseEventTime parses the time column of a modem's event log, such as
+// "06/27/2020 17:05" or "Sat Jun 27 17:10:41 2020", in the zone set by
+// SetEventLocation.  It returns the zero time if s isn't a time, such as "Time
+// Not Established", or is in 1970, which modems count from until they know the
+// time.
+func ParseEventTime(s string) time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, eventLocation); err == nil {
+			if t.Year() == 1970 {
+				return time.Time{}
+			}
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+var timeoutPattern = regexp.MustCompile(`(?i)\b(T[1-6]) time-? ?out`)
+
+// Timeout returns the DOCSIS MAC timer, such as "T3" or "T4", whose expiry e
+// reports, or "" if it isn't a timeout.  T3 and T4 timeouts are the usual
+// sign of upstream noise.
+func (e Event) Timeout() string {
+	m := timeoutPattern.FindStringSubmatch(e.Text)
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1])
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	for s, want := range map[string]Priority{
+		"Critical (3)": PriorityCritical,
+		"3":            PriorityCritical,
+		"critical":     PriorityCritical,
+		"Notice":       PriorityNotice,
+		"Warning (5)":  PriorityWarning,
+		"6":            PriorityNotice,
+		"Info":         PriorityInformation,
+		"bogus":        PriorityUnknown,
+		"0":            PriorityUnknown,
+	} {
+		if got := ParsePriority(s); got != want {
+			t.Errorf("ParsePriority(%q) got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseEventTime(t *testing.T) {
+	defer SetEventLocation(eventLocation)
+	est := time.FixedZone("EST", -5*60*60)
+	SetEventLocation(est)
+	for s, want := range map[string]time.Time{
+		"06/27/2020 17:05":         time.Date(2020, 6, 27, 17, 5, 0, 0, est),
+		"06/27/2020 17:05:09":      time.Date(2020, 6, 27, 17, 5, 9, 0, est),
+		"6/2/2021 17:27:50":        time.Date(2021, 6, 2, 17, 27, 50, 0, est),
+		"Sat Jun 27 17:10:41 2020": time.Date(2020, 6, 27, 17, 10, 41, 0, est),
+		"Sat Jun  7 17:10:41 2020": time.Date(2020, 6, 7, 17, 10, 41, 0, est),
+		"Jan 05 2020 10:09:08":     time.Date(2020, 1, 5, 10, 9, 8, 0, est),
+		"Jan 01 1970 00:01:12":     {},
+		"Time Not Established":     {},
+		"":                         {},
+	} {
+		if got := ParseEventTime(s); !got.Equal(want) {
+			t.Errorf("ParseEventTime(%q) got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	for text, want := range map[string]string{
+		"No Ranging Response received - T3 time-out;CM-MAC=00:00:00:00:00:00;":                                                "T3",
+		"Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out": "T4",
+		"SYNC Timing Synchronization failure - Failed to acquire QAM/QPSK symbol timing":                                      "",
+		"Honoring MDD; IP provisioning mode = IPv6":                                                                           "",
+	} {
+		if got := (Event{Text: text}).Timeout(); got != want {
+			t.Errorf("Timeout of %q got %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var wrapTransport func(http.RoundTripper) http.RoundTripper
+
+// SetTransportWrapper installs f to wrap the transport of every client
+// returned by Client.  It is intended for instrumentation, such as recording
+// raw modem responses, and should be called before any modem is probed.
+func SetTransportWrapper(f func(http.RoundTripper) http.RoundTripper) {
+	wrapTransport = f
+}
+
+// Client returns an *http.Client that modem implementations should use for
+// all requests to the modem.  If rt is nil, http.DefaultTransport is used.  A
+// timeout of zero means no timeout.
+func Client(rt http.RoundTripper, timeout time.Duration) *http.Client {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if wrapTransport != nil {
+		rt = wrapTransport(rt)
+	}
+	return &http.Client{Transport: rt, Timeout: timeout}
+}
+
+// GetPage fetches url with Client and returns the body, of at most 1MiB.  A
+// status other than 200 OK is an error.
+func GetPage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := Client(nil, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Info describes the modem itself and how it was provisioned, as shown on its
+// product information and startup pages.  Fields the modem doesn't report are
+// empty.
+type Info struct {
+	HardwareVersion string
+	// SoftwareVersion is the firmware version.
+	SoftwareVersion string
+	SerialNumber    string
+	// MACAddress is the MAC address of the cable (HFC) interface.
+	MACAddress string
+	// Uptime is how long the modem had been up when the info was fetched.
+	Uptime time.Duration
+	// SystemTime is the modem's clock when the info was fetched, zero if
+	// it doesn't know the time.
+	SystemTime time.Time
+	// ConfigFile is the name of the DOCSIS configuration file the modem was
+	// provisioned with.
+	ConfigFile   string
+	Provisioning *Provisioning
+}
+
+// Provisioning is the outcome of the modem's startup sequence.
+type Provisioning struct {
+	// Status is the overall state, such as "Operational".
+	Status string `json:"status"`
+	// Steps are the startup steps in the order the modem lists them.
+	Steps []ProvisioningStep `json:"steps,omitempty"`
+}
+
+// ProvisioningStep is a step of the startup sequence, such as "Establish
+// Time Of Day", and its status, such as "Done".  Comment is any detail the
+// modem adds.
+type ProvisioningStep struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Describer is implemented by modems that can fetch their product
+// information.
+type Describer interface {
+	Info(context.Context) (*Info, error)
+}
+
+var uptimePattern = regexp.MustCompile(`^(?:(\d+)\s*days?\s*)?(\d+)\s*h?\s*:\s*(\d+)\s*m?\s*:\s*(\d+)\s*s?(?:\.\d+)?\s*s?$`)
+
+// ParseUptime parses an uptime as modems display it, such as
+// "5 days 02h:35m:36s.00" or "0 days 01:23:45".
+func ParseUptime(s string) (time.Duration, error) {
+	m := uptimePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("unrecognized uptime %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, fmt.Errorf("unrecognized uptime %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUptime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"5 days 02h:35m:36s.00": 5*24*time.Hour + 2*time.Hour + 35*time.Minute + 36*time.Second,
+		"1 day 00h:00m:01s":     24*time.Hour + time.Second,
+		"0 days 01:23:45":       time.Hour + 23*time.Minute + 45*time.Second,
+		"12h:00m:00s":           12 * time.Hour,
+	} {
+		got, err := ParseUptime(s)
+		if err != nil {
+			t.Errorf("ParseUptime(%q): %v", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseUptime(%q) got %v, want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "Not Available", "5 days"} {
+		if _, err := ParseUptime(s); err == nil {
+			t.Errorf("ParseUptime(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -16,7 +16,12 @@
 // scrapers will support.
 package modem
 
-import "context"
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 type Downstream struct {
 	Correctable float64
@@ -29,7 +34,9 @@ type Downstream struct {
 	SNR           float64
 	Uncorrectable float64
 	Unerrored     float64
-	// TODO(wathiede): status?
+	// Lock status as reported by the modem, e.g. "Locked".  Empty if the
+	// modem doesn't report it.
+	Status string
 }
 
 type Upstream struct {
@@ -43,6 +50,16 @@ type Upstream struct {
 	Status     string
 }
 
+// IsLocked reports whether a channel status means it is usable.  Depending on
+// the model and direction this is a lock or a ranging status.
+func IsLocked(status string) bool {
+	switch strings.ToLower(status) {
+	case "locked", "success":
+		return true
+	}
+	return false
+}
+
 type Channel string
 
 type Signal struct {
@@ -50,6 +67,39 @@ type Signal struct {
 	Upstream   map[Channel]*Upstream
 }
 
+// DownstreamChannels returns the downstream channels of s in display order.
+func (s *Signal) DownstreamChannels() []Channel {
+	var chs []Channel
+	for ch := range s.Downstream {
+		chs = append(chs, ch)
+	}
+	SortChannels(chs)
+	return chs
+}
+
+// UpstreamChannels returns the upstream channels of s in display order.
+func (s *Signal) UpstreamChannels() []Channel {
+	var chs []Channel
+	for ch := range s.Upstream {
+		chs = append(chs, ch)
+	}
+	SortChannels(chs)
+	return chs
+}
+
+// SortChannels sorts chs numerically, falling back to lexical order for
+// channels that aren't numbers.
+func SortChannels(chs []Channel) {
+	sort.Slice(chs, func(i, j int) bool {
+		a, aErr := strconv.Atoi(string(chs[i]))
+		b, bErr := strconv.Atoi(string(chs[j]))
+		if aErr == nil && bErr == nil {
+			return a < b
+		}
+		return chs[i] < chs[j]
+	})
+}
+
 type Modem interface {
 	Name() string
 	// Fetch the status of the modem using implementation specific means.  The
@@ -69,7 +119,10 @@ type Modem interface {
 // contain expected results.
 type NewFunc func(ctx context.Context, path string) Modem
 
-var modems []NewFunc
+var (
+	modems []NewFunc
+	models = map[string]func() Modem{}
+)
 
 // New will walk the list of registered cable modems, and returns an instance
 // if any probers return successful.  Nil is returned if no probers succeed.
@@ -95,3 +148,30 @@ func New(ctx context.Context, path string) Modem {
 func Register(f NewFunc) {
 	modems = append(modems, f)
 }
+
+// RegisterModel allows Modem implementations to register a constructor under
+// their model name, so users can choose a model instead of relying on
+// autodetect.  It is usually called from a package init() alongside Register.
+func RegisterModel(name string, f func() Modem) {
+	models[name] = f
+}
+
+// NewModel returns an instance of the named model that fetches from its
+// default URL, or nil if no model is registered with that name.
+func NewModel(name string) Modem {
+	f, ok := models[name]
+	if !ok {
+		return nil
+	}
+	return f()
+}
+
+// Models returns the sorted names of all registered models.
+func Models() []string {
+	var names []string
+	for n := range models {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -30,8 +30,10 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -69,19 +71,53 @@ type loginResponse struct {
 	} `json:"LoginResponse"`
 }
 
-// JSON payload for getting downstream/upstream info.
-// From poking around there are other HNAP endpoints that
-// can be queried but not sure if they are all too useful.
+// JSON payload for getting, in one call, everything the status pages show:
+// the startup sequence, connection info, device status, downstream/upstream
+// info and the event log.
 type status struct {
 	HNAPs struct {
+		StartupSequence       string `json:"GetCustomerStatusStartupSequence"`
+		ConnectionInfo        string `json:"GetCustomerStatusConnectionInfo"`
+		DeviceStatus          string `json:"GetArrisDeviceStatus"`
 		DownstreamChannelInfo string `json:"GetCustomerStatusDownstreamChannelInfo"`
 		UpstreamChannelInfo   string `json:"GetCustomerStatusUpstreamChannelInfo"`
+		Log                   string `json:"GetCustomerStatusLog"`
 	} `json:"GetMultipleHNAPs"`
 }
 
-// Response containing downstream/upstream info
+// Response to status.  Each Result is "OK" if that call succeeded, and empty
+// if it wasn't answered, as in responses recorded before it was asked for.
 type statusResponse struct {
 	HNAPsResponse struct {
+		StartupSequence struct {
+			DSFreq                   string `json:"CustomerConnDSFreq"`
+			DSComment                string `json:"CustomerConnDSComment"`
+			ConnectivityStatus       string `json:"CustomerConnConnectivityStatus"`
+			ConnectivityComment      string `json:"CustomerConnConnectivityComment"`
+			BootStatus               string `json:"CustomerConnBootStatus"`
+			BootComment              string `json:"CustomerConnBootComment"`
+			ConfigurationFileStatus  string `json:"CustomerConnConfigurationFileStatus"`
+			ConfigurationFileComment string `json:"CustomerConnConfigurationFileComment"`
+			SecurityStatus           string `json:"CustomerConnSecurityStatus"`
+			SecurityComment          string `json:"CustomerConnSecurityComment"`
+			Result                   string `json:"GetCustomerStatusStartupSequenceResult"`
+		} `json:"GetCustomerStatusStartupSequenceResponse"`
+		ConnectionInfo struct {
+			SystemUpTime  string `json:"CustomerConnSystemUpTime"`
+			SystemTime    string `json:"CustomerCurSystemTime"`
+			NetworkAccess string `json:"CustomerConnNetworkAccess"`
+			Result        string `json:"GetCustomerStatusConnectionInfoResult"`
+		} `json:"GetCustomerStatusConnectionInfoResponse"`
+		DeviceStatus struct {
+			FirmwareVersion string `json:"FirmwareVersion"`
+			Result          string `json:"GetArrisDeviceStatusResult"`
+		} `json:"GetArrisDeviceStatusResponse"`
+		Log struct {
+			// List is the event log, entries separated by }-{ and
+			// fields by ^.
+			List   string `json:"CustomerStatusLogList"`
+			Result string `json:"GetCustomerStatusLogResult"`
+		} `json:"GetCustomerStatusLogResponse"`
 		Downstream struct {
 			Info   string `json:"CustomerConnDownstreamChannel"`
 			Result string `json:"GetCustomerStatusDownstreamChannelInfoResult"`
@@ -94,16 +130,57 @@ type statusResponse struct {
 	} `json:"GetMultipleHNAPsResponse"`
 }
 
+// JSON payload for rebooting, as sent by the Configuration page's Reboot
+// button.
+type configuration struct {
+	SetArrisConfigurationInfo struct {
+		Action       string `json:"Action"`
+		SetEEEEnable string `json:"SetEEEEnable"`
+		LEDStatus    string `json:"LED_Status"`
+	} `json:"SetArrisConfigurationInfo"`
+}
+
+type configurationResponse struct {
+	SetArrisConfigurationInfoResponse struct {
+		Result string `json:"SetArrisConfigurationInfoResult"`
+	} `json:"SetArrisConfigurationInfoResponse"`
+}
+
+// JSON payload for restoring factory defaults, as sent by the Security
+// page's Restore Factory Defaults button.
+type securitySettings struct {
+	SetStatusSecuritySettings struct {
+		Action string `json:"MotoStatusSecurityAction"`
+		XXX    string `json:"MotoStatusSecXXX"`
+	} `json:"SetStatusSecuritySettings"`
+}
+
+type securitySettingsResponse struct {
+	SetStatusSecuritySettingsResponse struct {
+		Result string `json:"SetStatusSecuritySettingsResult"`
+	} `json:"SetStatusSecuritySettingsResponse"`
+}
+
+// maxAge is how long Info and Events reuse the last status response, so
+// fetching both takes one round trip.
+const maxAge = 30 * time.Second
+
 type s33 struct {
 	fakeData []byte
+	// hnapURL is the modem's HNAP endpoint.
+	hnapURL string
+
+	mu     sync.Mutex
+	last   *statusResponse
+	lastAt time.Time
 }
 
-func (s33) Name() string { return "S33" }
+func (*s33) Name() string { return "S33" }
 
 // New returns a modem.Modem that scrapes S33 formatted data at the default
 // URL.
 func New() modem.Modem {
-	return &s33{}
+	return &s33{hnapURL: hnapURL}
 }
 
 // NewFakeData returns a modem.Modem that will parse S33 formatted data
@@ -120,27 +197,106 @@ func NewFakeData(path string) (modem.Modem, error) {
 // sb.fakeData is not nil, the fake data is parsed.  If it is nil, then an
 // HTTP request is made to the default signal URL of a S33.
 func (sb *s33) Status(ctx context.Context) (*modem.Signal, error) {
-	if sb.fakeData != nil {
-		status := statusResponse{}
-		json.Unmarshal(sb.fakeData, &status)
-		return parseStatus(&status)
+	r, err := sb.fetch(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(r)
+}
+
+// Info implements modem.Describer.
+func (sb *s33) Info(ctx context.Context) (*modem.Info, error) {
+	r, err := sb.fetch(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(r)
+}
+
+// Events implements modem.EventLogger.
+func (sb *s33) Events(ctx context.Context) ([]modem.Event, error) {
+	r, err := sb.fetch(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.HNAPsResponse.Log.Result == "" {
+		return nil, errors.New("no event log in the status response")
 	}
+	return parseLog(r.HNAPsResponse.Log.List), nil
+}
 
-	rc, err := getStatus(ctx)
+// fetch returns the status response, parsed from sb.fakeData if set.  If
+// reuse is set, a response fetched within maxAge is returned instead of
+// fetching again.
+func (sb *s33) fetch(ctx context.Context, reuse bool) (*statusResponse, error) {
+	if sb.fakeData != nil {
+		status := &statusResponse{}
+		json.Unmarshal(sb.fakeData, status)
+		return status, nil
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if reuse && sb.last != nil && time.Since(sb.lastAt) < maxAge {
+		return sb.last, nil
+	}
+	r, err := getStatus(ctx, sb.hnapURL)
 	if err != nil {
 		return nil, err
 	}
-	return parseStatus(rc)
+	sb.last, sb.lastAt = r, time.Now()
+	return r, nil
+}
+
+// Reboot implements modem.Controller.
+func (sb *s33) Reboot(ctx context.Context) error {
+	if sb.fakeData != nil {
+		return modem.ErrFakeData
+	}
+	req := configuration{}
+	req.SetArrisConfigurationInfo.Action = "reboot"
+	resp := &configurationResponse{}
+	if err := call(ctx, sb.hnapURL, "SetArrisConfigurationInfo", req, resp); err != nil {
+		return err
+	}
+	if r := resp.SetArrisConfigurationInfoResponse.Result; r != "OK" {
+		return fmt.Errorf("reboot failed: %q", r)
+	}
+	return nil
+}
+
+// ResetToDefaults implements modem.Controller.
+func (sb *s33) ResetToDefaults(ctx context.Context) error {
+	if sb.fakeData != nil {
+		return modem.ErrFakeData
+	}
+	req := securitySettings{}
+	req.SetStatusSecuritySettings.Action = "2"
+	req.SetStatusSecuritySettings.XXX = "XXX"
+	resp := &securitySettingsResponse{}
+	if err := call(ctx, sb.hnapURL, "SetStatusSecuritySettings", req, resp); err != nil {
+		return err
+	}
+	if r := resp.SetStatusSecuritySettingsResponse.Result; r != "OK" {
+		return fmt.Errorf("reset to defaults failed: %q", r)
+	}
+	return nil
 }
 
 func init() {
 	modem.Register(probe)
+	modem.RegisterModel("S33", New)
 }
 
 func isS33(b []byte) bool {
 	return bytes.Contains(b, []byte(`<span id="thisModelNumberIs"> S33 </span>`))
 }
 
+// isS33Status reports whether b is a GetMultipleHNAPs response, as saved by
+// -record_dir, that NewFakeData can parse.
+func isS33Status(b []byte) bool {
+	return bytes.Contains(b, []byte(`"GetCustomerStatusDownstreamChannelInfoResponse"`))
+}
+
 func probe(ctx context.Context, path string) modem.Modem {
 	if path != "" {
 		b, err := ioutil.ReadFile(path)
@@ -148,7 +304,7 @@ func probe(ctx context.Context, path string) modem.Modem {
 			glog.Errorf("Failed to read %q: %v", path, err)
 			return nil
 		}
-		if isS33(b) {
+		if isS33(b) || isS33Status(b) {
 			m, err := NewFakeData(path)
 			if err != nil {
 				glog.Errorf("Failed to create fake S33: %v", err)
@@ -192,17 +348,25 @@ func getID(ctx context.Context) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-func getStatus(ctx context.Context) (*statusResponse, error) {
+func getStatus(ctx context.Context, hnapURL string) (*statusResponse, error) {
+	response := &statusResponse{}
+	err := call(ctx, hnapURL, "GetMultipleHNAPs", status{}, response)
+	return response, err
+}
+
+// call logs in to the HNAP endpoint at hnapURL and calls action with the JSON
+// payload v, decoding the response into response.
+func call(ctx context.Context, hnapURL, action string, v, response interface{}) error {
 	// Cookies are used for auth after login completes
 	// TODO: Store the cookies and only re-auth if we need to
-	cookies, err := auth(ctx)
+	cookies, err := auth(ctx, hnapURL)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	jar, err := cookiejar.New(nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	client := httpClient()
@@ -210,10 +374,10 @@ func getStatus(ctx context.Context) (*statusResponse, error) {
 	urlPath, _ := url.Parse((hnapURL))
 	client.Jar.SetCookies(urlPath, cookies)
 
-	body, _ := json.Marshal(status{})
+	body, _ := json.Marshal(v)
 	req, err := http.NewRequest("POST", hnapURL, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var pKey string
@@ -222,23 +386,18 @@ func getStatus(ctx context.Context) (*statusResponse, error) {
 			pKey = i.Value
 		}
 	}
-	hnap := hnapAuth(pKey, "GetMultipleHNAPs")
+	hnap := hnapAuth(pKey, action)
 
 	req = req.WithContext(ctx)
-	req.Header.Add("SOAPAction", fmt.Sprintf("%s/GetMultipleHNAPs", hnapBase))
+	req.Header.Add("SOAPAction", fmt.Sprintf("%s/%s", hnapBase, action))
 	req.Header.Add("HNAP_AUTH", hnap)
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
-
+	b, err := httpCall(client, req)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	response := &statusResponse{}
-	b, err := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(b, response)
-	return response, err
+	return json.Unmarshal(b, response)
 }
 
 func privateKey(l loginResponse) string {
@@ -254,16 +413,10 @@ func hnapAuth(privateKey string, action string) string {
 	return fmt.Sprintf("%s %d", encrypt(privateKey, fmt.Sprintf("%d%s", t, fmt.Sprintf("%s/%s", hnapBase, action))), t)
 }
 
-func auth(ctx context.Context) ([]*http.Cookie, error) {
+func auth(ctx context.Context, hnapURL string) ([]*http.Cookie, error) {
 	// The S33 forces https via a redirect but also uses a self-signed
 	// certificates from Arris.
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	client := &http.Client{
-		Transport: transport,
-	}
+	client := httpClient()
 
 	auth := login{}
 	auth.Login.Action = "request"
@@ -366,7 +519,7 @@ func httpClient() *http.Client {
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	return &http.Client{Transport: transport}
+	return modem.Client(transport, 0)
 }
 
 func httpCall(client *http.Client, req *http.Request) ([]byte, error) {
@@ -374,6 +527,7 @@ func httpCall(client *http.Client, req *http.Request) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	return ioutil.ReadAll(resp.Body)
 }
 
@@ -392,6 +546,73 @@ func parseStatus(s *statusResponse) (*modem.Signal, error) {
 	}, nil
 }
 
+// parseInfo maps the startup sequence, connection info and device status of
+// s.  The startup sequence follows the Startup Procedure table of the status
+// page.
+func parseInfo(s *statusResponse) (*modem.Info, error) {
+	h := &s.HNAPsResponse
+	if h.StartupSequence.Result == "" && h.ConnectionInfo.Result == "" && h.DeviceStatus.Result == "" {
+		return nil, errors.New("no device info in the status response")
+	}
+	info := &modem.Info{
+		SoftwareVersion: h.DeviceStatus.FirmwareVersion,
+		SystemTime:      modem.ParseEventTime(h.ConnectionInfo.SystemTime),
+	}
+	if h.ConnectionInfo.SystemUpTime != "" {
+		d, err := modem.ParseUptime(h.ConnectionInfo.SystemUpTime)
+		if err != nil {
+			return nil, err
+		}
+		info.Uptime = d
+	}
+	if ss := h.StartupSequence; ss.Result != "" {
+		dsStatus := ss.DSFreq
+		if dsStatus != "" {
+			dsStatus += " Hz"
+		}
+		info.Provisioning = &modem.Provisioning{
+			Status: ss.BootComment,
+			Steps: []modem.ProvisioningStep{
+				{Name: "Acquire Downstream Channel", Status: dsStatus, Comment: ss.DSComment},
+				{Name: "Connectivity State", Status: ss.ConnectivityStatus, Comment: ss.ConnectivityComment},
+				{Name: "Boot State", Status: ss.BootStatus, Comment: ss.BootComment},
+				{Name: "Configuration File", Status: ss.ConfigurationFileStatus, Comment: ss.ConfigurationFileComment},
+				{Name: "Security", Status: ss.SecurityStatus, Comment: ss.SecurityComment},
+			},
+		}
+	}
+	return info, nil
+}
+
+// parseLog parses the event log list.  Each entry is a sequence number,
+// time, date, priority and description, and is sorted by sequence number to
+// put it oldest first.
+func parseLog(list string) []modem.Event {
+	type entry struct {
+		seq int
+		e   modem.Event
+	}
+	var entries []entry
+	for _, row := range strings.Split(list, "}-{") {
+		cols := strings.SplitN(row, "^", 5)
+		if len(cols) != 5 {
+			continue
+		}
+		seq, _ := strconv.Atoi(strings.TrimSpace(cols[0]))
+		entries = append(entries, entry{seq, modem.Event{
+			Time:     modem.ParseEventTime(cols[2] + " " + cols[1]),
+			Priority: modem.ParsePriority(cols[3]),
+			Text:     strings.TrimSpace(cols[4]),
+		}})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	var events []modem.Event
+	for _, en := range entries {
+		events = append(events, en.e)
+	}
+	return events
+}
+
 func parseDownstreamTable(t string) (map[modem.Channel]*modem.Downstream, error) {
 	m := map[modem.Channel]*modem.Downstream{}
 	rows := strings.Split(t, "|+|")
@@ -411,6 +632,7 @@ func parseDownstreamTable(t string) (map[modem.Channel]*modem.Downstream, error)
 				// Channel
 			case 1:
 				// Lock Status
+				d.Status = col
 			case 2:
 				// Modulation
 				d.Modulation = col
@@ -15,12 +15,19 @@
 package s33
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/wathiede/surfer/modem"
 )
@@ -59,6 +66,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"2": {
 				Modulation:    "QAM256",
@@ -67,6 +75,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"3": {
 				Modulation:    "QAM256",
@@ -75,6 +84,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"4": {
 				Modulation:    "QAM256",
@@ -83,6 +93,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"5": {
 				Modulation:    "QAM256",
@@ -91,6 +102,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"6": {
 				Modulation:    "QAM256",
@@ -99,6 +111,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"7": {
 				Modulation:    "QAM256",
@@ -107,6 +120,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"8": {
 				Modulation:    "QAM256",
@@ -115,6 +129,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"9": {
 				Modulation:    "QAM256",
@@ -123,6 +138,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"10": {
 				Modulation:    "QAM256",
@@ -131,6 +147,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"11": {
 				Modulation:    "QAM256",
@@ -139,6 +156,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"12": {
 				Modulation:    "QAM256",
@@ -147,6 +165,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"13": {
 				Modulation:    "QAM256",
@@ -155,6 +174,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"14": {
 				Modulation:    "QAM256",
@@ -163,6 +183,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"15": {
 				Modulation:    "QAM256",
@@ -171,6 +192,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"16": {
 				Modulation:    "QAM256",
@@ -179,6 +201,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"17": {
 				Modulation:    "QAM256",
@@ -187,6 +210,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"18": {
 				Modulation:    "QAM256",
@@ -195,6 +219,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"19": {
 				Modulation:    "QAM256",
@@ -203,6 +228,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           43,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"20": {
 				Modulation:    "QAM256",
@@ -211,6 +237,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"21": {
 				Modulation:    "QAM256",
@@ -219,6 +246,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"22": {
 				Modulation:    "QAM256",
@@ -227,6 +255,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"23": {
 				Modulation:    "QAM256",
@@ -235,6 +264,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"24": {
 				Modulation:    "QAM256",
@@ -243,6 +273,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"25": {
 				Modulation:    "OFDM PLC",
@@ -251,6 +282,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   590747125,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"26": {
 				Modulation:    "QAM256",
@@ -259,6 +291,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"27": {
 				Modulation:    "QAM256",
@@ -267,6 +300,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"28": {
 				Modulation:    "QAM256",
@@ -275,6 +309,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"29": {
 				Modulation:    "QAM256",
@@ -283,6 +318,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"30": {
 				Modulation:    "QAM256",
@@ -291,6 +327,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"31": {
 				Modulation:    "QAM256",
@@ -299,6 +336,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           41,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 			"32": {
 				Modulation:    "QAM256",
@@ -307,6 +345,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           42,
 				Correctable:   0,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 		},
 		Upstream: map[modem.Channel]*modem.Upstream{
@@ -343,3 +382,228 @@ func TestParseStatus(t *testing.T) {
 		t.Errorf("Got:\n%s\nWant:\n%s", g, w)
 	}
 }
+
+func TestInfoAndEvents(t *testing.T) {
+	m, err := NewFakeData("testdata/S33-status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	info, err := m.(modem.Describer).Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{
+		SoftwareVersion: "TB01.03.001.10_012022_212.S3",
+		Uptime:          39*time.Hour + 47*time.Minute + 37*time.Second,
+		SystemTime:      time.Date(2021, 6, 3, 9, 15, 27, 0, time.Local),
+		Provisioning: &modem.Provisioning{
+			Status: "Operational",
+			Steps: []modem.ProvisioningStep{
+				{Name: "Acquire Downstream Channel", Status: "441000000 Hz", Comment: "Locked"},
+				{Name: "Connectivity State", Status: "OK", Comment: "Operational"},
+				{Name: "Boot State", Status: "OK", Comment: "Operational"},
+				{Name: "Configuration File", Status: "OK"},
+				{Name: "Security", Status: "Enabled", Comment: "BPI+"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Info got %+v, want %+v", info, want)
+	}
+
+	events, err := m.(modem.EventLogger).Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix := ";CM-MAC=00:00:5e:00:53:31;CMTS-MAC=00:00:5e:00:53:32;CM-QOS=1.1;CM-VER=3.1;"
+	wantEvents := []modem.Event{
+		{Priority: modem.PriorityCritical, Text: "SYNC Timing Synchronization failure - Loss of Sync" + suffix},
+		{Priority: modem.PriorityNotice, Text: "Honoring MDD; IP provisioning mode = IPv6"},
+		{Time: time.Date(2021, 6, 2, 17, 27, 50, 0, time.Local), Priority: modem.PriorityCritical, Text: "No Ranging Response received - T3 time-out" + suffix},
+		{Time: time.Date(2021, 6, 2, 17, 28, 19, 0, time.Local), Priority: modem.PriorityWarning, Text: "Dynamic Range Window violation"},
+		{Time: time.Date(2021, 6, 3, 8, 2, 44, 0, time.Local), Priority: modem.PriorityCritical, Text: "Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out" + suffix},
+	}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("Events got\n%+v\nwant\n%+v", events, wantEvents)
+	}
+
+	// Responses recorded before the batch grew have neither.
+	old, err := NewFakeData("testdata/S33-signal.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.(modem.Describer).Info(ctx); err == nil {
+		t.Errorf("Info of a response without device info succeeded, want error")
+	}
+	if _, err := old.(modem.EventLogger).Events(ctx); err == nil {
+		t.Errorf("Events of a response without the event log succeeded, want error")
+	}
+}
+
+// hnapServer is a stand-in for the S33's HNAP endpoint.  It checks the login
+// handshake and HNAP_AUTH of every call, and answers calls from responses,
+// keyed by action.
+type hnapServer struct {
+	password  string
+	responses map[string]string
+
+	mu    sync.Mutex
+	calls []string
+	body  map[string]json.RawMessage
+}
+
+const (
+	testChallenge = "CHALLENGE"
+	testPublicKey = "PUBLICKEY"
+	testUID       = "UID"
+)
+
+func (h *hnapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/HNAP1/" {
+		http.NotFound(w, r)
+		return
+	}
+	action := strings.TrimPrefix(r.Header.Get("SOAPAction"), hnapBase+"/")
+	privateKey := encrypt(testPublicKey+h.password, testChallenge)
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if action == "Login" {
+		var l login
+		if err := json.Unmarshal(body["Login"], &l.Login); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if l.Login.Action == "request" {
+			fmt.Fprintf(w, `{"LoginResponse":{"Challenge":%q,"Cookie":%q,"PublicKey":%q,"LoginResult":"OK"}}`, testChallenge, testUID, testPublicKey)
+			return
+		}
+		if l.Login.LoginPassword != encrypt(privateKey, testChallenge) || !h.validAuth(r, privateKey, action) {
+			fmt.Fprint(w, `{"LoginResponse":{"LoginResult":"FAILED"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"LoginResponse":{"LoginResult":"OK"}}`)
+		return
+	}
+	if c, err := r.Cookie("uid"); err != nil || c.Value != testUID || !h.validAuth(r, privateKey, action) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mu.Lock()
+	h.calls = append(h.calls, action)
+	h.body = body
+	h.mu.Unlock()
+	resp, ok := h.responses[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, resp)
+}
+
+// validAuth reports whether r's HNAP_AUTH header signs action with key.
+func (h *hnapServer) validAuth(r *http.Request, key, action string) bool {
+	f := strings.Fields(r.Header.Get("HNAP_AUTH"))
+	return len(f) == 2 && f[0] == encrypt(key, f[1]+hnapBase+"/"+action)
+}
+
+func TestBatch(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/S33-status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &hnapServer{
+		password:  *password,
+		responses: map[string]string{"GetMultipleHNAPs": string(b)},
+	}
+	ts := httptest.NewTLSServer(h)
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := &s33{hnapURL: ts.URL + "/HNAP1/"}
+	s, err := sb.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(s.Downstream) == 0 || len(s.Upstream) == 0 {
+		t.Errorf("Status got %d downstream and %d upstream channels, want some", len(s.Downstream), len(s.Upstream))
+	}
+	var got map[string]string
+	if err := json.Unmarshal(h.body["GetMultipleHNAPs"], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"GetCustomerStatusStartupSequence":       "",
+		"GetCustomerStatusConnectionInfo":        "",
+		"GetArrisDeviceStatus":                   "",
+		"GetCustomerStatusDownstreamChannelInfo": "",
+		"GetCustomerStatusUpstreamChannelInfo":   "",
+		"GetCustomerStatusLog":                   "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Batched %v, want %v", got, want)
+	}
+
+	// Info and Events reuse the response Status just fetched.
+	if _, err := sb.Info(ctx); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if _, err := sb.Events(ctx); err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if want := []string{"GetMultipleHNAPs"}; !reflect.DeepEqual(h.calls, want) {
+		t.Errorf("Called %q, want %q", h.calls, want)
+	}
+	sb.lastAt = sb.lastAt.Add(-maxAge)
+	if _, err := sb.Events(ctx); err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if got := len(h.calls); got != 2 {
+		t.Errorf("Called %d times, want a stale response fetched again", got)
+	}
+}
+
+func TestControl(t *testing.T) {
+	h := &hnapServer{
+		password: *password,
+		responses: map[string]string{
+			"SetArrisConfigurationInfo": `{"SetArrisConfigurationInfoResponse":{"SetArrisConfigurationInfoResult":"OK","SetArrisConfigurationInfoAction":"REBOOT"}}`,
+			"SetStatusSecuritySettings": `{"SetStatusSecuritySettingsResponse":{"SetStatusSecuritySettingsResult":"OK"}}`,
+		},
+	}
+	ts := httptest.NewTLSServer(h)
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := &s33{hnapURL: ts.URL + "/HNAP1/"}
+	if err := sb.Reboot(ctx); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if got, want := string(h.body["SetArrisConfigurationInfo"]), `{"Action":"reboot","SetEEEEnable":"","LED_Status":""}`; got != want {
+		t.Errorf("Reboot sent %s, want %s", got, want)
+	}
+	if err := sb.ResetToDefaults(ctx); err != nil {
+		t.Fatalf("ResetToDefaults: %v", err)
+	}
+	if got, want := string(h.body["SetStatusSecuritySettings"]), `{"MotoStatusSecurityAction":"2","MotoStatusSecXXX":"XXX"}`; got != want {
+		t.Errorf("ResetToDefaults sent %s, want %s", got, want)
+	}
+	if want := []string{"SetArrisConfigurationInfo", "SetStatusSecuritySettings"}; !reflect.DeepEqual(h.calls, want) {
+		t.Errorf("Called %q, want %q", h.calls, want)
+	}
+
+	h.responses["SetArrisConfigurationInfo"] = `{"SetArrisConfigurationInfoResponse":{"SetArrisConfigurationInfoResult":"ERROR"}}`
+	if err := sb.Reboot(ctx); err == nil {
+		t.Errorf("Reboot refused by the modem succeeded, want error")
+	}
+	h.password = "wrong"
+	if err := sb.Reboot(ctx); err == nil {
+		t.Errorf("Reboot with the wrong password succeeded, want error")
+	}
+	if err := (&s33{fakeData: []byte("{}")}).Reboot(ctx); err != modem.ErrFakeData {
+		t.Errorf("Reboot of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
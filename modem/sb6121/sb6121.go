@@ -18,9 +18,9 @@ package sb6121
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -33,7 +33,14 @@ import (
 	"github.com/wathiede/surfer/modem"
 )
 
-const signalURL = "http://192.168.100.1/cmSignalData.htm"
+const (
+	baseURL   = "http://192.168.100.1"
+	signalURL = baseURL + "/cmSignalData.htm"
+	// startupPath is the status frame of startup.html.
+	startupPath = "/indexData.htm"
+	addressPath = "/cmAddressData.htm"
+	logPath     = "/cmLogsData.htm"
+)
 
 type downstreamStat struct {
 	frequency  string
@@ -59,6 +66,8 @@ type upstreamStat struct {
 
 type sb6121 struct {
 	fakeData []byte
+	// base is the URL of the modem's admin pages.
+	base string
 }
 
 func (sb6121) Name() string { return "SB6121" }
@@ -103,12 +112,13 @@ func probe(ctx context.Context, path string) modem.Modem {
 
 func init() {
 	modem.Register(probe)
+	modem.RegisterModel("SB6121", New)
 }
 
 // New returns a modem.Modem that scrapes SB6121 formatted data at the default
 // URL.
 func New() modem.Modem {
-	return &sb6121{}
+	return &sb6121{base: baseURL}
 }
 
 // NewFakeData returns a modem.Modem that will parse SB6121 formatted data
@@ -124,7 +134,7 @@ func NewFakeData(path string) (modem.Modem, error) {
 func get(ctx context.Context) (io.ReadCloser, error) {
 	glog.V(2).Infof("Start Probing %q", signalURL)
 	defer glog.V(2).Infof("Done Probing %q", signalURL)
-	c := http.Client{Timeout: 10 * time.Second}
+	c := modem.Client(nil, 10*time.Second)
 	resp, err := c.Get(signalURL)
 	if err != nil {
 		return nil, err
@@ -147,6 +157,146 @@ func (sb *sb6121) Status(ctx context.Context) (*modem.Signal, error) {
 	return parseStatus(rc)
 }
 
+// Info implements modem.Describer, from the startup and address pages.
+func (sb *sb6121) Info(ctx context.Context) (*modem.Info, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := modem.GetPage(ctx, sb.base+startupPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseStartup(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	b, err = modem.GetPage(ctx, sb.base+addressPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseAddress(bytes.NewReader(b), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Events implements modem.EventLogger.
+func (sb *sb6121) Events(ctx context.Context) ([]modem.Event, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := modem.GetPage(ctx, sb.base+logPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(bytes.NewReader(b))
+}
+
+// tableRows returns the text of the cells of every row with cells, skipping
+// heading rows.
+func tableRows(r io.Reader) ([][]string, error) {
+	n, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	for _, tr := range cascadia.MustCompile("center > table tr").MatchAll(n) {
+		var row []string
+		for _, td := range cascadia.MustCompile("td").MatchAll(tr) {
+			row = append(row, htmlutil.GetText(td))
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// parseStartup parses the startup steps, overall status and uptime from the
+// startup page.
+func parseStartup(r io.Reader) (*modem.Info, error) {
+	rows, err := tableRows(r)
+	if err != nil {
+		return nil, err
+	}
+	info := &modem.Info{Provisioning: &modem.Provisioning{}}
+	for _, row := range rows {
+		if len(row) != 2 {
+			continue
+		}
+		switch row[0] {
+		case "Cable Modem Status":
+			info.Provisioning.Status = row[1]
+		case "System Up Time":
+			d, err := modem.ParseUptime(row[1])
+			if err != nil {
+				return nil, err
+			}
+			info.Uptime = d
+		case "Current Time and Date", "Computers Detected":
+		default:
+			info.Provisioning.Steps = append(info.Provisioning.Steps, modem.ProvisioningStep{Name: row[0], Status: row[1]})
+		}
+	}
+	if info.Provisioning.Status == "" {
+		return nil, fmt.Errorf("no cable modem status found")
+	}
+	return info, nil
+}
+
+// parseAddress adds the serial number, HFC MAC address and configuration
+// file name from the address page to info.
+func parseAddress(r io.Reader, info *modem.Info) error {
+	rows, err := tableRows(r)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, row := range rows {
+		if len(row) != 2 {
+			continue
+		}
+		switch row[0] {
+		case "Serial Number":
+			info.SerialNumber = row[1]
+		case "HFC MAC Address":
+			info.MACAddress = row[1]
+		case "Configuration File Name":
+			info.ConfigFile = row[1]
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no cable modem addresses found")
+	}
+	return nil
+}
+
+// parseLog parses the log page.  It lists events newest first, with the
+// DOCSIS event ID as the code.
+func parseLog(r io.Reader) ([]modem.Event, error) {
+	rows, err := tableRows(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []modem.Event
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+		if len(row) != 4 {
+			continue
+		}
+		events = append(events, modem.Event{
+			Time:     modem.ParseEventTime(row[0]),
+			Priority: modem.ParsePriority(row[1]),
+			ID:       row[2],
+			Text:     row[3],
+		})
+	}
+	return events, nil
+}
+
 func parseStatus(r io.Reader) (*modem.Signal, error) {
 	n, err := html.Parse(r)
 	if err != nil {
@@ -185,7 +335,7 @@ func parseStatus(r io.Reader) (*modem.Signal, error) {
 				d := signal.Downstream[ch]
 				d.Unerrored = s.unerrored
 				d.Correctable = s.correctable
-				d.Unerrored = s.uncorrectable
+				d.Uncorrectable = s.uncorrectable
 			}
 		}
 	}
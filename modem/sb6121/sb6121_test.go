@@ -15,10 +15,15 @@
 package sb6121
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/wathiede/surfer/modem"
 )
@@ -44,8 +49,8 @@ func TestParseStatus(t *testing.T) {
 				Modulation:    "QAM256",
 				PowerLevel:    9,
 				SNR:           37,
-				Uncorrectable: 0,
-				Unerrored:     110946,
+				Uncorrectable: 110946,
+				Unerrored:     46834464779,
 			},
 			"11": {
 				Correctable:   1.492144e+06,
@@ -53,8 +58,8 @@ func TestParseStatus(t *testing.T) {
 				Modulation:    "QAM256",
 				PowerLevel:    9,
 				SNR:           37,
-				Uncorrectable: 0,
-				Unerrored:     262486,
+				Uncorrectable: 262486,
+				Unerrored:     46831592362,
 			},
 			"12": {
 				Correctable:   19024,
@@ -62,8 +67,8 @@ func TestParseStatus(t *testing.T) {
 				Modulation:    "QAM256",
 				PowerLevel:    9,
 				SNR:           37,
-				Uncorrectable: 0,
-				Unerrored:     59971,
+				Uncorrectable: 59971,
+				Unerrored:     46833546650,
 			},
 			"9": {
 				Correctable:   21163,
@@ -71,8 +76,8 @@ func TestParseStatus(t *testing.T) {
 				Modulation:    "QAM256",
 				PowerLevel:    10,
 				SNR:           37,
-				Uncorrectable: 0,
-				Unerrored:     111242,
+				Uncorrectable: 111242,
+				Unerrored:     46834465469,
 			},
 		},
 		Upstream: map[modem.Channel]*modem.Upstream{
@@ -113,3 +118,125 @@ func TestParseStatus(t *testing.T) {
 		t.Errorf("Got:\n%s\nWant:\n%s", g, w)
 	}
 }
+
+func TestParseStartup(t *testing.T) {
+	r, err := os.Open("testdata/SB6121-startup.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := parseStartup(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{
+		Uptime: 3*24*time.Hour + 4*time.Hour + 5*time.Minute + 6*time.Second,
+		Provisioning: &modem.Provisioning{
+			Status: "Operational",
+			Steps: []modem.ProvisioningStep{
+				{Name: "DOCSIS Downstream Channel Acquisition", Status: "Done"},
+				{Name: "DOCSIS Ranging", Status: "Done"},
+				{Name: "Establish IP Connectivity using DHCP", Status: "Done"},
+				{Name: "Establish Time Of Day", Status: "Done"},
+				{Name: "Transfer Operational Parameters through TFTP", Status: "Done"},
+				{Name: "Register Connection", Status: "Done"},
+				{Name: "Initialize Baseline Privacy", Status: "Done"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStartup got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseStartup(strings.NewReader("<html></html>")); err == nil {
+		t.Errorf("parseStartup of an empty page succeeded, want error")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	r, err := os.Open("testdata/SB6121-address.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := &modem.Info{}
+	if err := parseAddress(r, got); err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{
+		SerialNumber: "000000000000",
+		MACAddress:   "00:00:5e:00:53:21",
+		ConfigFile:   "d11_m_sb6121_speedtier_c01.cm",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAddress got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseLog(t *testing.T) {
+	r, err := os.Open("testdata/SB6121-log.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := parseLog(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix := ";CM-MAC=00:00:5e:00:53:21;CMTS-MAC=00:00:5e:00:53:24;CM-QOS=1.1;CM-VER=3.0;"
+	want := []modem.Event{
+		{Priority: modem.PriorityCritical, ID: "T01.0", Text: "SYNC Timing Synchronization failure - Failed to acquire QAM/QPSK symbol timing;;CM-MAC=00:00:5e:00:53:21;CMTS-MAC=00:00:00:00:00:00;CM-QOS=1.0;CM-VER=3.0;"},
+		{Time: time.Date(2020, 3, 7, 16, 12, 40, 0, time.Local), Priority: modem.PriorityNotice, ID: "I401.0", Text: "TLV-11 - unrecognized OID" + suffix},
+		{Time: time.Date(2020, 3, 10, 18, 1, 40, 0, time.Local), Priority: modem.PriorityCritical, ID: "R02.0", Text: "No Ranging Response received - T3 time-out" + suffix},
+		{Time: time.Date(2020, 3, 10, 18, 2, 11, 0, time.Local), Priority: modem.PriorityCritical, ID: "R03.0", Text: "Ranging Request Retries exhausted" + suffix},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLog got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestInfoAndEvents(t *testing.T) {
+	pages := map[string]string{
+		startupPath: "testdata/SB6121-startup.html",
+		addressPath: "testdata/SB6121-address.html",
+		logPath:     "testdata/SB6121-log.html",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, p)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := &sb6121{base: ts.URL}
+	info, err := sb.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if info.Provisioning.Status != "Operational" || info.ConfigFile != "d11_m_sb6121_speedtier_c01.cm" {
+		t.Errorf("Info got status %q and config file %q, want both pages parsed", info.Provisioning.Status, info.ConfigFile)
+	}
+	events, err := sb.Events(ctx)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if got, want := len(events), 4; got != want {
+		t.Errorf("Events got %d, want %d", got, want)
+	}
+
+	delete(pages, addressPath)
+	if _, err := sb.Info(ctx); err == nil {
+		t.Errorf("Info without an address page succeeded, want error")
+	}
+	fake := &sb6121{fakeData: []byte("fake")}
+	if _, err := fake.Info(ctx); err != modem.ErrFakeData {
+		t.Errorf("Info of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+	if _, err := fake.Events(ctx); err != modem.ErrFakeData {
+		t.Errorf("Events of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
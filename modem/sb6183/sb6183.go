@@ -22,6 +22,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -33,10 +34,21 @@ import (
 	"github.com/wathiede/surfer/modem"
 )
 
-const signalURL = "http://192.168.100.1/"
+const (
+	baseURL   = "http://192.168.100.1"
+	signalURL = baseURL + "/"
+	// configPath is where the Configuration page's Reboot and Restore
+	// Factory Defaults buttons post to.
+	configPath = "/goform/RgConfiguration"
+	// infoPath is the Software page the status page links to.
+	infoPath     = "/RgSwInfo.asp"
+	eventLogPath = "/RgEventLog.asp"
+)
 
 type sb6183 struct {
 	fakeData []byte
+	// base is the URL of the modem's admin pages.
+	base string
 }
 
 func (sb6183) Name() string { return "SB6183" }
@@ -82,12 +94,13 @@ func probe(ctx context.Context, path string) modem.Modem {
 
 func init() {
 	modem.Register(probe)
+	modem.RegisterModel("SB6183", New)
 }
 
 // New returns a modem.Modem that scrapes SB6183 formatted data at the default
 // URL.
 func New() modem.Modem {
-	return &sb6183{}
+	return &sb6183{base: baseURL}
 }
 
 // NewFakeData returns a modem.Modem that will parse SB6183 formatted data
@@ -106,7 +119,7 @@ func get(ctx context.Context) (io.ReadCloser, error) {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := modem.Client(nil, 0).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +142,62 @@ func (sb *sb6183) Status(ctx context.Context) (*modem.Signal, error) {
 	return parseStatus(rc)
 }
 
+// Info implements modem.Describer.
+func (sb *sb6183) Info(ctx context.Context) (*modem.Info, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := modem.GetPage(ctx, sb.base+infoPath)
+	if err != nil {
+		return nil, err
+	}
+	return htmlutil.ParseInfoTable(bytes.NewReader(b))
+}
+
+// Events implements modem.EventLogger.
+func (sb *sb6183) Events(ctx context.Context) ([]modem.Event, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := modem.GetPage(ctx, sb.base+eventLogPath)
+	if err != nil {
+		return nil, err
+	}
+	return htmlutil.ParseEventTable(bytes.NewReader(b))
+}
+
+// Reboot implements modem.Controller.
+func (sb *sb6183) Reboot(ctx context.Context) error {
+	return sb.configure(ctx, url.Values{"Rebooting": {"1"}, "RestoreFactoryDefault": {"0"}})
+}
+
+// ResetToDefaults implements modem.Controller.
+func (sb *sb6183) ResetToDefaults(ctx context.Context) error {
+	return sb.configure(ctx, url.Values{"Rebooting": {"0"}, "RestoreFactoryDefault": {"1"}})
+}
+
+// configure submits the Configuration page form with v.
+func (sb *sb6183) configure(ctx context.Context, v url.Values) error {
+	if sb.fakeData != nil {
+		return modem.ErrFakeData
+	}
+	req, err := http.NewRequest("POST", sb.base+configPath, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := modem.Client(nil, 0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", configPath, resp.Status)
+	}
+	return nil
+}
+
 func parseStatus(r io.Reader) (*modem.Signal, error) {
 	n, err := html.Parse(r)
 	if err != nil {
@@ -174,6 +243,7 @@ func parseDownstreamTable(n *html.Node) (map[modem.Channel]*modem.Downstream, er
 				ch = modem.Channel(v)
 			case 1:
 				// Lock Status
+				d.Status = v
 			case 2:
 				// Modulation
 				d.Modulation = v
@@ -15,12 +15,19 @@
 package sb6183
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/wathiede/surfer/htmlutil"
 	"github.com/wathiede/surfer/modem"
 )
 
@@ -50,6 +57,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38.4,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"10": {
 				Correctable:   0,
@@ -59,6 +67,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37.1,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"11": {
 				Correctable:   3,
@@ -68,6 +77,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"12": {
 				Correctable:   3,
@@ -77,6 +87,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.9,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"13": {
 				Correctable:   5,
@@ -86,6 +97,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.7,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"14": {
 				Correctable:   10,
@@ -95,6 +107,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.7,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"15": {
 				Correctable:   8,
@@ -104,6 +117,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.6,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"16": {
 				Correctable:   7,
@@ -113,6 +127,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.7,
 				Uncorrectable: 9,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"2": {
 				Correctable:   0,
@@ -122,6 +137,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38.4,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"3": {
 				Correctable:   0,
@@ -131,6 +147,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38.3,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"4": {
 				Correctable:   0,
@@ -140,6 +157,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38.2,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"5": {
 				Correctable:   0,
@@ -149,6 +167,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"6": {
 				Correctable:   0,
@@ -158,6 +177,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37.7,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"7": {
 				Correctable:   0,
@@ -167,6 +187,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37.5,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"8": {
 				Correctable:   0,
@@ -176,6 +197,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37.3,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 			"9": {
 				Correctable:   3,
@@ -185,6 +207,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           37.2,
 				Uncorrectable: 0,
 				Unerrored:     0,
+				Status:        "Locked",
 			},
 		},
 		Upstream: map[modem.Channel]*modem.Upstream{
@@ -225,3 +248,132 @@ func TestParseStatus(t *testing.T) {
 		t.Errorf("Got:\n%s\nWant:\n%s", g, w)
 	}
 }
+
+func TestParseInfo(t *testing.T) {
+	r, err := os.Open("testdata/SB6183-swinfo.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := htmlutil.ParseInfoTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{
+		HardwareVersion: "V1.0",
+		SoftwareVersion: "D30CM-OSPREY-2.4.0.1-GA-02-NOSH",
+		SerialNumber:    "000000000000000000",
+		MACAddress:      "00:00:5e:00:53:0a",
+		Uptime:          2*24*time.Hour + 3*time.Hour + 10*time.Minute + 41*time.Second,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInfoTable got %+v, want %+v", got, want)
+	}
+
+	if _, err := htmlutil.ParseInfoTable(strings.NewReader("<html></html>")); err == nil {
+		t.Errorf("ParseInfoTable of an empty page succeeded, want error")
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	r, err := os.Open("testdata/SB6183-eventlog.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := htmlutil.ParseEventTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suffix := ";CM-MAC=00:00:5e:00:53:0a;CMTS-MAC=00:00:5e:00:53:0b;CM-QOS=1.1;CM-VER=3.0;"
+	want := []modem.Event{
+		{Priority: modem.PriorityCritical, Text: "No Ranging Response received - T3 time-out" + suffix},
+		{Time: time.Date(2016, 10, 3, 9, 37, 26, 0, time.Local), Priority: modem.PriorityNotice, Text: "TLV-11 - unrecognized OID" + suffix},
+		{Time: time.Date(2016, 10, 5, 7, 12, 3, 0, time.Local), Priority: modem.PriorityCritical, Text: "Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out" + suffix},
+		{Time: time.Date(2016, 10, 5, 12, 45, 6, 0, time.Local), Priority: modem.PriorityWarning, Text: "MDD message timeout" + suffix},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEventTable got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestInfoAndEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case infoPath:
+			http.ServeFile(w, r, "testdata/SB6183-swinfo.html")
+		case eventLogPath:
+			http.ServeFile(w, r, "testdata/SB6183-eventlog.html")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := &sb6183{base: ts.URL}
+	info, err := sb.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if got, want := info.SoftwareVersion, "D30CM-OSPREY-2.4.0.1-GA-02-NOSH"; got != want {
+		t.Errorf("Info software version got %q, want %q", got, want)
+	}
+	events, err := sb.Events(ctx)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if got, want := len(events), 4; got != want {
+		t.Errorf("Events got %d, want %d", got, want)
+	}
+
+	if _, err := (&sb6183{base: ts.URL + "/missing"}).Info(ctx); err == nil {
+		t.Errorf("Info with a bad URL succeeded, want error")
+	}
+	fake := &sb6183{fakeData: []byte("fake")}
+	if _, err := fake.Info(ctx); err != modem.ErrFakeData {
+		t.Errorf("Info of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+	if _, err := fake.Events(ctx); err != modem.ErrFakeData {
+		t.Errorf("Events of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
+
+func TestControl(t *testing.T) {
+	var got []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != configPath {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, r.PostForm)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := &sb6183{base: ts.URL}
+	if err := sb.Reboot(ctx); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if err := sb.ResetToDefaults(ctx); err != nil {
+		t.Fatalf("ResetToDefaults: %v", err)
+	}
+	want := []url.Values{
+		{"Rebooting": {"1"}, "RestoreFactoryDefault": {"0"}},
+		{"Rebooting": {"0"}, "RestoreFactoryDefault": {"1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %v, want %v", got, want)
+	}
+
+	if err := (&sb6183{base: ts.URL + "/missing"}).Reboot(ctx); err == nil {
+		t.Errorf("Reboot with a bad URL succeeded, want error")
+	}
+	if err := (&sb6183{fakeData: []byte("fake")}).Reboot(ctx); err != modem.ErrFakeData {
+		t.Errorf("Reboot of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
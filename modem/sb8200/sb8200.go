@@ -18,12 +18,19 @@ package sb8200
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/andybalholm/cascadia"
 	"github.com/golang/glog"
@@ -33,18 +40,52 @@ import (
 	"github.com/wathiede/surfer/modem"
 )
 
-const signalURL = "http://192.168.100.1/cmconnectionstatus.html"
+var (
+	username = flag.String("sb8200_username", "admin", "SB8200 admin username, for firmware that requires a login")
+	password = flag.String("sb8200_password", "", "SB8200 admin password, for firmware that requires a login.  The default is usually the last 8 characters of the serial number")
+)
+
+const (
+	baseURL    = "http://192.168.100.1"
+	signalPath = "/cmconnectionstatus.html"
+	signalURL  = baseURL + signalPath
+	// configPath is the Configuration page, whose Reboot and Restore
+	// Factory Defaults buttons post back to it.
+	configPath = "/cmconfiguration.html"
+	// infoPath is the Product Information page.
+	infoPath     = "/cmswinfo.html"
+	eventLogPath = "/cmeventlog.html"
+)
+
+// errLoginRequired is returned when the modem asks for a login and no
+// password is set.
+var errLoginRequired = errors.New("SB8200 requires a login, set -sb8200_password")
 
 type sb8200 struct {
 	fakeData []byte
+	// base is the URL of the modem's admin pages.
+	base               string
+	username, password string
+
+	mu sync.Mutex
+	// token is the credential of the current login session, empty if there
+	// isn't one.  Firmware that doesn't require a login never has one.
+	token  string
+	client *http.Client
 }
 
-func (sb8200) Name() string { return "SB8200" }
+func (*sb8200) Name() string { return "SB8200" }
 
 func isSB8200(b []byte) bool {
 	return bytes.Contains(b, []byte(`<span id="thisModelNumberIs">SB8200</span>`))
 }
 
+// isLoginPage reports whether b is the login page newer firmware serves in
+// place of any other page until logged in.
+func isLoginPage(b []byte) bool {
+	return bytes.Contains(b, []byte(`id="loginPassword"`))
+}
+
 func probe(ctx context.Context, path string) modem.Modem {
 	if path != "" {
 		b, err := ioutil.ReadFile(path)
@@ -52,7 +93,7 @@ func probe(ctx context.Context, path string) modem.Modem {
 			glog.Errorf("Failed to read %q: %v", path, err)
 			return nil
 		}
-		if isSB8200(b) {
+		if isSB8200(b) && !isLoginPage(b) {
 			m, err := NewFakeData(path)
 			if err != nil {
 				glog.Errorf("Failed to create fake SB8200: %v", err)
@@ -63,31 +104,44 @@ func probe(ctx context.Context, path string) modem.Modem {
 		return nil
 	}
 	glog.Infof("Probing %q", signalURL)
-	rc, err := get(ctx)
-	if err != nil {
-		glog.Errorf("Failed to get status page: %v", err)
+	sb := newSB8200(baseURL, *username, *password)
+	b, err := sb.get(ctx, signalPath)
+	if err == errLoginRequired {
+		glog.Errorf("Found an SB8200 that requires a login: set -sb8200_password")
 		return nil
 	}
-	defer rc.Close()
-	b, err := ioutil.ReadAll(io.LimitReader(rc, 1<<20))
 	if err != nil {
-		glog.Errorf("Failed to read status page: %v", err)
+		glog.Errorf("Failed to get status page: %v", err)
 		return nil
 	}
 	if isSB8200(b) {
-		return New()
+		return sb
 	}
 	return nil
 }
 
 func init() {
 	modem.Register(probe)
+	modem.RegisterModel("SB8200", New)
 }
 
 // New returns a modem.Modem that scrapes SB8200 formatted data at the default
-// URL.
+// URL, logging in with -sb8200_username and -sb8200_password if the firmware
+// requires it.
 func New() modem.Modem {
-	return &sb8200{}
+	return newSB8200(baseURL, *username, *password)
+}
+
+func newSB8200(base, username, password string) *sb8200 {
+	jar, _ := cookiejar.New(nil)
+	// Firmware that requires a login redirects to HTTPS, with a self-signed
+	// certificate.
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	client := modem.Client(transport, 0)
+	client.Jar = jar
+	return &sb8200{base: base, username: username, password: password, client: client}
 }
 
 // NewFakeData returns a modem.Modem that will parse SB8200 formatted data
@@ -100,17 +154,111 @@ func NewFakeData(path string) (modem.Modem, error) {
 	return &sb8200{fakeData: b}, nil
 }
 
-func get(ctx context.Context) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", signalURL, nil)
+// get fetches the page at path, logging in if needed.
+func (sb *sb8200) get(ctx context.Context, path string) ([]byte, error) {
+	return sb.do(ctx, "GET", path, nil)
+}
+
+// do sends a request for the page at path, with form as the body if it isn't
+// nil, and returns the response.  The session is reused until the modem asks
+// for a login again, when it logs in and retries once.
+func (sb *sb8200) do(ctx context.Context, method, path string, form url.Values) ([]byte, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for retried := false; ; retried = true {
+		b, err := sb.doOnce(ctx, method, path, form)
+		if err != nil {
+			return nil, err
+		}
+		if !isLoginPage(b) {
+			return b, nil
+		}
+		if retried {
+			return nil, errors.New("SB8200 login failed")
+		}
+		if sb.password == "" {
+			return nil, errLoginRequired
+		}
+		if sb.token != "" {
+			glog.Infof("SB8200 session expired, logging in again")
+		}
+		if err := sb.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// doOnce must be called with sb.mu held.
+func (sb *sb8200) doOnce(ctx context.Context, method, path string, form url.Values) ([]byte, error) {
+	u := sb.base + path
+	if sb.token != "" {
+		u += "?ct_" + sb.token
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
 	if err != nil {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if sb.token != "" {
+		req.AddCookie(&http.Cookie{Name: "credential", Value: sb.token})
+	}
+	resp, err := sb.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
+	defer resp.Body.Close()
+	sb.follow(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// follow switches sb.base to where the modem redirected to, typically from
+// HTTP to HTTPS, so later requests go there directly.  It must be called with
+// sb.mu held.
+func (sb *sb8200) follow(resp *http.Response) {
+	if u := resp.Request.URL; u.Scheme+"://"+u.Host != sb.base {
+		sb.base = u.Scheme + "://" + u.Host
+	}
+}
+
+// login starts a session.  The modem answers a request carrying the base64
+// encoded credentials, in both the query and an Authorization header, with a
+// token that later requests carry in the query and a credential cookie.  It
+// must be called with sb.mu held.
+func (sb *sb8200) login(ctx context.Context) error {
+	sb.token = ""
+	cred := base64.StdEncoding.EncodeToString([]byte(sb.username + ":" + sb.password))
+	req, err := http.NewRequest("GET", sb.base+signalPath+"?login_"+cred, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Basic "+cred)
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sb.follow(resp)
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(b))
+	if resp.StatusCode != http.StatusOK || token == "" || strings.ContainsAny(token, "<> ") {
+		return fmt.Errorf("SB8200 login failed: %s", resp.Status)
+	}
+	sb.token = token
+	return nil
 }
 
 // Status will return signal data parsed from an HTML status page.  If
@@ -121,12 +269,54 @@ func (sb *sb8200) Status(ctx context.Context) (*modem.Signal, error) {
 		return parseStatus(bytes.NewReader(sb.fakeData))
 	}
 
-	rc, err := get(ctx)
+	b, err := sb.get(ctx, signalPath)
 	if err != nil {
 		return nil, err
 	}
-	defer rc.Close()
-	return parseStatus(rc)
+	return parseStatus(bytes.NewReader(b))
+}
+
+// Info implements modem.Describer.
+func (sb *sb8200) Info(ctx context.Context) (*modem.Info, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := sb.get(ctx, infoPath)
+	if err != nil {
+		return nil, err
+	}
+	return htmlutil.ParseInfoTable(bytes.NewReader(b))
+}
+
+// Events implements modem.EventLogger.
+func (sb *sb8200) Events(ctx context.Context) ([]modem.Event, error) {
+	if sb.fakeData != nil {
+		return nil, modem.ErrFakeData
+	}
+	b, err := sb.get(ctx, eventLogPath)
+	if err != nil {
+		return nil, err
+	}
+	return htmlutil.ParseEventTable(bytes.NewReader(b))
+}
+
+// Reboot implements modem.Controller.
+func (sb *sb8200) Reboot(ctx context.Context) error {
+	return sb.configure(ctx, url.Values{"Rebooting": {"1"}, "RestoreFactoryDefault": {"0"}})
+}
+
+// ResetToDefaults implements modem.Controller.
+func (sb *sb8200) ResetToDefaults(ctx context.Context) error {
+	return sb.configure(ctx, url.Values{"Rebooting": {"0"}, "RestoreFactoryDefault": {"1"}})
+}
+
+// configure submits the Configuration page form with v.
+func (sb *sb8200) configure(ctx context.Context, v url.Values) error {
+	if sb.fakeData != nil {
+		return modem.ErrFakeData
+	}
+	_, err := sb.do(ctx, "POST", configPath, v)
+	return err
 }
 
 func parseStatus(r io.Reader) (*modem.Signal, error) {
@@ -174,6 +364,7 @@ func parseDownstreamTable(n *html.Node) (map[modem.Channel]*modem.Downstream, er
 				ch = modem.Channel(v)
 			case 1:
 				// Lock Status
+				d.Status = v
 			case 2:
 				// Modulation
 				d.Modulation = v
@@ -15,12 +15,23 @@
 package sb8200
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/wathiede/surfer/htmlutil"
 	"github.com/wathiede/surfer/modem"
 )
 
@@ -49,6 +60,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   1643,
 				Uncorrectable: 3047,
+				Status:        "Locked",
 			},
 			"1": {
 				Modulation:    "QAM256",
@@ -57,6 +69,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.1,
 				Correctable:   2549,
 				Uncorrectable: 8191,
+				Status:        "Locked",
 			},
 			"2": {
 				Modulation:    "QAM256",
@@ -65,6 +78,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.4,
 				Correctable:   2540,
 				Uncorrectable: 7489,
+				Status:        "Locked",
 			},
 			"3": {
 				Modulation:    "QAM256",
@@ -73,6 +87,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.4,
 				Correctable:   2505,
 				Uncorrectable: 7854,
+				Status:        "Locked",
 			},
 			"4": {
 				Modulation:    "QAM256",
@@ -81,6 +96,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.5,
 				Correctable:   2343,
 				Uncorrectable: 7456,
+				Status:        "Locked",
 			},
 			"5": {
 				Modulation:    "QAM256",
@@ -89,6 +105,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.2,
 				Correctable:   2089,
 				Uncorrectable: 6803,
+				Status:        "Locked",
 			},
 			"6": {
 				Modulation:    "QAM256",
@@ -97,6 +114,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           40.0,
 				Correctable:   2092,
 				Uncorrectable: 6111,
+				Status:        "Locked",
 			},
 			"7": {
 				Modulation:    "QAM256",
@@ -105,6 +123,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.9,
 				Correctable:   2220,
 				Uncorrectable: 5516,
+				Status:        "Locked",
 			},
 			"8": {
 				Modulation:    "QAM256",
@@ -113,6 +132,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.1,
 				Correctable:   2117,
 				Uncorrectable: 5893,
+				Status:        "Locked",
 			},
 			"9": {
 				Modulation:    "QAM256",
@@ -121,6 +141,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           38.8,
 				Correctable:   2210,
 				Uncorrectable: 5966,
+				Status:        "Locked",
 			},
 			"10": {
 				Modulation:    "QAM256",
@@ -129,6 +150,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   2145,
 				Uncorrectable: 5962,
+				Status:        "Locked",
 			},
 			"11": {
 				Modulation:    "QAM256",
@@ -137,6 +159,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.5,
 				Correctable:   1838,
 				Uncorrectable: 5681,
+				Status:        "Locked",
 			},
 			"12": {
 				Modulation:    "QAM256",
@@ -145,6 +168,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.5,
 				Correctable:   1760,
 				Uncorrectable: 5062,
+				Status:        "Locked",
 			},
 			"13": {
 				Modulation:    "QAM256",
@@ -153,6 +177,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.5,
 				Correctable:   1711,
 				Uncorrectable: 4013,
+				Status:        "Locked",
 			},
 			"14": {
 				Modulation:    "QAM256",
@@ -161,6 +186,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.0,
 				Correctable:   1797,
 				Uncorrectable: 3586,
+				Status:        "Locked",
 			},
 			"15": {
 				Modulation:    "QAM256",
@@ -169,6 +195,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.1,
 				Correctable:   1961,
 				Uncorrectable: 3673,
+				Status:        "Locked",
 			},
 			"16": {
 				Modulation:    "QAM256",
@@ -177,6 +204,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.0,
 				Correctable:   1760,
 				Uncorrectable: 4294,
+				Status:        "Locked",
 			},
 			"17": {
 				Modulation:    "QAM256",
@@ -185,6 +213,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.0,
 				Correctable:   1739,
 				Uncorrectable: 4569,
+				Status:        "Locked",
 			},
 			"18": {
 				Modulation:    "QAM256",
@@ -193,6 +222,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.1,
 				Correctable:   1867,
 				Uncorrectable: 4407,
+				Status:        "Locked",
 			},
 			"19": {
 				Modulation:    "QAM256",
@@ -201,6 +231,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.5,
 				Correctable:   1761,
 				Uncorrectable: 4156,
+				Status:        "Locked",
 			},
 			"20": {
 				Modulation:    "QAM256",
@@ -209,6 +240,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   1700,
 				Uncorrectable: 3700,
+				Status:        "Locked",
 			},
 			"21": {
 				Modulation:    "QAM256",
@@ -217,6 +249,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.2,
 				Correctable:   1863,
 				Uncorrectable: 3231,
+				Status:        "Locked",
 			},
 			"22": {
 				Modulation:    "QAM256",
@@ -225,6 +258,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   1895,
 				Uncorrectable: 2905,
+				Status:        "Locked",
 			},
 			"23": {
 				Modulation:    "QAM256",
@@ -233,6 +267,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.0,
 				Correctable:   1836,
 				Uncorrectable: 3035,
+				Status:        "Locked",
 			},
 			"24": {
 				Modulation:    "QAM256",
@@ -241,6 +276,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.3,
 				Correctable:   2027,
 				Uncorrectable: 3141,
+				Status:        "Locked",
 			},
 			"25": {
 				Modulation:    "QAM256",
@@ -249,6 +285,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.2,
 				Correctable:   1765,
 				Uncorrectable: 3784,
+				Status:        "Locked",
 			},
 			"26": {
 				Modulation:    "QAM256",
@@ -257,6 +294,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.2,
 				Correctable:   1928,
 				Uncorrectable: 4098,
+				Status:        "Locked",
 			},
 			"27": {
 				Modulation:    "QAM256",
@@ -265,6 +303,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.2,
 				Correctable:   1767,
 				Uncorrectable: 4253,
+				Status:        "Locked",
 			},
 			"28": {
 				Modulation:    "QAM256",
@@ -273,6 +312,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   1848,
 				Uncorrectable: 4298,
+				Status:        "Locked",
 			},
 			"30": {
 				Modulation:    "QAM256",
@@ -281,6 +321,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.3,
 				Correctable:   1521,
 				Uncorrectable: 3600,
+				Status:        "Locked",
 			},
 			"31": {
 				Modulation:    "QAM256",
@@ -289,6 +330,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.6,
 				Correctable:   1844,
 				Uncorrectable: 3185,
+				Status:        "Locked",
 			},
 			"32": {
 				Modulation:    "QAM256",
@@ -297,6 +339,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           39.4,
 				Correctable:   1836,
 				Uncorrectable: 3219,
+				Status:        "Locked",
 			},
 			"159": {
 				Modulation:    "Other",
@@ -305,6 +348,7 @@ func TestParseStatus(t *testing.T) {
 				SNR:           36.2,
 				Correctable:   1179900627,
 				Uncorrectable: 0,
+				Status:        "Locked",
 			},
 		},
 		Upstream: map[modem.Channel]*modem.Upstream{
@@ -347,3 +391,267 @@ func TestParseStatus(t *testing.T) {
 		t.Errorf("Got:\n%s\nWant:\n%s", g, w)
 	}
 }
+
+func TestParseInfo(t *testing.T) {
+	r, err := os.Open("testdata/SB8200-swinfo.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := htmlutil.ParseInfoTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &modem.Info{
+		HardwareVersion: "6",
+		SoftwareVersion: "AB01.02.053.05_051921_193.0A.NSH",
+		SerialNumber:    "000000000000000",
+		MACAddress:      "00:00:5E:00:53:01",
+		Uptime:          5*24*time.Hour + 2*time.Hour + 35*time.Minute + 36*time.Second,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInfoTable got %+v, want %+v", got, want)
+	}
+
+	if _, err := htmlutil.ParseInfoTable(strings.NewReader("<html></html>")); err == nil {
+		t.Errorf("ParseInfoTable of an empty page succeeded, want error")
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	r, err := os.Open("testdata/SB8200-eventlog.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := htmlutil.ParseEventTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t3 := "No Ranging Response received - T3 time-out;CM-MAC=00:00:5e:00:53:01;CMTS-MAC=00:00:5e:00:53:02;CM-QOS=1.1;CM-VER=3.1;"
+	want := []modem.Event{
+		{Priority: modem.PriorityCritical, Text: t3},
+		{Priority: modem.PriorityNotice, Text: "Honoring MDD; IP provisioning mode = IPv6"},
+		{Time: time.Date(2020, 6, 22, 9, 14, 0, 0, time.Local), Priority: modem.PriorityWarning, Text: "Dynamic Range Window violation"},
+		{Time: time.Date(2020, 6, 26, 23, 41, 0, 0, time.Local), Priority: modem.PriorityCritical, Text: "Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out;CM-MAC=00:00:5e:00:53:01;CMTS-MAC=00:00:5e:00:53:02;CM-QOS=1.1;CM-VER=3.1;"},
+		{Time: time.Date(2020, 6, 27, 17, 5, 0, 0, time.Local), Priority: modem.PriorityCritical, Text: t3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEventTable got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestInfoAndEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case infoPath:
+			http.ServeFile(w, r, "testdata/SB8200-swinfo.html")
+		case eventLogPath:
+			http.ServeFile(w, r, "testdata/SB8200-eventlog.html")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := newSB8200(ts.URL, "", "")
+	info, err := sb.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if got, want := info.SoftwareVersion, "AB01.02.053.05_051921_193.0A.NSH"; got != want {
+		t.Errorf("Info software version got %q, want %q", got, want)
+	}
+	events, err := sb.Events(ctx)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if got, want := len(events), 5; got != want {
+		t.Errorf("Events got %d, want %d", got, want)
+	}
+
+	fake := &sb8200{fakeData: []byte("fake")}
+	if _, err := fake.Info(ctx); err != modem.ErrFakeData {
+		t.Errorf("Info of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+	if _, err := fake.Events(ctx); err != modem.ErrFakeData {
+		t.Errorf("Events of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
+
+func TestControl(t *testing.T) {
+	var got []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != configPath {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, r.PostForm)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	sb := newSB8200(ts.URL, "", "")
+	if err := sb.Reboot(ctx); err != nil {
+		t.Fatalf("Reboot: %v", err)
+	}
+	if err := sb.ResetToDefaults(ctx); err != nil {
+		t.Fatalf("ResetToDefaults: %v", err)
+	}
+	want := []url.Values{
+		{"Rebooting": {"1"}, "RestoreFactoryDefault": {"0"}},
+		{"Rebooting": {"0"}, "RestoreFactoryDefault": {"1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %v, want %v", got, want)
+	}
+
+	if err := newSB8200(ts.URL+"/missing", "", "").Reboot(ctx); err == nil {
+		t.Errorf("Reboot with a bad URL succeeded, want error")
+	}
+	if err := (&sb8200{fakeData: []byte("fake")}).Reboot(ctx); err != modem.ErrFakeData {
+		t.Errorf("Reboot of fake data got %v, want %v", err, modem.ErrFakeData)
+	}
+}
+
+// loginServer is a stand-in for firmware that requires a login.  Pages other
+// than the status page are answered with an empty 200.
+type loginServer struct {
+	status, login []byte
+
+	mu     sync.Mutex
+	tokens map[string]bool
+	logins int
+}
+
+func newLoginServer(t *testing.T) *loginServer {
+	t.Helper()
+	status, err := ioutil.ReadFile("testdata/SB8200.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := ioutil.ReadFile("testdata/SB8200-login.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &loginServer{status: status, login: login, tokens: map[string]bool{}}
+}
+
+func (s *loginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.RawQuery
+	if strings.HasPrefix(q, "login_") {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != "admin" || p != "secret" || strings.TrimPrefix(q, "login_") != strings.TrimPrefix(r.Header.Get("Authorization"), "Basic ") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.logins++
+		token := fmt.Sprintf("token%d", s.logins)
+		s.tokens[token] = true
+		fmt.Fprint(w, token)
+		return
+	}
+	c, err := r.Cookie("credential")
+	if err != nil || !s.tokens[c.Value] || q != "ct_"+c.Value {
+		w.Write(s.login)
+		return
+	}
+	if r.URL.Path == signalPath {
+		w.Write(s.status)
+	}
+}
+
+// expire ends every session.
+func (s *loginServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]bool{}
+}
+
+func TestLogin(t *testing.T) {
+	ls := newLoginServer(t)
+	ts := httptest.NewTLSServer(ls)
+	defer ts.Close()
+	// Redirect plain HTTP to the TLS server, as the modem does.
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	defer redirect.Close()
+
+	want, err := parseStatus(bytes.NewReader(ls.status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sb := newSB8200(redirect.URL, "admin", "secret")
+	for i := 0; i < 2; i++ {
+		got, err := sb.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Status %d got %+v, want %+v", i, got, want)
+		}
+	}
+	if ls.logins != 1 {
+		t.Errorf("Logged in %d times, want the session reused", ls.logins)
+	}
+	if sb.base != ts.URL {
+		t.Errorf("Base got %q, want the redirect followed to %q", sb.base, ts.URL)
+	}
+
+	ls.expire()
+	if _, err := sb.Status(ctx); err != nil {
+		t.Fatalf("Status after the session expired: %v", err)
+	}
+	if ls.logins != 2 {
+		t.Errorf("Logged in %d times, want another login after the session expired", ls.logins)
+	}
+	if err := sb.Reboot(ctx); err != nil {
+		t.Errorf("Reboot: %v", err)
+	}
+
+	if _, err := newSB8200(ts.URL, "admin", "wrong").Status(ctx); err == nil {
+		t.Errorf("Status with the wrong password succeeded, want error")
+	}
+	if _, err := newSB8200(ts.URL, "admin", "").Status(ctx); err != errLoginRequired {
+		t.Errorf("Status without a password got %v, want %v", err, errLoginRequired)
+	}
+}
+
+func TestNoLogin(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/SB8200.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	sb := newSB8200(ts.URL, "admin", "secret")
+	if _, err := sb.Status(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{""}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("Queries got %q, want %q without logging in", queries, want)
+	}
+}
+
+func TestProbeLoginPage(t *testing.T) {
+	if m := probe(context.Background(), "testdata/SB8200-login.html"); m != nil {
+		t.Errorf("Probing the login page got %v, want nil", m)
+	}
+	if m := probe(context.Background(), "testdata/SB8200.html"); m == nil {
+		t.Errorf("Probing the status page got nil")
+	}
+}
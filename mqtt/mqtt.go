@@ -0,0 +1,400 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqtt publishes modem signal data to an MQTT broker, with Home
+// Assistant discovery so sensors show up without any configuration.
+//
+// It includes a minimal MQTT 3.1.1 client supporting only what a publisher
+// needs: QoS 0 and 1, retained messages, a last will, keep alive, username
+// and password authentication and TLS.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Packet types.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// Message is a message to publish.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// Options configures a Client.
+type Options struct {
+	// Broker is the URL of the broker, tcp://host:port for plain TCP or
+	// ssl://host:port or tls://host:port for TLS.  The port defaults to 1883
+	// or 8883.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TLSConfig is used for ssl:// and tls:// brokers, nil uses the defaults.
+	TLSConfig *tls.Config
+	// KeepAlive is the interval the client pings the broker at when idle.
+	// Zero defaults to a minute.
+	KeepAlive time.Duration
+	// Will is published by the broker if the client disconnects without
+	// calling Close.
+	Will *Message
+	// Timeout bounds connecting and waiting for acknowledgements.  Zero
+	// defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Client is a connection to an MQTT broker.
+type Client struct {
+	opts Options
+	conn net.Conn
+	// r reads every packet from conn, first in connect then in readLoop.
+	r *bufio.Reader
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan struct{}
+	err     error
+	done    chan struct{}
+}
+
+// ErrClosed is returned when publishing on a closed or broken connection.
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// Dial connects to the broker in opts.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = time.Minute
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{Timeout: opts.Timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		cfg := opts.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		var raw net.Conn
+		raw, err = d.DialContext(ctx, "tcp", hostPort(u.Host, "8883"))
+		if err == nil {
+			tc := tls.Client(raw, cfg)
+			tc.SetDeadline(time.Now().Add(opts.Timeout))
+			if err = tc.Handshake(); err != nil {
+				raw.Close()
+			}
+			tc.SetDeadline(time.Time{})
+			conn = tc
+		}
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		opts:    opts,
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		pending: map[uint16]chan struct{}{},
+		done:    make(chan struct{}),
+	}
+	if err := c.connect(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	go c.pingLoop()
+	return c, nil
+}
+
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func appendLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// packet returns a complete packet with the fixed header for typ and flags.
+func packet(typ, flags byte, body []byte) []byte {
+	b := appendLength([]byte{typ<<4 | flags}, len(body))
+	return append(b, body...)
+}
+
+// readPacket reads a packet, returning its type, the flags of the fixed
+// header and the rest of the packet.
+func readPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(d&0x7f) * mult
+		mult *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return h >> 4, h & 0x0f, body, nil
+}
+
+func (c *Client) connect() error {
+	flags := byte(0x02) // Clean session.
+	var payload []byte
+	payload = appendString(payload, c.opts.ClientID)
+	if w := c.opts.Will; w != nil {
+		flags |= 0x04 | w.QoS<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, w.Topic)
+		payload = appendString(payload, string(w.Payload))
+	}
+	if c.opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, c.opts.Username)
+		if c.opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, c.opts.Password)
+		}
+	}
+	body := appendString(nil, "MQTT")
+	keepAlive := uint16(c.opts.KeepAlive / time.Second)
+	body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = append(body, payload...)
+
+	c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(packet(typeConnect, 0, body)); err != nil {
+		return err
+	}
+	typ, _, ack, err := readPacket(c.r)
+	if err != nil {
+		return err
+	}
+	if typ != typeConnack || len(ack) != 2 {
+		return fmt.Errorf("mqtt: expected CONNACK, got packet type %d", typ)
+	}
+	if rc := ack[1]; rc != 0 {
+		return fmt.Errorf("mqtt: connection refused: %s", connackError(rc))
+	}
+	return nil
+}
+
+func connackError(rc byte) string {
+	switch rc {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", rc)
+}
+
+func (c *Client) write(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write(b)
+	if err != nil {
+		c.fail(err)
+	}
+	return err
+}
+
+// fail records err as the reason the connection broke and closes it.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// Err returns why the connection was closed, or nil if it is still open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) readLoop() {
+	for {
+		typ, _, body, err := readPacket(c.r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch typ {
+		case typePuback:
+			if len(body) < 2 {
+				continue
+			}
+			id := binary.BigEndian.Uint16(body)
+			c.mu.Lock()
+			if ch, ok := c.pending[id]; ok {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+		case typePingresp:
+		}
+	}
+}
+
+func (c *Client) pingLoop() {
+	t := time.NewTicker(c.opts.KeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.write(packet(typePingreq, 0, nil))
+		}
+	}
+}
+
+// Publish sends m.  With QoS 1 it waits for the broker to acknowledge it.
+func (c *Client) Publish(m Message) error {
+	if err := c.Err(); err != nil {
+		return ErrClosed
+	}
+	if m.QoS > 1 {
+		return fmt.Errorf("mqtt: QoS %d not supported", m.QoS)
+	}
+	flags := m.QoS << 1
+	if m.Retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	var ack chan struct{}
+	if m.QoS == 1 {
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id := c.nextID
+		ack = make(chan struct{})
+		c.pending[id] = ack
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		}()
+		body = append(body, byte(id>>8), byte(id))
+	}
+	body = append(body, m.Payload...)
+	if err := c.write(packet(typePublish, flags, body)); err != nil {
+		return err
+	}
+	if ack == nil {
+		return nil
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-time.After(c.opts.Timeout):
+		return fmt.Errorf("mqtt: timed out waiting for PUBACK on %q", m.Topic)
+	}
+}
+
+// Close disconnects cleanly, so the broker doesn't publish the will.
+func (c *Client) Close() error {
+	if c.Err() != nil {
+		return nil
+	}
+	err := c.write(packet(typeDisconnect, 0, nil))
+	c.fail(ErrClosed)
+	return err
+}
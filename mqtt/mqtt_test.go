@@ -0,0 +1,351 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// connectInfo is what a client sent in CONNECT.
+type connectInfo struct {
+	ClientID           string
+	Username, Password string
+	Will               *Message
+}
+
+// broker is a stand-in MQTT broker.  It acknowledges everything and records
+// what it was sent.
+type broker struct {
+	l          net.Listener
+	rc         byte
+	connects   chan connectInfo
+	msgs       chan Message
+	disconnect chan bool
+	// afterConnack is sent in the same write as CONNACK.
+	afterConnack []byte
+	// noAck leaves QoS 1 messages unacknowledged.
+	noAck bool
+}
+
+func newBroker(t *testing.T, l net.Listener) *broker {
+	b := &broker{
+		l:          l,
+		connects:   make(chan connectInfo, 10),
+		msgs:       make(chan Message, 1000),
+		disconnect: make(chan bool, 10),
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+func startBroker(t *testing.T) *broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBroker(t, l)
+}
+
+func (b *broker) url() string {
+	return "tcp://" + b.l.Addr().String()
+}
+
+func readString(body []byte) (string, []byte) {
+	n := binary.BigEndian.Uint16(body)
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+func (b *broker) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		typ, flags, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case typeConnect:
+			var ci connectInfo
+			_, rest := readString(body)
+			cf := rest[1]
+			rest = rest[4:]
+			ci.ClientID, rest = readString(rest)
+			if cf&0x04 != 0 {
+				w := &Message{QoS: cf >> 3 & 0x03, Retain: cf&0x20 != 0}
+				var payload string
+				w.Topic, rest = readString(rest)
+				payload, rest = readString(rest)
+				w.Payload = []byte(payload)
+				ci.Will = w
+			}
+			if cf&0x80 != 0 {
+				ci.Username, rest = readString(rest)
+			}
+			if cf&0x40 != 0 {
+				ci.Password, _ = readString(rest)
+			}
+			b.connects <- ci
+			c.Write(append(packet(typeConnack, 0, []byte{0, b.rc}), b.afterConnack...))
+			if b.rc != 0 {
+				return
+			}
+		case typePublish:
+			m := Message{QoS: flags >> 1 & 0x03, Retain: flags&0x01 != 0}
+			m.Topic, body = readString(body)
+			if m.QoS > 0 {
+				if !b.noAck {
+					c.Write(packet(typePuback, 0, body[:2]))
+				}
+				body = body[2:]
+			}
+			m.Payload = body
+			b.msgs <- m
+		case typePingreq:
+			c.Write(packet(typePingresp, 0, nil))
+		case typeDisconnect:
+			b.disconnect <- true
+			return
+		}
+	}
+}
+
+func (b *broker) next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case m := <-b.msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return Message{}
+}
+
+func TestClient(t *testing.T) {
+	b := startBroker(t)
+	will := &Message{Topic: "surfer/availability", Payload: []byte("offline"), QoS: 1, Retain: true}
+	c, err := Dial(context.Background(), Options{Broker: b.url(), ClientID: "test", Username: "user", Password: "secret", Will: will})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := <-b.connects
+	want := connectInfo{ClientID: "test", Username: "user", Password: "secret", Will: will}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CONNECT got %+v, want %+v", got, want)
+	}
+
+	for _, m := range []Message{
+		{Topic: "a", Payload: []byte("at most once")},
+		{Topic: "b/c", Payload: []byte("at least once"), QoS: 1, Retain: true},
+	} {
+		if err := c.Publish(m); err != nil {
+			t.Fatalf("Publish(%v): %v", m, err)
+		}
+		if got := b.next(t); !reflect.DeepEqual(got, m) {
+			t.Errorf("Broker got %+v, want %+v", got, m)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case <-b.disconnect:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Broker didn't get DISCONNECT")
+	}
+	if err := c.Publish(Message{Topic: "a"}); err != ErrClosed {
+		t.Errorf("Publish after Close got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestClientPubackTimeout(t *testing.T) {
+	b := startBroker(t)
+	b.noAck = true
+	c, err := Dial(context.Background(), Options{Broker: b.url(), Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Publish(Message{Topic: "a", QoS: 1}); err == nil {
+		t.Error("Publish without a PUBACK succeeded")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) != 0 {
+		t.Errorf("Got %d pending messages after the timeout, want 0", len(c.pending))
+	}
+}
+
+func TestClientReadsAfterConnack(t *testing.T) {
+	b := startBroker(t)
+	// A malformed packet right behind CONNACK must reach the read loop,
+	// which closes the connection.
+	b.afterConnack = []byte{typePublish << 4, 0xff, 0xff, 0xff, 0xff}
+	c, err := Dial(context.Background(), Options{Broker: b.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Error("Packet after CONNACK was never read")
+	}
+}
+
+func TestClientRefused(t *testing.T) {
+	b := startBroker(t)
+	b.rc = 4
+	_, err := Dial(context.Background(), Options{Broker: b.url(), Username: "user", Password: "wrong"})
+	if err == nil || err.Error() != "mqtt: connection refused: bad user name or password" {
+		t.Errorf("Dial got %v, want bad user name or password", err)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	// Borrow the test certificate of an httptest TLS server.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBroker(t, l)
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	c, err := Dial(context.Background(), Options{Broker: "ssl://" + l.Addr().String(), TLSConfig: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-b.connects
+	if err := c.Publish(Message{Topic: "a", Payload: []byte("b"), QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+	b.next(t)
+
+	// Without trusting the test certificate the handshake fails.
+	if _, err := Dial(context.Background(), Options{Broker: "ssl://" + l.Addr().String()}); err == nil {
+		t.Errorf("Dial with untrusted certificate succeeded")
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	b := startBroker(t)
+	p := NewPublisher(Config{
+		Options:         Options{Broker: b.url()},
+		TopicPrefix:     "surfer",
+		DiscoveryPrefix: "homeassistant",
+		Model:           "SB8200",
+	})
+	s := &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Frequency: "459000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 2, SNR: 40, Correctable: 10, Uncorrectable: 2},
+			"2": {Frequency: "465000000 Hz", Modulation: "QAM256", Status: "Locked", PowerLevel: 3, SNR: 38, Correctable: 5, Uncorrectable: 1},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Frequency: "36000000 Hz", Modulation: "ATDMA", PowerLevel: 45},
+		},
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rep := quality.Profiles["docsis"].Evaluate(s)
+	p.Publish(now, s, rep, nil)
+
+	msgs := map[string]Message{}
+	for {
+		m := b.next(t)
+		msgs[m.Topic] = m
+		if m.Topic == "surfer/state" {
+			break
+		}
+	}
+	if m := msgs["surfer/availability"]; string(m.Payload) != Online || !m.Retain {
+		t.Errorf("Availability got %+v, want retained %q", m, Online)
+	}
+	ci := <-b.connects
+	if ci.Will == nil || ci.Will.Topic != "surfer/availability" || string(ci.Will.Payload) != Offline {
+		t.Errorf("Will got %+v", ci.Will)
+	}
+
+	var sum Summary
+	if err := json.Unmarshal(msgs["surfer/state"].Payload, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum.Health != "good" || *sum.TotalUncorrectable != 3 || *sum.TotalCorrectable != 15 || *sum.MinSNR != 38 || *sum.DownstreamChannels != 2 || *sum.UpstreamChannels != 1 {
+		t.Errorf("Summary got %s", msgs["surfer/state"].Payload)
+	}
+
+	var ds DownstreamState
+	if err := json.Unmarshal(msgs["surfer/downstream/2"].Payload, &ds); err != nil {
+		t.Fatal(err)
+	}
+	if want := (DownstreamState{Frequency: "465000000 Hz", Modulation: "QAM256", Status: "Locked", Power: 3, SNR: 38, Correctable: 5, Uncorrectable: 1}); ds != want {
+		t.Errorf("Downstream 2 got %+v, want %+v", ds, want)
+	}
+	if _, ok := msgs["surfer/upstream/1"]; !ok {
+		t.Errorf("No upstream channel 1 state")
+	}
+
+	m, ok := msgs["homeassistant/sensor/surfer_sb8200/min_snr/config"]
+	if !ok {
+		t.Fatalf("No min_snr discovery config, got topics %v", msgs)
+	}
+	var dc discoveryConfig
+	if err := json.Unmarshal(m.Payload, &dc); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Retain || dc.StateTopic != "surfer/state" || dc.ValueTemplate != "{{ value_json.min_snr }}" || dc.AvailabilityTopic != "surfer/availability" || dc.UniqueID != "surfer_sb8200_min_snr" {
+		t.Errorf("min_snr discovery got %+v", dc)
+	}
+	if _, ok := msgs["homeassistant/sensor/surfer_sb8200/downstream_1_snr/config"]; !ok {
+		t.Errorf("No downstream channel 1 SNR discovery config")
+	}
+
+	// Discovery is only sent once per connection, failures publish the
+	// unreachable state.
+	p.Publish(now, nil, nil, errors.New("connection refused"))
+	if m := b.next(t); m.Topic != "surfer/state" {
+		t.Errorf("Got %s, want only surfer/state", m.Topic)
+	} else if err := json.Unmarshal(m.Payload, &sum); err != nil || sum.Health != Unreachable {
+		t.Errorf("Unreachable state got %s", m.Payload)
+	}
+
+	p.Close()
+	if m := b.next(t); m.Topic != "surfer/availability" || string(m.Payload) != Offline {
+		t.Errorf("Close published %s %s, want offline availability", m.Topic, m.Payload)
+	}
+	<-b.disconnect
+}
@@ -0,0 +1,386 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/latest"
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// Availability payloads.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Unreachable is the health state published when the modem couldn't be
+// fetched.
+const Unreachable = "unreachable"
+
+// Config configures a Publisher.
+type Config struct {
+	Options
+	// TopicPrefix is prepended to every state topic.
+	TopicPrefix string
+	// DiscoveryPrefix is the Home Assistant discovery prefix, empty disables
+	// discovery.
+	DiscoveryPrefix string
+	// NodeID identifies this modem in discovery topics and unique IDs.
+	// Empty defaults to the model.
+	NodeID string
+	// Model is the modem model name.
+	Model string
+}
+
+// Summary is published to <prefix>/state after every fetch.
+type Summary struct {
+	Time               time.Time `json:"time"`
+	Health             string    `json:"health"`
+	Score              *float64  `json:"score,omitempty"`
+	TotalCorrectable   *float64  `json:"total_correctable,omitempty"`
+	TotalUncorrectable *float64  `json:"total_uncorrectable,omitempty"`
+	MinSNR             *float64  `json:"min_snr,omitempty"`
+	DownstreamChannels *int      `json:"downstream_channels,omitempty"`
+	UpstreamChannels   *int      `json:"upstream_channels,omitempty"`
+	Error              string    `json:"error,omitempty"`
+}
+
+// DownstreamState is published to <prefix>/downstream/<channel>.
+type DownstreamState struct {
+	Frequency     string        `json:"frequency"`
+	Modulation    string        `json:"modulation"`
+	Status        string        `json:"status,omitempty"`
+	Power         float64       `json:"power"`
+	SNR           float64       `json:"snr"`
+	Unerrored     float64       `json:"unerrored"`
+	Correctable   float64       `json:"correctable"`
+	Uncorrectable float64       `json:"uncorrectable"`
+	Quality       quality.Level `json:"quality"`
+}
+
+// UpstreamState is published to <prefix>/upstream/<channel>.
+type UpstreamState struct {
+	Frequency  string        `json:"frequency"`
+	Modulation string        `json:"modulation"`
+	Status     string        `json:"status,omitempty"`
+	Power      float64       `json:"power"`
+	SymbolRate float64       `json:"symbol_rate"`
+	Quality    quality.Level `json:"quality"`
+}
+
+// NewSummary summarizes s and its quality report rep, or records err if the
+// fetch failed.
+func NewSummary(t time.Time, s *modem.Signal, rep *quality.Report, err error) *Summary {
+	sum := &Summary{Time: t}
+	if err != nil {
+		sum.Health = Unreachable
+		sum.Error = err.Error()
+		return sum
+	}
+	sum.Health = rep.Level.String()
+	sum.Score = &rep.Score
+	var corr, uncorr float64
+	var minSNR *float64
+	for _, d := range s.Downstream {
+		corr += d.Correctable
+		uncorr += d.Uncorrectable
+		if snr := d.SNR; minSNR == nil || snr < *minSNR {
+			minSNR = &snr
+		}
+	}
+	ds, us := len(s.Downstream), len(s.Upstream)
+	sum.TotalCorrectable = &corr
+	sum.TotalUncorrectable = &uncorr
+	sum.MinSNR = minSNR
+	sum.DownstreamChannels = &ds
+	sum.UpstreamChannels = &us
+	return sum
+}
+
+type update struct {
+	t   time.Time
+	s   *modem.Signal
+	rep *quality.Report
+	err error
+}
+
+// Publisher publishes every fetch to an MQTT broker.  Publishing happens in
+// the background, connecting or reconnecting to the broker as needed, so a
+// slow or unreachable broker never holds up fetching.  Only the latest fetch
+// is kept while the broker is busy.
+type Publisher struct {
+	cfg     Config
+	updates *latest.Queue
+	done    chan struct{}
+
+	c *Client
+	// discovered holds the discovery topics published on the current
+	// connection.
+	discovered map[string]bool
+}
+
+// NewPublisher returns a Publisher for cfg.  Close it to disconnect.
+func NewPublisher(cfg Config) *Publisher {
+	if cfg.NodeID == "" {
+		cfg.NodeID = cfg.Model
+	}
+	cfg.NodeID = sanitize(cfg.NodeID)
+	cfg.TopicPrefix = strings.TrimSuffix(cfg.TopicPrefix, "/")
+	cfg.DiscoveryPrefix = strings.TrimSuffix(cfg.DiscoveryPrefix, "/")
+	if cfg.ClientID == "" {
+		cfg.ClientID = "surfer-" + cfg.NodeID
+	}
+	cfg.Will = &Message{Topic: cfg.TopicPrefix + "/availability", Payload: []byte(Offline), QoS: 1, Retain: true}
+	p := &Publisher{
+		cfg:     cfg,
+		updates: latest.New(),
+		done:    make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// sanitize returns id with only characters allowed in Home Assistant object
+// IDs.
+func sanitize(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, id)
+}
+
+// Publish queues a fetch to be published, replacing any fetch still waiting.
+// It never blocks, and drops the fetch once the Publisher is closed.
+func (p *Publisher) Publish(t time.Time, s *modem.Signal, rep *quality.Report, err error) {
+	p.updates.Put(&update{t: t, s: s, rep: rep, err: err})
+}
+
+// Close publishes the publisher as offline and disconnects.
+func (p *Publisher) Close() {
+	p.updates.Close()
+	<-p.done
+}
+
+func (p *Publisher) loop() {
+	defer close(p.done)
+	for {
+		v, ok := p.updates.Get()
+		if !ok {
+			break
+		}
+		u := v.(*update)
+		if err := p.publish(u); err != nil {
+			glog.Errorf("Failed to publish to MQTT broker %s: %v", p.cfg.Broker, err)
+		}
+	}
+	if p.c != nil && p.c.Err() == nil {
+		p.c.Publish(Message{Topic: p.cfg.Will.Topic, Payload: []byte(Offline), QoS: 1, Retain: true})
+		p.c.Close()
+	}
+}
+
+func (p *Publisher) connect() error {
+	if p.c != nil && p.c.Err() == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, p.cfg.Options)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Connected to MQTT broker %s", p.cfg.Broker)
+	p.c = c
+	p.discovered = map[string]bool{}
+	return p.send(p.cfg.Will.Topic, Online, true)
+}
+
+func (p *Publisher) send(topic string, v interface{}, retain bool) error {
+	var b []byte
+	if s, ok := v.(string); ok {
+		b = []byte(s)
+	} else {
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	return p.c.Publish(Message{Topic: topic, Payload: b, QoS: 1, Retain: retain})
+}
+
+func (p *Publisher) publish(u *update) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+	prefix := p.cfg.TopicPrefix
+	if err := p.discover(summarySensors...); err != nil {
+		return err
+	}
+	if u.err == nil {
+		for _, ch := range u.s.DownstreamChannels() {
+			d := u.s.Downstream[ch]
+			topic := fmt.Sprintf("%s/downstream/%s", prefix, ch)
+			if err := p.discover(channelSensors(modem.DirectionDownstream, ch, topic)...); err != nil {
+				return err
+			}
+			st := DownstreamState{
+				Frequency:     d.Frequency,
+				Modulation:    d.Modulation,
+				Status:        d.Status,
+				Power:         d.PowerLevel,
+				SNR:           d.SNR,
+				Unerrored:     d.Unerrored,
+				Correctable:   d.Correctable,
+				Uncorrectable: d.Uncorrectable,
+				Quality:       u.rep.Downstream[ch].Level,
+			}
+			if err := p.send(topic, st, false); err != nil {
+				return err
+			}
+		}
+		for _, ch := range u.s.UpstreamChannels() {
+			up := u.s.Upstream[ch]
+			topic := fmt.Sprintf("%s/upstream/%s", prefix, ch)
+			if err := p.discover(channelSensors(modem.DirectionUpstream, ch, topic)...); err != nil {
+				return err
+			}
+			st := UpstreamState{
+				Frequency:  up.Frequency,
+				Modulation: up.Modulation,
+				Status:     up.Status,
+				Power:      up.PowerLevel,
+				SymbolRate: up.SymbolRate,
+				Quality:    u.rep.Upstream[ch].Level,
+			}
+			if err := p.send(topic, st, false); err != nil {
+				return err
+			}
+		}
+	}
+	return p.send(prefix+"/state", NewSummary(u.t, u.s, u.rep, u.err), true)
+}
+
+// sensor describes a Home Assistant sensor read from a JSON state topic.
+type sensor struct {
+	id          string
+	name        string
+	topic       string // Empty means <prefix>/state.
+	key         string
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+}
+
+var summarySensors = []sensor{
+	{id: "health", name: "Signal health", key: "health", icon: "mdi:heart-pulse"},
+	{id: "score", name: "Signal score", key: "score", unit: "%", stateClass: "measurement"},
+	{id: "total_correctable", name: "Total correctable codewords", key: "total_correctable", stateClass: "total_increasing", icon: "mdi:alert-circle-check"},
+	{id: "total_uncorrectable", name: "Total uncorrectable codewords", key: "total_uncorrectable", stateClass: "total_increasing", icon: "mdi:alert-circle"},
+	{id: "min_snr", name: "Minimum downstream SNR", key: "min_snr", unit: "dB", deviceClass: "signal_strength", stateClass: "measurement"},
+	{id: "downstream_channels", name: "Downstream channels", key: "downstream_channels", stateClass: "measurement", icon: "mdi:download-network"},
+	{id: "upstream_channels", name: "Upstream channels", key: "upstream_channels", stateClass: "measurement", icon: "mdi:upload-network"},
+}
+
+func channelSensors(dir modem.Direction, ch modem.Channel, topic string) []sensor {
+	id := fmt.Sprintf("%s_%s_", dir, sanitize(string(ch)))
+	name := fmt.Sprintf("%s%s channel %s ", strings.ToUpper(string(dir[:1])), dir[1:], ch)
+	ss := []sensor{
+		{id: id + "power", name: name + "power", topic: topic, key: "power", unit: "dBmV", stateClass: "measurement", icon: "mdi:signal"},
+	}
+	if dir == modem.DirectionDownstream {
+		ss = append(ss,
+			sensor{id: id + "snr", name: name + "SNR", topic: topic, key: "snr", unit: "dB", deviceClass: "signal_strength", stateClass: "measurement"},
+			sensor{id: id + "uncorrectable", name: name + "uncorrectable codewords", topic: topic, key: "uncorrectable", stateClass: "total_increasing", icon: "mdi:alert-circle"},
+		)
+	}
+	return ss
+}
+
+// discoveryConfig is the Home Assistant MQTT discovery payload of a sensor.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id"`
+	StateTopic        string          `json:"state_topic"`
+	ValueTemplate     string          `json:"value_template"`
+	Unit              string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Icon              string          `json:"icon,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+}
+
+// discover publishes the discovery config of every sensor not yet published
+// on this connection.
+func (p *Publisher) discover(ss ...sensor) error {
+	if p.cfg.DiscoveryPrefix == "" {
+		return nil
+	}
+	node := p.cfg.NodeID
+	for _, s := range ss {
+		topic := fmt.Sprintf("%s/sensor/surfer_%s/%s/config", p.cfg.DiscoveryPrefix, node, s.id)
+		if p.discovered[topic] {
+			continue
+		}
+		st := s.topic
+		if st == "" {
+			st = p.cfg.TopicPrefix + "/state"
+		}
+		c := discoveryConfig{
+			Name:              s.name,
+			UniqueID:          fmt.Sprintf("surfer_%s_%s", node, s.id),
+			ObjectID:          fmt.Sprintf("%s_%s", node, s.id),
+			StateTopic:        st,
+			ValueTemplate:     fmt.Sprintf("{{ value_json.%s }}", s.key),
+			Unit:              s.unit,
+			DeviceClass:       s.deviceClass,
+			StateClass:        s.stateClass,
+			Icon:              s.icon,
+			AvailabilityTopic: p.cfg.Will.Topic,
+			Device: discoveryDevice{
+				Identifiers: []string{"surfer_" + node},
+				Name:        "Cable modem " + p.cfg.Model,
+				Model:       p.cfg.Model,
+			},
+		}
+		if err := p.send(topic, c, true); err != nil {
+			return err
+		}
+		p.discovered[topic] = true
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp exports modem signal data as OpenTelemetry metrics over
+// OTLP/HTTP, using the JSON encoding, to an OpenTelemetry Collector or any
+// other OTLP receiver.
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/latest"
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// The OTLP JSON messages, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Gauge       *gauge `json:"gauge,omitempty"`
+	Sum         *sum   `json:"sum,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const aggregationTemporalityCumulative = 2
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func attrs(kv ...string) []keyValue {
+	var a []keyValue
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+		a = append(a, keyValue{kv[i], anyValue{kv[i+1]}})
+	}
+	return a
+}
+
+func unixNano(t time.Time) uint64 {
+	return uint64(t.UnixNano())
+}
+
+// Options configures an Exporter.
+type Options struct {
+	// Endpoint is the OTLP/HTTP receiver, e.g. http://collector:4318.  The
+	// /v1/metrics path is added unless it is already there.
+	Endpoint string
+	// Headers are sent with every request, e.g. for authentication.
+	Headers map[string]string
+	// Resource attributes describe the modem, e.g. modem.model and
+	// modem.serial.  service.name defaults to surfer.
+	Resource map[string]string
+	// Timeout bounds each request.  Zero defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// counterKey identifies a cumulative series by all of its attributes.
+type counterKey struct {
+	direction  modem.Direction
+	channel    modem.Channel
+	frequency  string
+	modulation string
+	state      string
+}
+
+// counterStart is the start of a cumulative series: its start time, and its
+// counter value then, which is subtracted from later values.
+type counterStart struct {
+	start time.Time
+	base  float64
+	last  float64
+	lastT time.Time
+}
+
+// Exporter exports every fetch in the background, so a slow or unreachable
+// receiver never holds up fetching.  Only the latest fetch is kept while the
+// receiver is busy, which loses nothing as counters are cumulative.
+type Exporter struct {
+	opts    Options
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	// starts is only used by the export goroutine.
+	starts map[counterKey]*counterStart
+
+	updates *latest.Queue
+	done    chan struct{}
+}
+
+type update struct {
+	t   time.Time
+	s   *modem.Signal
+	rep *quality.Report
+}
+
+// NewExporter returns an Exporter sending to opts.Endpoint.  Close it to
+// stop.
+func NewExporter(opts Options) (*Exporter, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("otlp: no endpoint")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	u := strings.TrimSuffix(opts.Endpoint, "/")
+	if !strings.HasSuffix(u, "/v1/metrics") {
+		u += "/v1/metrics"
+	}
+	e := &Exporter{
+		opts:    opts,
+		url:     u,
+		client:  &http.Client{Timeout: opts.Timeout},
+		retries: 3,
+		backoff: time.Second,
+		starts:  map[counterKey]*counterStart{},
+		updates: latest.New(),
+		done:    make(chan struct{}),
+	}
+	go e.loop()
+	return e, nil
+}
+
+// Export queues a successful fetch and its quality report to be exported,
+// replacing any fetch still waiting.  It never blocks, and drops the fetch
+// once the Exporter is closed.
+func (e *Exporter) Export(t time.Time, s *modem.Signal, rep *quality.Report) {
+	e.updates.Put(&update{t: t, s: s, rep: rep})
+}
+
+// Close exports anything queued and stops.
+func (e *Exporter) Close() {
+	e.updates.Close()
+	<-e.done
+}
+
+func (e *Exporter) loop() {
+	defer close(e.done)
+	for {
+		v, ok := e.updates.Get()
+		if !ok {
+			return
+		}
+		u := v.(*update)
+		b, err := json.Marshal(e.request(u.t, u.s, u.rep))
+		if err != nil {
+			glog.Errorf("Failed to encode OTLP request: %v", err)
+			continue
+		}
+		if err := e.post(b); err != nil {
+			glog.Errorf("Failed to export to %s: %v", e.url, err)
+		}
+	}
+}
+
+// cumulative returns the start time and value to export for the cumulative
+// series k with counter value v at t, and false if there's nothing to export
+// yet.  The modem doesn't say when its counters started, so the first
+// observation of a series only records its start time and value, and later
+// points count from there.  If the counter goes down, which happens when the
+// modem reboots, it restarted some time after the previous observation, and
+// the series starts again then.
+func (e *Exporter) cumulative(k counterKey, t time.Time, v float64) (time.Time, float64, bool) {
+	cs, ok := e.starts[k]
+	switch {
+	case !ok:
+		e.starts[k] = &counterStart{start: t, base: v, last: v, lastT: t}
+		return time.Time{}, 0, false
+	case v < cs.last:
+		cs.start, cs.base = cs.lastT, 0
+	}
+	cs.last, cs.lastT = v, t
+	return cs.start, v - cs.base, true
+}
+
+// request maps s to OTLP metrics: gauges for power levels, SNR, symbol rate
+// and health, and monotonic cumulative sums for codeword counters.
+func (e *Exporter) request(t time.Time, s *modem.Signal, rep *quality.Report) *exportRequest {
+	ts := unixNano(t)
+	var (
+		dsPower   = metric{Name: "modem.downstream.power", Unit: "dBmV", Description: "Downstream channel power level", Gauge: &gauge{}}
+		dsSNR     = metric{Name: "modem.downstream.snr", Unit: "dB", Description: "Downstream channel signal to noise ratio", Gauge: &gauge{}}
+		codewords = metric{Name: "modem.downstream.codewords", Unit: "{codeword}", Description: "Downstream codewords received by error correction state", Sum: &sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}}
+		usPower   = metric{Name: "modem.upstream.power", Unit: "dBmV", Description: "Upstream channel power level", Gauge: &gauge{}}
+		usRate    = metric{Name: "modem.upstream.symbol_rate", Unit: "{symbol}/s", Description: "Upstream channel symbol rate", Gauge: &gauge{}}
+		chQuality = metric{Name: "modem.channel.quality", Description: "Channel signal quality, 0 good, 1 marginal, 2 bad", Gauge: &gauge{}}
+		health    = metric{Name: "modem.health.score", Unit: "%", Description: "Overall signal health from 0 (all bad) to 100 (all good)", Gauge: &gauge{}}
+	)
+	gp := func(m *metric, v float64, a []keyValue) {
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, dataPoint{Attributes: a, TimeUnixNano: ts, AsDouble: v})
+	}
+	seen := map[counterKey]bool{}
+	for _, ch := range s.DownstreamChannels() {
+		d := s.Downstream[ch]
+		a := attrs("modem.channel", string(ch), "modem.direction", string(modem.DirectionDownstream), "modem.frequency", d.Frequency, "modem.modulation", d.Modulation)
+		gp(&dsPower, d.PowerLevel, a)
+		gp(&dsSNR, d.SNR, a)
+		if rep != nil && rep.Downstream[ch].Level != quality.Unrated {
+			gp(&chQuality, float64(rep.Downstream[ch].Level), a)
+		}
+		for _, c := range []struct {
+			state string
+			v     float64
+		}{
+			{"unerrored", d.Unerrored},
+			{"correctable", d.Correctable},
+			{"uncorrectable", d.Uncorrectable},
+		} {
+			ca := append(append([]keyValue(nil), a...), attrs("modem.codeword.state", c.state)...)
+			k := counterKey{modem.DirectionDownstream, ch, d.Frequency, d.Modulation, c.state}
+			seen[k] = true
+			start, v, ok := e.cumulative(k, t, c.v)
+			if !ok {
+				continue
+			}
+			codewords.Sum.DataPoints = append(codewords.Sum.DataPoints, dataPoint{Attributes: ca, StartTimeUnixNano: unixNano(start), TimeUnixNano: ts, AsDouble: v})
+		}
+	}
+	for _, ch := range s.UpstreamChannels() {
+		u := s.Upstream[ch]
+		a := attrs("modem.channel", string(ch), "modem.direction", string(modem.DirectionUpstream), "modem.frequency", u.Frequency, "modem.modulation", u.Modulation)
+		gp(&usPower, u.PowerLevel, a)
+		gp(&usRate, u.SymbolRate, a)
+		if rep != nil && rep.Upstream[ch].Level != quality.Unrated {
+			gp(&chQuality, float64(rep.Upstream[ch].Level), a)
+		}
+	}
+	// Forget series that are gone, such as a channel that moved frequency.
+	for k := range e.starts {
+		if !seen[k] {
+			delete(e.starts, k)
+		}
+	}
+	ms := []metric{dsPower, dsSNR}
+	if len(codewords.Sum.DataPoints) > 0 {
+		ms = append(ms, codewords)
+	}
+	ms = append(ms, usPower, usRate)
+	if rep != nil {
+		gp(&health, rep.Score, nil)
+		ms = append(ms, chQuality, health)
+	}
+
+	res := map[string]string{"service.name": "surfer"}
+	for k, v := range e.opts.Resource {
+		res[k] = v
+	}
+	var keys []string
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ra []keyValue
+	for _, k := range keys {
+		ra = append(ra, attrs(k, res[k])...)
+	}
+	return &exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: ra},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: "github.com/wathiede/surfer"}, Metrics: ms}},
+	}}}
+}
+
+// post sends b, retrying failures other than rejected requests with
+// exponential backoff.
+func (e *Exporter) post(b []byte) error {
+	var err error
+	backoff := e.backoff
+	for i := 0; i < e.retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = e.postOnce(b); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (e *Exporter) postOnce(b []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "surfer")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		// The OTLP spec only retries these.
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			retry = true
+		}
+		return retry, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return false, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+func testSignal(uncorrectable float64) *modem.Signal {
+	return &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Frequency: "459000000 Hz", Modulation: "QAM256", PowerLevel: 2.5, SNR: 40, Correctable: 10, Uncorrectable: uncorrectable},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Frequency: "36000000 Hz", Modulation: "ATDMA", PowerLevel: 45, SymbolRate: 5120},
+		},
+	}
+}
+
+type received struct {
+	header http.Header
+	req    exportRequest
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan received) {
+	ch := make(chan received, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("Got path %q, want /v1/metrics", r.URL.Path)
+		}
+		var rec received
+		rec.header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&rec.req); err != nil {
+			t.Errorf("Decoding request: %v", err)
+		}
+		code := http.StatusOK
+		if len(statuses) > 0 {
+			code, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(code)
+		ch <- rec
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+func next(t *testing.T, ch chan received) received {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an export")
+	}
+	return received{}
+}
+
+func findMetric(t *testing.T, r received, name string) metric {
+	t.Helper()
+	for _, m := range r.req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == name {
+			return m
+		}
+	}
+	t.Fatalf("No metric %s", name)
+	return metric{}
+}
+
+// uncorrectable returns the start time, time and value of the uncorrectable
+// codewords of downstream channel 1.
+func uncorrectable(t *testing.T, r received) (start, ts uint64, v float64) {
+	t.Helper()
+	m := findMetric(t, r, "modem.downstream.codewords")
+	if m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.AggregationTemporality != aggregationTemporalityCumulative {
+		t.Fatalf("Codewords got %+v, want a cumulative monotonic sum", m)
+	}
+	for _, dp := range m.Sum.DataPoints {
+		for _, a := range dp.Attributes {
+			if a.Key == "modem.codeword.state" && a.Value.StringValue == "uncorrectable" {
+				return dp.StartTimeUnixNano, dp.TimeUnixNano, dp.AsDouble
+			}
+		}
+	}
+	t.Fatal("No uncorrectable data point")
+	return 0, 0, 0
+}
+
+func TestExporter(t *testing.T) {
+	srv, ch := newReceiver(t)
+	e, err := NewExporter(Options{
+		Endpoint: srv.URL,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Resource: map[string]string{"modem.model": "SB8200", "modem.serial": "123"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	t0 := time.Unix(1577836800, 0)
+	profile := quality.Profiles["docsis"]
+
+	s := testSignal(5)
+	e.Export(t0, s, profile.Evaluate(s))
+	r := next(t, ch)
+	if got, want := r.header.Get("Authorization"), "Bearer secret"; got != want {
+		t.Errorf("Authorization got %q, want %q", got, want)
+	}
+	if got, want := r.header.Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("Content-Type got %q, want %q", got, want)
+	}
+	wantRes := attrs("modem.model", "SB8200", "modem.serial", "123", "service.name", "surfer")
+	if got := r.req.ResourceMetrics[0].Resource.Attributes; !reflect.DeepEqual(got, wantRes) {
+		t.Errorf("Resource got %+v, want %+v", got, wantRes)
+	}
+	snr := findMetric(t, r, "modem.downstream.snr")
+	wantSNR := &gauge{DataPoints: []dataPoint{{
+		Attributes:   attrs("modem.channel", "1", "modem.direction", "downstream", "modem.frequency", "459000000 Hz", "modem.modulation", "QAM256"),
+		TimeUnixNano: unixNano(t0),
+		AsDouble:     40,
+	}}}
+	if !reflect.DeepEqual(snr.Gauge, wantSNR) || snr.Unit != "dB" {
+		t.Errorf("SNR got %+v, want %+v", snr.Gauge, wantSNR)
+	}
+	if h := findMetric(t, r, "modem.health.score"); len(h.Gauge.DataPoints) != 1 {
+		t.Errorf("Health got %+v", h)
+	}
+	// The first observation of a counter only records its start.
+	for _, m := range r.req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == "modem.downstream.codewords" {
+			t.Errorf("First export got codewords %+v, want none", m)
+		}
+	}
+
+	// Later points count from the first observation.
+	t1 := t0.Add(time.Minute)
+	e.Export(t1, testSignal(8), nil)
+	if start, ts, v := uncorrectable(t, next(t, ch)); start != unixNano(t0) || ts != unixNano(t1) || v != 3 {
+		t.Errorf("Second export start %d time %d value %v, want %d %d 3", start, ts, v, unixNano(t0), unixNano(t1))
+	}
+	// A reset, e.g. a modem reboot, starts it again after the previous point.
+	t2 := t1.Add(time.Minute)
+	e.Export(t2, testSignal(1), nil)
+	if start, _, v := uncorrectable(t, next(t, ch)); start != unixNano(t1) || v != 1 {
+		t.Errorf("Export after reset start %d value %v, want %d 1", start, v, unixNano(t1))
+	}
+	// A channel that changes frequency is a new series.
+	t3 := t2.Add(time.Minute)
+	s = testSignal(4)
+	s.Downstream["1"].Frequency = "465000000 Hz"
+	e.Export(t3, s, nil)
+	r = next(t, ch)
+	for _, m := range r.req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == "modem.downstream.codewords" {
+			t.Errorf("Export after frequency change got codewords %+v, want none", m)
+		}
+	}
+}
+
+func TestExporterRetries(t *testing.T) {
+	srv, ch := newReceiver(t, http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest, http.StatusOK)
+	e, err := NewExporter(Options{Endpoint: srv.URL + "/v1/metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.backoff = time.Millisecond
+	e.Export(time.Now(), testSignal(0), nil)
+	next(t, ch)
+	next(t, ch)
+	// Rejected requests aren't retried.
+	e.Export(time.Now(), testSignal(0), nil)
+	next(t, ch)
+	e.Close()
+	select {
+	case <-ch:
+		t.Errorf("Rejected export was retried")
+	default:
+	}
+	// Exports racing shutdown are dropped.
+	e.Export(time.Now(), testSignal(0), nil)
+	e.Close()
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/groupcache/singleflight"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/record"
+)
+
+// poll is the outcome of fetching the modem status once.
+type poll struct {
+	Time     time.Time
+	Duration time.Duration
+	Signal   *modem.Signal
+	Err      error
+}
+
+// poller fetches the modem status when asked, or on a fixed interval, and
+// hands every result to its subscribers.
+type poller struct {
+	m       modem.Modem
+	timeout time.Duration
+	// maxAge is how old a poll cached returns may be when not polling in the
+	// background.
+	maxAge time.Duration
+	g      singleflight.Group
+
+	mu       sync.Mutex
+	interval time.Duration
+	last     *poll
+	lastOK   *poll
+	subs     []func(*poll)
+}
+
+// defaultMaxAge lets the dashboard, which asks for the status every 10
+// seconds, and any other API clients share fetches.
+const defaultMaxAge = 15 * time.Second
+
+func newPoller(m modem.Modem, timeout time.Duration) *poller {
+	return &poller{m: m, timeout: timeout, maxAge: defaultMaxAge}
+}
+
+// subscribe registers f to be called with every poll, in the order
+// subscribed.  f is called from the fetching goroutine and should not block
+// for long.
+func (p *poller) subscribe(f func(*poll)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subs = append(p.subs, f)
+}
+
+// fetch queries the modem.  Only one query is made if concurrent requests
+// come in, all callers share its result.
+func (p *poller) fetch(ctx context.Context) *poll {
+	v, _ := p.g.Do("get", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(record.Scrape(ctx, "status"), p.timeout)
+		defer cancel()
+		start := time.Now()
+		s, err := p.m.Status(ctx)
+		r := &poll{Time: start, Duration: time.Since(start), Signal: s, Err: err}
+		p.mu.Lock()
+		p.last = r
+		if err == nil {
+			p.lastOK = r
+		}
+		subs := p.subs
+		p.mu.Unlock()
+		for _, f := range subs {
+			f(r)
+		}
+		return r, nil
+	})
+	return v.(*poll)
+}
+
+// latest returns the most recent poll and the most recent successful poll.
+// Either is nil if there hasn't been one yet.
+func (p *poller) latest() (last, lastOK *poll) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last, p.lastOK
+}
+
+// cached returns the latest poll if the modem is being polled in the
+// background or it is younger than p.maxAge, otherwise it fetches.
+func (p *poller) cached(ctx context.Context) (last, lastOK *poll) {
+	p.mu.Lock()
+	fresh := p.last != nil && (p.interval > 0 || time.Since(p.last.Time) < p.maxAge)
+	p.mu.Unlock()
+	if !fresh {
+		p.fetch(ctx)
+	}
+	return p.latest()
+}
+
+// run fetches every interval until ctx is done.  A fetch in progress when ctx
+// is done is finished rather than cancelled, so subscribers aren't handed a
+// spurious error on shutdown.
+func (p *poller) run(ctx context.Context, interval time.Duration) {
+	p.mu.Lock()
+	p.interval = interval
+	p.mu.Unlock()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if r := p.fetch(context.Background()); r.Err != nil {
+			glog.Warningf("Failed to poll %s: %v", p.m.Name(), r.Err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushTimeout bounds each request to the Pushgateway, so one that stops
+// answering can't hold up pushing or shutting down.
+const pushTimeout = 30 * time.Second
+
+// pusher pushes metrics to a Pushgateway for sites Prometheus can't scrape.
+// Pushes happen in the background, a failed push is retried with exponential
+// backoff until it succeeds or the next poll asks for a fresh push.
+type pusher struct {
+	url      string
+	job      string
+	grouping map[string]string
+	g        prometheus.Gatherer
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// timeout bounds each request.
+	timeout time.Duration
+
+	trigger chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// newPusher returns a pusher of the metrics in g to the Pushgateway at u.
+// maxBackoff bounds the wait between retries, usually the poll interval.
+func newPusher(u, job string, grouping map[string]string, g prometheus.Gatherer, maxBackoff time.Duration) *pusher {
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	p := &pusher{
+		url:        strings.TrimSuffix(u, "/"),
+		job:        job,
+		grouping:   grouping,
+		g:          g,
+		backoff:    time.Second,
+		maxBackoff: maxBackoff,
+		timeout:    pushTimeout,
+		trigger:    make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// exportedGatherer gathers the surfer metrics from the default registry,
+// leaving out the go_ and process_ metrics.  Those describe this process
+// rather than the modem, and would live on in the Pushgateway after it exits.
+var exportedGatherer = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	var out []*dto.MetricFamily
+	for _, mf := range mfs {
+		if n := mf.GetName(); strings.HasPrefix(n, "go_") || strings.HasPrefix(n, "process_") {
+			continue
+		}
+		out = append(out, mf)
+	}
+	return out, err
+})
+
+// push asks for the metrics to be pushed.  It never blocks.
+func (p *pusher) push() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pusher) loop() {
+	defer close(p.done)
+	// ctx cancels a push in progress when closing.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-p.trigger:
+		}
+		backoff := p.backoff
+		for {
+			// Every attempt gathers the current values.
+			err := p.put(ctx)
+			if err == nil {
+				break
+			}
+			glog.Errorf("Failed to push to %s, retrying in %s: %v", p.url, backoff, err)
+			select {
+			case <-p.quit:
+				return
+			case <-p.trigger:
+				// A newer poll, push it without waiting.
+				backoff = p.backoff
+				continue
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; p.maxBackoff > 0 && backoff > p.maxBackoff {
+				backoff = p.maxBackoff
+			}
+		}
+	}
+}
+
+// put replaces the pusher's group with the current metrics, as
+// push.FromGatherer does but giving up when ctx is done.
+func (p *pusher) put(ctx context.Context) error {
+	mfs, err := p.g.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("PUT", p.groupURL(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtProtoDelim))
+	return p.do(req.WithContext(ctx))
+}
+
+// do sends req to the Pushgateway within the pusher's timeout.
+func (p *pusher) do(req *http.Request) error {
+	c := &http.Client{Timeout: p.timeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d for %s %s: %s", resp.StatusCode, req.Method, req.URL, body)
+	}
+	return nil
+}
+
+// groupURL is the URL of the pusher's group, the same as push.FromGatherer
+// pushes to.
+func (p *pusher) groupURL() string {
+	var keys []string
+	for k := range p.grouping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{url.QueryEscape(p.job)}
+	for _, k := range keys {
+		parts = append(parts, k, p.grouping[k])
+	}
+	return fmt.Sprintf("%s/metrics/job/%s", p.url, strings.Join(parts, "/"))
+}
+
+// close stops pushing and deletes the group from the Pushgateway, so a
+// cleanly stopped surfer doesn't leave stale values behind.
+func (p *pusher) close() error {
+	close(p.quit)
+	<-p.done
+	req, err := http.NewRequest("DELETE", p.groupURL(), nil)
+	if err != nil {
+		return err
+	}
+	return p.do(req)
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pushgateway is a stand-in Pushgateway that fails the first failures
+// requests.
+type pushgateway struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	reqs     []string
+}
+
+func newPushgateway(t *testing.T, failures int) *pushgateway {
+	pg := &pushgateway{failures: failures}
+	pg.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pg.mu.Lock()
+		defer pg.mu.Unlock()
+		pg.reqs = append(pg.reqs, r.Method+" "+r.URL.Path)
+		if pg.failures > 0 {
+			pg.failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(pg.Close)
+	return pg
+}
+
+// wait returns the requests once there are n of them.
+func (pg *pushgateway) wait(t *testing.T, n int) []string {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		pg.mu.Lock()
+		reqs := append([]string(nil), pg.reqs...)
+		pg.mu.Unlock()
+		if len(reqs) >= n {
+			return reqs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d requests", n)
+	return nil
+}
+
+func testRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "signal_health_score", Help: "test"})
+	g.Set(90)
+	r.MustRegister(g)
+	return r
+}
+
+func TestPusher(t *testing.T) {
+	pg := newPushgateway(t, 2)
+	pu := newPusher(pg.URL, "surfer", map[string]string{"site": "cabin", "modem": "SB8200"}, testRegistry(), time.Second)
+	pu.backoff = time.Millisecond
+	pu.push()
+
+	// Two failures, then the retry succeeds.
+	reqs := pg.wait(t, 3)
+	for _, r := range reqs {
+		if !strings.HasPrefix(r, "PUT /metrics/job/surfer/") || !strings.Contains(r, "/site/cabin") || !strings.Contains(r, "/modem/SB8200") {
+			t.Errorf("Got request %q, want PUT of the site and modem group", r)
+		}
+	}
+
+	if err := pu.close(); err != nil {
+		t.Fatal(err)
+	}
+	reqs = pg.wait(t, 4)
+	if got, want := reqs[len(reqs)-1], "DELETE /metrics/job/surfer/modem/SB8200/site/cabin"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if len(reqs) != 4 {
+		t.Errorf("Got %d requests, want 4: %v", len(reqs), reqs)
+	}
+}
+
+func TestPusherCloseWhileUnanswered(t *testing.T) {
+	// A Pushgateway that never answers.
+	reqs := make(chan string, 10)
+	stop := make(chan struct{})
+	pg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs <- r.Method
+		<-stop
+	}))
+	defer pg.Close()
+	defer close(stop)
+	pu := newPusher(pg.URL, "surfer", map[string]string{"site": "cabin"}, testRegistry(), time.Second)
+	pu.timeout = 100 * time.Millisecond
+	pu.push()
+	select {
+	case <-reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a push")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- pu.close() }()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Errorf("close of an unanswered delete succeeded, want timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked on the unanswered Pushgateway")
+	}
+}
+
+func TestExportedGatherer(t *testing.T) {
+	mfs, err := exportedGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, mf := range mfs {
+		n := mf.GetName()
+		if strings.HasPrefix(n, "go_") || strings.HasPrefix(n, "process_") {
+			t.Errorf("Got process metric %s", n)
+		}
+		if n == "fetch_errors" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("fetch_errors not gathered")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quality rates modem signal data against DOCSIS operating ranges,
+// so users don't each have to encode "is this in spec?" by hand.
+package quality
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// Level rates a value.  Higher is worse.
+type Level int
+
+const (
+	// Unrated is the Level of a channel with nothing that could be rated,
+	// such as one of an unknown modulation.
+	Unrated Level = iota - 1
+	Good
+	Marginal
+	Bad
+)
+
+func (l Level) String() string {
+	switch l {
+	case Unrated:
+		return "unrated"
+	case Good:
+		return "good"
+	case Marginal:
+		return "marginal"
+	}
+	return "bad"
+}
+
+// MarshalText encodes l as its name.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a name written by MarshalText.
+func (l *Level) UnmarshalText(b []byte) error {
+	for _, v := range []Level{Unrated, Good, Marginal, Bad} {
+		if v.String() == string(b) {
+			*l = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", b)
+}
+
+// Range is an inclusive range of values.  In JSON it is written as a two
+// element array where null leaves that end unbounded, e.g. [33, null].
+type Range struct {
+	Min, Max float64
+}
+
+// Contains reports whether v is in r.
+func (r Range) Contains(v float64) bool {
+	return v >= r.Min && v <= r.Max
+}
+
+// MarshalJSON encodes r as [min, max].
+func (r Range) MarshalJSON() ([]byte, error) {
+	end := func(f float64) *float64 {
+		if math.IsInf(f, 0) {
+			return nil
+		}
+		return &f
+	}
+	return json.Marshal([2]*float64{end(r.Min), end(r.Max)})
+}
+
+// UnmarshalJSON decodes [min, max].
+func (r *Range) UnmarshalJSON(b []byte) error {
+	var v [2]*float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	r.Min, r.Max = math.Inf(-1), math.Inf(1)
+	if v[0] != nil {
+		r.Min = *v[0]
+	}
+	if v[1] != nil {
+		r.Max = *v[1]
+	}
+	if r.Min > r.Max {
+		return fmt.Errorf("range %s has min greater than max", b)
+	}
+	return nil
+}
+
+// Band rates a value Good inside Good, Marginal inside Marginal and Bad
+// otherwise.
+type Band struct {
+	Good     Range `json:"good"`
+	Marginal Range `json:"marginal"`
+}
+
+// Rate returns the Level of v.
+func (b Band) Rate(v float64) Level {
+	switch {
+	case b.Good.Contains(v):
+		return Good
+	case b.Marginal.Contains(v):
+		return Marginal
+	}
+	return Bad
+}
+
+func band(goodMin, goodMax, marginalMin, marginalMax float64) Band {
+	return Band{Good: Range{goodMin, goodMax}, Marginal: Range{marginalMin, marginalMax}}
+}
+
+var inf = math.Inf(1)
+
+// Profile holds the bands every value is rated against.
+type Profile struct {
+	Name string `json:"name"`
+	// Downstream power in dBmV keyed by modulation, see Modulation.
+	DownstreamPower map[string]Band `json:"downstream_power"`
+	// Downstream SNR or MER in dB keyed by modulation, see Modulation.
+	SNR map[string]Band `json:"snr"`
+	// Upstream transmit power in dBmV keyed by the number of bonded upstream
+	// channels.  The entry for the largest count not above the actual number
+	// of channels is used.
+	UpstreamPower map[int]Band `json:"upstream_power"`
+	// Ratio of uncorrectable codewords to all codewords received.
+	CodewordErrorRatio Band `json:"codeword_error_ratio"`
+}
+
+// Profiles are the built in profiles, keyed by name.
+var Profiles = map[string]*Profile{
+	// Commonly quoted DOCSIS 3.0 and 3.1 operating ranges.
+	"docsis": {
+		Name: "docsis",
+		DownstreamPower: map[string]Band{
+			"QAM64":   band(-10, 10, -15, 15),
+			"QAM256":  band(-7, 7, -15, 15),
+			"QAM4096": band(-7, 7, -12, 15),
+			"OFDM":    band(-7, 7, -12, 15),
+		},
+		SNR: map[string]Band{
+			"QAM64":   band(27, inf, 24, inf),
+			"QAM256":  band(33, inf, 30, inf),
+			"QAM4096": band(41, inf, 38, inf),
+			"OFDM":    band(38, inf, 34, inf),
+		},
+		UpstreamPower: map[int]Band{
+			1: band(35, 51, 32, 58),
+			2: band(35, 51, 32, 55),
+			3: band(35, 51, 32, 54),
+		},
+		CodewordErrorRatio: band(0, 1e-5, 0, 1e-3),
+	},
+	// Tighter ranges preferred by some ISPs before they will dispatch a
+	// technician.
+	"strict": {
+		Name: "strict",
+		DownstreamPower: map[string]Band{
+			"QAM64":   band(-5, 5, -8, 8),
+			"QAM256":  band(-4, 4, -7, 7),
+			"QAM4096": band(-4, 4, -6, 10),
+			"OFDM":    band(-4, 4, -6, 10),
+		},
+		SNR: map[string]Band{
+			"QAM64":   band(30, inf, 27, inf),
+			"QAM256":  band(36, inf, 33, inf),
+			"QAM4096": band(43, inf, 41, inf),
+			"OFDM":    band(40, inf, 38, inf),
+		},
+		UpstreamPower: map[int]Band{
+			1: band(40, 48, 37, 51),
+			2: band(40, 48, 37, 51),
+			3: band(40, 47, 37, 50),
+		},
+		CodewordErrorRatio: band(0, 1e-6, 0, 1e-4),
+	},
+}
+
+// Load returns the built in profile called name, or if there is none, reads
+// a JSON encoded profile from the file name.  Top level fields missing from
+// the file are taken from the "docsis" profile.
+func Load(name string) (*Profile, error) {
+	if p, ok := Profiles[name]; ok {
+		return p, nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("no built in profile %q and failed to read it as a file: %v", name, err)
+	}
+	def := Profiles["docsis"]
+	p := &Profile{Name: name}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if p.DownstreamPower == nil {
+		p.DownstreamPower = def.DownstreamPower
+	}
+	if p.SNR == nil {
+		p.SNR = def.SNR
+	}
+	if p.UpstreamPower == nil {
+		p.UpstreamPower = def.UpstreamPower
+	}
+	if p.CodewordErrorRatio == (Band{}) {
+		p.CodewordErrorRatio = def.CodewordErrorRatio
+	}
+	return p, nil
+}
+
+// Modulation normalizes the modulation names used by different modems, such
+// as "QAM256", "256QAM" and "OFDM PLC", to the keys used by Profile.
+func Modulation(m string) string {
+	m = strings.ToUpper(m)
+	switch {
+	case strings.Contains(m, "OFDM"):
+		return "OFDM"
+	case strings.Contains(m, "4096"):
+		return "QAM4096"
+	case strings.Contains(m, "256"):
+		return "QAM256"
+	case strings.Contains(m, "64"):
+		return "QAM64"
+	}
+	return m
+}
+
+// Field names used in Result.Fields.
+const (
+	Power              = "power"
+	SNR                = "snr"
+	CodewordErrorRatio = "codeword_error_ratio"
+)
+
+// Result rates a single channel.
+type Result struct {
+	// Level is the worst level of all Fields, Unrated if there are none.
+	Level Level `json:"level"`
+	// Fields rates each value that could be checked.
+	Fields map[string]Level `json:"fields"`
+}
+
+func (r *Result) rate(field string, l Level) {
+	if r.Fields == nil {
+		r.Fields = map[string]Level{}
+	}
+	r.Fields[field] = l
+	if l > r.Level {
+		r.Level = l
+	}
+}
+
+// Report rates every channel of a modem.Signal.
+type Report struct {
+	Profile    string                   `json:"profile"`
+	Downstream map[modem.Channel]Result `json:"downstream"`
+	Upstream   map[modem.Channel]Result `json:"upstream"`
+	// Level is the worst level of any channel, Unrated if none was rated.
+	Level Level `json:"level"`
+	// Score summarizes health from 0 to 100.  Every rated field counts 1 when
+	// good, 0.5 when marginal and 0 when bad.  A signal with no channels
+	// scores 0.
+	Score float64 `json:"score"`
+}
+
+// Evaluate rates every channel of s.
+func (p *Profile) Evaluate(s *modem.Signal) *Report {
+	r := &Report{
+		Profile:    p.Name,
+		Downstream: map[modem.Channel]Result{},
+		Upstream:   map[modem.Channel]Result{},
+		Level:      Unrated,
+	}
+	var points, fields float64
+	add := func(res Result) {
+		for _, l := range res.Fields {
+			fields++
+			switch l {
+			case Good:
+				points++
+			case Marginal:
+				points += 0.5
+			}
+		}
+		if res.Level > r.Level {
+			r.Level = res.Level
+		}
+	}
+	for ch, d := range s.Downstream {
+		res := p.Downstream(d)
+		r.Downstream[ch] = res
+		add(res)
+	}
+	for ch, u := range s.Upstream {
+		res := p.Upstream(u, len(s.Upstream))
+		r.Upstream[ch] = res
+		add(res)
+	}
+	if fields > 0 {
+		r.Score = 100 * points / fields
+	}
+	return r
+}
+
+// Downstream rates a downstream channel.  Values that can't be rated, such
+// as those of an unknown modulation, are left out of the Result.
+func (p *Profile) Downstream(d *modem.Downstream) Result {
+	r := Result{Level: Unrated}
+	mod := Modulation(d.Modulation)
+	if b, ok := p.DownstreamPower[mod]; ok {
+		r.rate(Power, b.Rate(d.PowerLevel))
+	}
+	if b, ok := p.SNR[mod]; ok {
+		r.rate(SNR, b.Rate(d.SNR))
+	}
+	// Many modems don't count unerrored codewords, which leaves no total to
+	// compare against.
+	if d.Unerrored > 0 {
+		total := d.Unerrored + d.Correctable + d.Uncorrectable
+		r.rate(CodewordErrorRatio, p.CodewordErrorRatio.Rate(d.Uncorrectable/total))
+	}
+	return r
+}
+
+// Upstream rates an upstream channel of a modem bonded to n upstream
+// channels.
+func (p *Profile) Upstream(u *modem.Upstream, n int) Result {
+	r := Result{Level: Unrated}
+	var counts []int
+	for c := range p.UpstreamPower {
+		counts = append(counts, c)
+	}
+	sort.Ints(counts)
+	best := -1
+	for _, c := range counts {
+		if c <= n {
+			best = c
+		}
+	}
+	if best != -1 {
+		r.rate(Power, p.UpstreamPower[best].Rate(u.PowerLevel))
+	}
+	return r
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+func TestModulation(t *testing.T) {
+	for in, want := range map[string]string{
+		"QAM256":             "QAM256",
+		"256QAM":             "QAM256",
+		"qam 64":             "QAM64",
+		"OFDM PLC":           "OFDM",
+		"Other":              "OTHER",
+		"4096QAM":            "QAM4096",
+		"[3] QPSK [3] 64QAM": "QAM64",
+	} {
+		if got := Modulation(in); got != want {
+			t.Errorf("Modulation(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDownstream(t *testing.T) {
+	p := Profiles["docsis"]
+	for _, tc := range []struct {
+		name string
+		d    modem.Downstream
+		want Result
+	}{
+		{
+			name: "good QAM256",
+			d:    modem.Downstream{Modulation: "QAM256", PowerLevel: 2, SNR: 38},
+			want: Result{Level: Good, Fields: map[string]Level{Power: Good, SNR: Good}},
+		},
+		{
+			name: "marginal power",
+			d:    modem.Downstream{Modulation: "QAM256", PowerLevel: -9, SNR: 38},
+			want: Result{Level: Marginal, Fields: map[string]Level{Power: Marginal, SNR: Good}},
+		},
+		{
+			// 30 dB is fine for QAM64 but bad for QAM256.
+			name: "QAM64 SNR",
+			d:    modem.Downstream{Modulation: "QAM64", PowerLevel: 0, SNR: 30},
+			want: Result{Level: Good, Fields: map[string]Level{Power: Good, SNR: Good}},
+		},
+		{
+			name: "bad QAM256 SNR",
+			d:    modem.Downstream{Modulation: "QAM256", PowerLevel: 0, SNR: 29},
+			want: Result{Level: Bad, Fields: map[string]Level{Power: Good, SNR: Bad}},
+		},
+		{
+			name: "codeword errors",
+			d:    modem.Downstream{Modulation: "QAM256", SNR: 38, Unerrored: 99990, Uncorrectable: 10},
+			want: Result{Level: Marginal, Fields: map[string]Level{Power: Good, SNR: Good, CodewordErrorRatio: Marginal}},
+		},
+		{
+			name: "unknown modulation",
+			d:    modem.Downstream{Modulation: "Unknown", PowerLevel: 100},
+			want: Result{Level: Unrated},
+		},
+		{
+			name: "SB8200 OFDM without codeword counts",
+			d:    modem.Downstream{Modulation: "Other", PowerLevel: 1, SNR: 40},
+			want: Result{Level: Unrated},
+		},
+	} {
+		if got := p.Downstream(&tc.d); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestUpstreamBonding(t *testing.T) {
+	p := Profiles["docsis"]
+	u := &modem.Upstream{PowerLevel: 56}
+	// 56 dBmV is within spec for a single channel but not with four bonded.
+	for n, want := range map[int]Level{1: Marginal, 2: Bad, 4: Bad} {
+		if got := p.Upstream(u, n).Level; got != want {
+			t.Errorf("%d channels: got %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	s := &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Modulation: "QAM256", PowerLevel: 0, SNR: 38},
+			"2": {Modulation: "QAM256", PowerLevel: 10, SNR: 29},
+			"3": {Modulation: "Other", PowerLevel: 30},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {PowerLevel: 45},
+		},
+	}
+	r := Profiles["docsis"].Evaluate(s)
+	if r.Level != Bad {
+		t.Errorf("Level got %v, want %v", r.Level, Bad)
+	}
+	// 3 good, 1 marginal and 1 bad of 5 fields.
+	if want := 100 * 3.5 / 5; r.Score != want {
+		t.Errorf("Score got %v, want %v", r.Score, want)
+	}
+	if got := r.Downstream["2"].Level; got != Bad {
+		t.Errorf("Downstream 2 got %v, want %v", got, Bad)
+	}
+	if got := r.Downstream["3"].Level; got != Unrated {
+		t.Errorf("Downstream 3 got %v, want %v", got, Unrated)
+	}
+
+	if got := Profiles["docsis"].Evaluate(&modem.Signal{}).Level; got != Unrated {
+		t.Errorf("Level of no channels got %v, want %v", got, Unrated)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if p, err := Load("strict"); err != nil || p.Name != "strict" {
+		t.Fatalf("Load(strict) = %v, %v", p, err)
+	}
+
+	dir, err := ioutil.TempDir("", "quality")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "isp.json")
+	profile := `{"snr": {"QAM256": {"good": [35, null], "marginal": [34, null]}}}`
+	if err := ioutil.WriteFile(path, []byte(profile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &modem.Downstream{Modulation: "QAM256", PowerLevel: 0, SNR: 34.5}
+	if got := p.Downstream(d).Fields[SNR]; got != Marginal {
+		t.Errorf("SNR 34.5 got %v, want %v", got, Marginal)
+	}
+	if _, ok := p.SNR["QAM64"]; ok {
+		t.Errorf("SNR bands in the file should replace the defaults, got QAM64 band")
+	}
+	if !reflect.DeepEqual(p.DownstreamPower, Profiles["docsis"].DownstreamPower) {
+		t.Errorf("Missing downstream power bands not taken from docsis profile")
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Load of missing file succeeded")
+	}
+}
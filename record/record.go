@@ -0,0 +1,370 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package record captures the raw HTTP exchanges made with a cable modem.
+// Each scrape, the requests of one logical fetch such as of the status page,
+// is written to its own directory so the response bodies can be attached to
+// bug reports or copied directly into a driver's testdata.
+package record
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// timeFormat names scrape directories so they sort chronologically.  The
+// kind of scrape follows it.
+const timeFormat = "20060102T150405.000Z"
+
+// scrape is the exchanges of one logical fetch.
+type scrape struct {
+	kind string
+	// dir is where the scrape is written, set with its first exchange.
+	dir       string
+	exchanges []*Exchange
+}
+
+type scrapeKey struct{}
+
+// Scrape returns a context whose requests are recorded together, as a new
+// scrape of kind, such as "status" or "info".  Requests made outside any
+// scrape are each recorded as a scrape of their own, named after their path.
+func Scrape(ctx context.Context, kind string) context.Context {
+	return context.WithValue(ctx, scrapeKey{}, &scrape{kind: kind})
+}
+
+// Exchange is a single request made to the modem and the response it
+// returned.
+type Exchange struct {
+	// Name is the base name used for the files of this exchange.
+	Name string
+	// Request is the request as sent on the wire, including any body, with
+	// credentials redacted.
+	Request []byte
+	// Response is the response status line and headers followed by the body,
+	// with credentials redacted.  It is empty if the request failed.
+	Response []byte
+	// Body is the raw response body, or REDACTED for a login.
+	Body []byte
+	// Err is the transport error, if any.
+	Err error
+}
+
+// Recorder wraps an http.RoundTripper and saves every exchange made through
+// it.  The exchanges from the most recent scrape of each kind are always kept
+// in memory.  If a directory is configured, they are also written to disk,
+// keeping only the newest scrapes.
+type Recorder struct {
+	dir  string
+	keep int
+	now  func() time.Time
+
+	mu sync.Mutex
+	// last maps kinds to their most recent scrape.
+	last map[string]*scrape
+}
+
+// New returns a Recorder that writes each scrape to a new subdirectory of dir,
+// deleting the oldest subdirectories so no more than keep remain.  If dir is
+// empty, nothing is written to disk.  If keep is zero or less, scrapes are
+// never deleted.
+func New(dir string, keep int) (*Recorder, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Recorder{dir: dir, keep: keep, now: time.Now, last: map[string]*scrape{}}, nil
+}
+
+// Kinds returns the kinds of scrape recorded so far, sorted.
+func (r *Recorder) Kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []string
+	for k := range r.last {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Last returns the exchanges of the most recent scrape of kind.
+func (r *Recorder) Last(kind string) []*Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.last[kind]
+	if !ok {
+		return nil
+	}
+	return append([]*Exchange(nil), s.exchanges...)
+}
+
+// Wrap returns an http.RoundTripper that records every exchange made through
+// rt.  Its signature matches modem.SetTransportWrapper.
+func (r *Recorder) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return &transport{r: r, rt: rt}
+}
+
+type transport struct {
+	r  *Recorder
+	rt http.RoundTripper
+}
+
+// redacted replaces credentials in recorded exchanges.
+const redacted = "REDACTED"
+
+// secretHeaders carry credentials and are redacted from recorded exchanges.
+var secretHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "HNAP_AUTH"}
+
+// isLogin reports whether req logs in to the modem, in which case both its
+// body and the response carry credentials.  The SB8200 sends its credentials
+// in a query starting with login_, the S33 in a HNAP Login action.
+func isLogin(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.RawQuery, "login_") ||
+		strings.HasSuffix(strings.Trim(req.Header.Get("SOAPAction"), `"`), "/Login")
+}
+
+// redactHeader returns a copy of h with credentials redacted.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range secretHeaders {
+		if _, ok := h[http.CanonicalHeaderKey(k)]; ok {
+			h.Set(k, redacted)
+		}
+	}
+	return h
+}
+
+// dumpRequest returns req as sent on the wire with credentials redacted.  The
+// query is always redacted, as it carries the login or session token of some
+// modems.
+func dumpRequest(req *http.Request) ([]byte, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		body = b
+	}
+	login := isLogin(req)
+	r := req.Clone(req.Context())
+	r.Header = redactHeader(req.Header)
+	if r.URL.RawQuery != "" {
+		r.URL.RawQuery = redacted
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if login {
+		r.Body = nil
+		r.ContentLength = 0
+	}
+	dump, err := httputil.DumpRequestOut(r, !login)
+	if err != nil {
+		return nil, err
+	}
+	if login && len(body) > 0 {
+		dump = append(dump, redacted...)
+	}
+	return dump, nil
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := &Exchange{}
+	reqDump, err := dumpRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	x.Request = reqDump
+	login := isLogin(req)
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		x.Err = err
+		t.r.add(req, nil, x)
+		return nil, err
+	}
+	body, readErr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	x.Err = readErr
+	x.Body = body
+	if login {
+		x.Body = []byte(redacted)
+	}
+	dumped := *resp
+	dumped.Header = redactHeader(resp.Header)
+	respDump, dumpErr := httputil.DumpResponse(&dumped, false)
+	if dumpErr != nil {
+		return nil, dumpErr
+	}
+	x.Response = append(respDump, x.Body...)
+	t.r.add(req, resp, x)
+	if readErr != nil {
+		return nil, fmt.Errorf("reading response body: %w", readErr)
+	}
+	return resp, nil
+}
+
+func (r *Recorder) add(req *http.Request, resp *http.Response, x *Exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := req.Context().Value(scrapeKey{}).(*scrape)
+	if !ok {
+		s = &scrape{kind: baseName(req.URL.Path)}
+	}
+	x.Name = fmt.Sprintf("%02d-%s", len(s.exchanges)+1, baseName(req.URL.Path))
+	s.exchanges = append(s.exchanges, x)
+	if len(s.exchanges) == 1 {
+		r.last[s.kind] = s
+	}
+	if r.dir == "" {
+		return
+	}
+	if s.dir == "" {
+		s.dir = filepath.Join(r.dir, r.now().UTC().Format(timeFormat)+"-"+s.kind)
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			glog.Errorf("Failed to create record directory: %v", err)
+			s.dir = ""
+			return
+		}
+		r.rotate()
+	}
+	if err := write(s.dir, x, resp); err != nil {
+		glog.Errorf("Failed to record %s: %v", req.URL, err)
+	}
+}
+
+// write saves x and the body of resp, if any, in dir.
+func write(dir string, x *Exchange, resp *http.Response) error {
+	p := filepath.Join(dir, x.Name)
+	if err := ioutil.WriteFile(p+".request", x.Request, 0644); err != nil {
+		return err
+	}
+	if x.Err != nil {
+		if err := ioutil.WriteFile(p+".error", []byte(x.Err.Error()+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(p+".response", x.Response, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p+extension(resp), x.Body, 0644)
+}
+
+// rotate removes the oldest scrape directories of each kind beyond r.keep,
+// so frequent status scrapes don't push out the rarer kinds.  r.mu must be
+// held.
+func (r *Recorder) rotate() {
+	if r.keep <= 0 {
+		return
+	}
+	fis, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		glog.Errorf("Failed to list %q: %v", r.dir, err)
+		return
+	}
+	dirs := map[string][]string{}
+	for _, fi := range fis {
+		n := fi.Name()
+		if !fi.IsDir() || len(n) < len(timeFormat) {
+			continue
+		}
+		if _, err := time.Parse(timeFormat, n[:len(timeFormat)]); err != nil {
+			continue
+		}
+		kind := n[len(timeFormat):]
+		dirs[kind] = append(dirs[kind], n)
+	}
+	for _, ds := range dirs {
+		sort.Strings(ds)
+		for len(ds) > r.keep {
+			if err := os.RemoveAll(filepath.Join(r.dir, ds[0])); err != nil {
+				glog.Errorf("Failed to remove old recording: %v", err)
+			}
+			ds = ds[1:]
+		}
+	}
+}
+
+// ServeHTTP writes every exchange of the most recent scrape of each kind as
+// plain text.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	kinds := r.Kinds()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(kinds) == 0 {
+		fmt.Fprintln(w, "No responses recorded yet.")
+		return
+	}
+	for _, k := range kinds {
+		for _, x := range r.Last(k) {
+			fmt.Fprintf(w, "==> %s/%s request <==\n", k, x.Name)
+			w.Write(x.Request)
+			fmt.Fprintf(w, "\n\n==> %s/%s response <==\n", k, x.Name)
+			if x.Err != nil {
+				fmt.Fprintf(w, "error: %v\n", x.Err)
+			}
+			w.Write(x.Response)
+			fmt.Fprint(w, "\n\n")
+		}
+	}
+}
+
+func baseName(p string) string {
+	b := strings.TrimSuffix(path.Base(p), path.Ext(p))
+	if b == "" || b == "/" || b == "." {
+		return "index"
+	}
+	return b
+}
+
+// extension picks a file extension for the response body, preferring the
+// Content-Type returned by the modem.
+func extension(resp *http.Response) string {
+	if mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		switch mt {
+		case "text/html":
+			return ".html"
+		case "application/json":
+			return ".json"
+		case "text/plain":
+			return ".txt"
+		}
+	}
+	if resp.Request != nil {
+		if ext := path.Ext(resp.Request.URL.Path); ext != "" {
+			return ext
+		}
+	}
+	return ".body"
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package record
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const page = `<html><body><span id="thisModelNumberIs">SB8200</span></body></html>`
+
+func TestRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := New(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	c := &http.Client{Transport: r.Wrap(http.DefaultTransport)}
+
+	get := func(ctx context.Context, path string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != page {
+			t.Errorf("Caller got body %q, want %q", got, page)
+		}
+	}
+
+	info := Scrape(context.Background(), "info")
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		status := Scrape(context.Background(), "status")
+		get(status, "/cmconnectionstatus.html")
+		if i == 0 {
+			// A scrape of another kind in progress at the same time
+			// stays separate.
+			get(info, "/cmswinfo.html")
+			get(context.Background(), "/cmeventlog.html")
+			get(info, "/cmswinfo.html")
+		}
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fi.Name())
+	}
+	// Old status scrapes are removed without pushing out the other kinds.
+	want := []string{
+		"20200101T000100.000Z-cmeventlog",
+		"20200101T000100.000Z-info",
+		"20200101T000200.000Z-status",
+		"20200101T000300.000Z-status",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Scrape directories got %v, want %v", got, want)
+	}
+
+	p := filepath.Join(dir, want[3], "01-cmconnectionstatus.html")
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	if string(b) != page {
+		t.Errorf("%s got %q, want %q", p, b, page)
+	}
+	for _, ext := range []string{".request", ".response"} {
+		if _, err := os.Stat(filepath.Join(dir, want[3], "01-cmconnectionstatus"+ext)); err != nil {
+			t.Errorf("Missing %s dump: %v", ext, err)
+		}
+	}
+	if xs := r.Last("info"); len(xs) != 2 || xs[1].Name != "02-cmswinfo" {
+		t.Errorf("Last info scrape got %+v, want 2 exchanges", xs)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/last-response", nil))
+	for _, s := range []string{"==> status/01-cmconnectionstatus request", "GET /cmconnectionstatus.html", "HTTP/1.1 200 OK", page, "==> info/02-cmswinfo response"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("/debug/last-response missing %q:\n%s", s, w.Body.String())
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	const secret = "c2VjcmV0"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sessionId", Value: secret})
+		w.Write([]byte(secret))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: r.Wrap(http.DefaultTransport)}
+
+	req, err := http.NewRequest("GET", ts.URL+"/cmconnectionstatus.html?login_"+secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Basic "+secret)
+	req.AddCookie(&http.Cookie{Name: "credential", Value: secret})
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != secret {
+		t.Errorf("Caller got body %q, want %q", b, secret)
+	}
+
+	req, err = http.NewRequest("POST", ts.URL+"/HNAP1/", strings.NewReader(`{"Login":{"LoginPassword":"`+secret+`"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("SOAPAction", `"http://purenetworks.com/HNAP1/Login"`)
+	req.Header.Set("HNAP_AUTH", secret)
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/last-response", nil))
+	if strings.Contains(w.Body.String(), secret) {
+		t.Errorf("/debug/last-response contains a credential:\n%s", w.Body.String())
+	}
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(b), secret) {
+			t.Errorf("%s contains a credential:\n%s", p, b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent so the body read fails.
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(page))
+	}))
+	defer ts.Close()
+
+	r, err := New("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: r.Wrap(http.DefaultTransport)}
+	if _, err := c.Get(ts.URL + "/cmconnectionstatus.html"); err == nil {
+		t.Error("Get of a truncated body succeeded")
+	}
+	xs := r.Last("cmconnectionstatus")
+	if len(xs) != 1 || xs[0].Err == nil || string(xs[0].Body) != page {
+		t.Errorf("Recorded %+v, want the partial body and its error", xs)
+	}
+}
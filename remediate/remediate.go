@@ -0,0 +1,479 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remediate reboots the modem when configured conditions hold for a
+// while, within limits on how often and when it may do so.  Every reboot and
+// every reboot suppressed by those limits is recorded.
+package remediate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/alert"
+	"github.com/wathiede/surfer/modem"
+)
+
+// Condition kinds.
+const (
+	// The uncorrectable codeword total grows faster than Threshold per
+	// minute.
+	UncorrectableRate = alert.UncorrectableRate
+	// Any downstream channel SNR is below Threshold dB.
+	SNRBelow = alert.SNRBelow
+	// Fewer than Threshold downstream channels are locked.
+	LockedDownstreamBelow = "locked_downstream_below"
+	// Fewer than Threshold upstream channels are locked.
+	LockedUpstreamBelow = "locked_upstream_below"
+)
+
+// Condition is a sign of degradation a reboot may fix.
+type Condition struct {
+	// Name identifies the condition in the audit log, it defaults to Kind.
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold"`
+	// For is how long the condition must hold before rebooting.
+	For alert.Duration `json:"for"`
+}
+
+// Policy configures when to reboot.
+type Policy struct {
+	// Conditions trigger a reboot once any of them held for its For.
+	Conditions []Condition `json:"conditions"`
+	// Cooldown is the least time between reboots.  Zero defaults to an
+	// hour.
+	Cooldown alert.Duration `json:"cooldown"`
+	// MaxPerDay is the most reboots in any 24 hours.  Zero defaults to 3.
+	MaxPerDay int `json:"max_per_day"`
+	// QuietHours are local time ranges such as "22:00-07:00" during which
+	// the modem is never rebooted.
+	QuietHours []string `json:"quiet_hours"`
+	// Timezone is the IANA zone QuietHours are in, empty for the local
+	// zone.
+	Timezone string `json:"timezone"`
+	// AuditLog is a file every action is appended to as a line of JSON.
+	// Reboots in it count towards MaxPerDay after a restart.
+	AuditLog string `json:"audit_log"`
+	// DryRun records reboots without rebooting.  They don't count
+	// towards Cooldown or MaxPerDay.
+	DryRun bool `json:"dry_run"`
+
+	loc   *time.Location
+	quiet []quietHours
+}
+
+// quietHours is a range of minutes since midnight.  It wraps past midnight if
+// end is before start.
+type quietHours struct {
+	start, end int
+}
+
+func (q quietHours) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if q.start <= q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LoadPolicy reads a JSON encoded Policy from path.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+func (p *Policy) validate() error {
+	if len(p.Conditions) == 0 {
+		return fmt.Errorf("no conditions")
+	}
+	names := map[string]bool{}
+	for i := range p.Conditions {
+		c := &p.Conditions[i]
+		switch c.Kind {
+		case UncorrectableRate, SNRBelow, LockedDownstreamBelow, LockedUpstreamBelow:
+		default:
+			return fmt.Errorf("condition %d: unknown kind %q", i, c.Kind)
+		}
+		if c.Name == "" {
+			c.Name = c.Kind
+		}
+		if names[c.Name] {
+			return fmt.Errorf("condition %d: duplicate name %q", i, c.Name)
+		}
+		names[c.Name] = true
+	}
+	if p.Cooldown <= 0 {
+		p.Cooldown = alert.Duration(time.Hour)
+	}
+	if p.MaxPerDay <= 0 {
+		p.MaxPerDay = 3
+	}
+	p.loc = time.Local
+	if p.Timezone != "" {
+		var err error
+		if p.loc, err = time.LoadLocation(p.Timezone); err != nil {
+			return err
+		}
+	}
+	p.quiet = nil
+	for _, r := range p.QuietHours {
+		i := strings.Index(r, "-")
+		if i < 0 {
+			return fmt.Errorf("quiet hours %q aren't HH:MM-HH:MM", r)
+		}
+		start, err := parseClock(r[:i])
+		if err != nil {
+			return fmt.Errorf("quiet hours %q: %v", r, err)
+		}
+		end, err := parseClock(r[i+1:])
+		if err != nil {
+			return fmt.Errorf("quiet hours %q: %v", r, err)
+		}
+		p.quiet = append(p.quiet, quietHours{start, end})
+	}
+	return nil
+}
+
+// Outcomes of an Action.
+const (
+	Rebooted     = "rebooted"
+	RebootFailed = "reboot_failed"
+	DryRun       = "dry_run"
+	// Suppressed reboots.
+	QuietHours = "quiet_hours"
+	Cooldown   = "cooldown"
+	DailyLimit = "daily_limit"
+)
+
+// Action is a reboot taken or suppressed, as written to the audit log.
+type Action struct {
+	Time      time.Time `json:"time"`
+	Model     string    `json:"model"`
+	Condition string    `json:"condition"`
+	Summary   string    `json:"summary"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type conditionState struct {
+	pending time.Time
+	// suppressed is why the last reboot this condition triggered was
+	// suppressed, so each reason is only recorded once.
+	suppressed string
+}
+
+// Engine evaluates a Policy against every poll and reboots the modem when it
+// says to.  Time is taken from the polls, so tests drive it with the times
+// they evaluate at.
+type Engine struct {
+	p        *Policy
+	model    string
+	c        modem.Controller
+	onAction func(Action)
+	// timeout bounds each reboot request.
+	timeout time.Duration
+
+	mu     sync.Mutex
+	states map[string]*conditionState
+	// reboots are the times of reboots in the last day.
+	reboots []time.Time
+	// Previous successful poll, for rates.
+	prevTime          time.Time
+	prevUncorrectable map[modem.Channel]float64
+
+	auditMu sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// New returns an Engine rebooting c, the modem named model, according to p.
+// onAction, if not nil, is called with every Action, from a background
+// goroutine for reboots.  Close waits for reboots in progress.
+func New(p *Policy, model string, c modem.Controller, onAction func(Action)) (*Engine, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		p:        p,
+		model:    model,
+		c:        c,
+		onAction: onAction,
+		timeout:  time.Minute,
+		states:   map[string]*conditionState{},
+	}
+	for _, c := range p.Conditions {
+		e.states[c.Name] = &conditionState{}
+	}
+	if p.AuditLog != "" {
+		if err := e.loadAudit(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// loadAudit restores the times of reboot attempts from the audit log.
+func (e *Engine) loadAudit() error {
+	f, err := os.Open(e.p.AuditLog)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var a Action
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			glog.Warningf("Skipping corrupt audit log line %q: %v", sc.Text(), err)
+			continue
+		}
+		if a.Outcome == Rebooted || a.Outcome == RebootFailed {
+			e.reboots = append(e.reboots, a.Time)
+		}
+	}
+	return sc.Err()
+}
+
+// Close waits for any reboot in progress.
+func (e *Engine) Close() error {
+	e.wg.Wait()
+	return nil
+}
+
+// Evaluate checks the policy against the result of polling the modem at t.
+// err is the error fetching the status, in which case s is ignored.
+func (e *Engine) Evaluate(t time.Time, s *modem.Signal, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var trigger *Condition
+	var summary string
+	for i := range e.p.Conditions {
+		c := &e.p.Conditions[i]
+		active, sum, ok := e.check(c, t, s, err)
+		if !ok {
+			continue
+		}
+		st := e.states[c.Name]
+		if !active {
+			st.pending, st.suppressed = time.Time{}, ""
+			continue
+		}
+		if st.pending.IsZero() {
+			st.pending = t
+		}
+		if trigger == nil && t.Sub(st.pending) >= time.Duration(c.For) {
+			trigger, summary = c, sum
+		}
+	}
+	if err == nil {
+		e.prevTime = t
+		e.prevUncorrectable = map[modem.Channel]float64{}
+		for ch, d := range s.Downstream {
+			e.prevUncorrectable[ch] = d.Uncorrectable
+		}
+	}
+	if trigger == nil {
+		return
+	}
+
+	a := Action{Time: t, Model: e.model, Condition: trigger.Name, Summary: summary}
+	st := e.states[trigger.Name]
+	if a.Outcome = e.suppressed(t); a.Outcome != "" {
+		if st.suppressed != a.Outcome {
+			st.suppressed = a.Outcome
+			e.record(a)
+		}
+		return
+	}
+	// Conditions must hold for their For again before the next reboot.
+	for _, st := range e.states {
+		st.pending, st.suppressed = time.Time{}, ""
+	}
+	if e.p.DryRun {
+		// Dry runs don't reboot, so they don't count towards the
+		// cooldown or MaxPerDay.
+		a.Outcome = DryRun
+		e.record(a)
+		return
+	}
+	e.reboots = append(e.reboots, t)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+		defer cancel()
+		a.Outcome = Rebooted
+		if err := e.c.Reboot(ctx); err != nil {
+			a.Outcome, a.Error = RebootFailed, err.Error()
+		}
+		e.record(a)
+	}()
+}
+
+// suppressed returns why a reboot at t isn't allowed, or "" if it is.  It
+// must be called with e.mu held.
+func (e *Engine) suppressed(t time.Time) string {
+	lt := t.In(e.p.loc)
+	for _, q := range e.p.quiet {
+		if q.contains(lt) {
+			return QuietHours
+		}
+	}
+	var recent []time.Time
+	for _, r := range e.reboots {
+		if t.Sub(r) < 24*time.Hour {
+			recent = append(recent, r)
+		}
+	}
+	e.reboots = recent
+	if n := len(recent); n > 0 && t.Sub(recent[n-1]) < time.Duration(e.p.Cooldown) {
+		return Cooldown
+	}
+	if len(recent) >= e.p.MaxPerDay {
+		return DailyLimit
+	}
+	return ""
+}
+
+// record logs a, appends it to the audit log and passes it to onAction.
+func (e *Engine) record(a Action) {
+	switch a.Outcome {
+	case Rebooted, DryRun:
+		glog.Warningf("Remediation %s %s: %s", a.Outcome, a.Model, a.Summary)
+	case RebootFailed:
+		glog.Errorf("Remediation failed to reboot %s: %s: %s", a.Model, a.Summary, a.Error)
+	default:
+		glog.Infof("Remediation suppressed by %s: %s", a.Outcome, a.Summary)
+	}
+	if e.p.AuditLog != "" {
+		if err := e.audit(a); err != nil {
+			glog.Errorf("Failed to write remediation audit log: %v", err)
+		}
+	}
+	if e.onAction != nil {
+		e.onAction(a)
+	}
+}
+
+func (e *Engine) audit(a Action) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	e.auditMu.Lock()
+	defer e.auditMu.Unlock()
+	f, err := os.OpenFile(e.p.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// check returns whether c holds and a description.  ok is false if the poll
+// doesn't tell either way, such as when the modem couldn't be reached.
+func (e *Engine) check(c *Condition, t time.Time, s *modem.Signal, err error) (active bool, summary string, ok bool) {
+	if err != nil {
+		return false, "", false
+	}
+	switch c.Kind {
+	case SNRBelow:
+		var low []string
+		for _, ch := range s.DownstreamChannels() {
+			if s.Downstream[ch].SNR < c.Threshold {
+				low = append(low, string(ch))
+			}
+		}
+		if len(low) == 0 {
+			return false, "", true
+		}
+		return true, fmt.Sprintf("downstream SNR below %g dB on channel %s", c.Threshold, strings.Join(low, ", ")), true
+
+	case UncorrectableRate:
+		if e.prevUncorrectable == nil || !t.After(e.prevTime) {
+			return false, "", false
+		}
+		var growth float64
+		for ch, d := range s.Downstream {
+			last, ok := e.prevUncorrectable[ch]
+			switch {
+			case !ok:
+			case d.Uncorrectable >= last:
+				growth += d.Uncorrectable - last
+			default:
+				// The counter was reset, likely by a modem reboot.
+				growth += d.Uncorrectable
+			}
+		}
+		rate := growth / t.Sub(e.prevTime).Minutes()
+		if rate <= c.Threshold {
+			return false, "", true
+		}
+		return true, fmt.Sprintf("uncorrectable codewords rising %.1f per minute, above %g", rate, c.Threshold), true
+
+	case LockedDownstreamBelow, LockedUpstreamBelow:
+		dir, locked := "downstream", 0
+		if c.Kind == LockedUpstreamBelow {
+			dir = "upstream"
+			for _, u := range s.Upstream {
+				if u.Status == "" || modem.IsLocked(u.Status) {
+					locked++
+				}
+			}
+		} else {
+			for _, d := range s.Downstream {
+				if d.Status == "" || modem.IsLocked(d.Status) {
+					locked++
+				}
+			}
+		}
+		if float64(locked) >= c.Threshold {
+			return false, "", true
+		}
+		return true, fmt.Sprintf("%d %s channels locked, fewer than %g", locked, dir, c.Threshold), true
+	}
+	return false, "", false
+}
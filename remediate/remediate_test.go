@@ -0,0 +1,325 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remediate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/alert"
+	"github.com/wathiede/surfer/modem"
+)
+
+// fakeModem records reboots, failing them with err if set.
+type fakeModem struct {
+	mu      sync.Mutex
+	err     error
+	reboots int
+}
+
+func (f *fakeModem) Reboot(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reboots++
+	return f.err
+}
+
+func (f *fakeModem) ResetToDefaults(context.Context) error {
+	return errors.New("not allowed")
+}
+
+// poll evaluates at t and waits for any reboot it starts, as if the fake
+// modem rebooted instantly.
+func poll(e *Engine, t time.Time, s *modem.Signal, err error) {
+	e.Evaluate(t, s, err)
+	e.Close()
+}
+
+// clock is a fake clock that polls advance.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) advance(d time.Duration) time.Time {
+	c.t = c.t.Add(d)
+	return c.t
+}
+
+func signal(locked, downstream int, uncorrectable float64) *modem.Signal {
+	s := &modem.Signal{Downstream: map[modem.Channel]*modem.Downstream{}, Upstream: map[modem.Channel]*modem.Upstream{}}
+	for i := 1; i <= downstream; i++ {
+		d := &modem.Downstream{SNR: 40, Status: "Locked", Uncorrectable: uncorrectable}
+		if i > locked {
+			d.Status = "Not Locked"
+		}
+		s.Downstream[modem.Channel(string(rune('0'+i)))] = d
+	}
+	s.Upstream["1"] = &modem.Upstream{Status: "Locked"}
+	return s
+}
+
+// recorder collects actions.
+type recorder struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+func (r *recorder) record(a Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, a)
+}
+
+func (r *recorder) outcomes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var o []string
+	for _, a := range r.actions {
+		o = append(o, a.Outcome)
+	}
+	return o
+}
+
+func newEngine(t *testing.T, p *Policy, m *fakeModem) (*Engine, *recorder) {
+	t.Helper()
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
+	r := &recorder{}
+	e, err := New(p, "SB8200", m, r.record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, r
+}
+
+func lockedPolicy() *Policy {
+	return &Policy{
+		Conditions: []Condition{{Kind: LockedDownstreamBelow, Threshold: 8, For: alert.Duration(15 * time.Minute)}},
+	}
+}
+
+var start = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRebootAfterFor(t *testing.T) {
+	m := &fakeModem{}
+	e, r := newEngine(t, lockedPolicy(), m)
+	c := &clock{start}
+	poll(e, c.t, signal(8, 8, 0), nil)
+	// 6 of 8 channels locked, which has to last 15 minutes.
+	for i := 0; i < 3; i++ {
+		poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	if m.reboots != 0 {
+		t.Fatalf("Rebooted %d times before the condition held 15 minutes", m.reboots)
+	}
+	poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	e.Close()
+	if m.reboots != 1 {
+		t.Fatalf("Rebooted %d times, want 1", m.reboots)
+	}
+	if got, want := r.outcomes(), []string{Rebooted}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes got %q, want %q", got, want)
+	}
+	want := Action{Time: c.t, Model: "SB8200", Condition: LockedDownstreamBelow, Summary: "6 downstream channels locked, fewer than 8", Outcome: Rebooted}
+	if r.actions[0] != want {
+		t.Errorf("Action got %+v, want %+v", r.actions[0], want)
+	}
+
+	// A condition that clears restarts its For.
+	poll(e, c.advance(2*time.Hour), signal(6, 8, 0), nil)
+	poll(e, c.advance(10*time.Minute), signal(8, 8, 0), nil)
+	poll(e, c.advance(10*time.Minute), signal(6, 8, 0), nil)
+	poll(e, c.advance(10*time.Minute), signal(6, 8, 0), nil)
+	e.Close()
+	if m.reboots != 1 {
+		t.Errorf("Rebooted %d times after the condition cleared, want 1", m.reboots)
+	}
+}
+
+func TestCooldownAndDailyLimit(t *testing.T) {
+	m := &fakeModem{}
+	p := lockedPolicy()
+	p.Cooldown = alert.Duration(time.Hour)
+	p.MaxPerDay = 2
+	e, r := newEngine(t, p, m)
+	c := &clock{start}
+	// The condition holds throughout, polled every 5 minutes for a day.
+	poll(e, c.t, signal(6, 8, 0), nil)
+	for i := 0; i < 24*12; i++ {
+		poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	// Reboots at 0:15 and 1:15, and suppressions are recorded once each time
+	// they start.
+	want := []string{Rebooted, Cooldown, Rebooted, Cooldown, DailyLimit}
+	if got := r.outcomes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes got %q, want %q", got, want)
+	}
+	if m.reboots != 2 {
+		t.Errorf("Rebooted %d times, want 2", m.reboots)
+	}
+	// A day after the first reboot, another is allowed.
+	poll(e, c.advance(20*time.Minute), signal(6, 8, 0), nil)
+	e.Close()
+	if m.reboots != 3 {
+		t.Errorf("Rebooted %d times a day later, want 3", m.reboots)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	m := &fakeModem{}
+	p := lockedPolicy()
+	p.QuietHours = []string{"22:00-07:00"}
+	e, r := newEngine(t, p, m)
+	c := &clock{time.Date(2020, 1, 1, 22, 30, 0, 0, time.UTC)}
+	poll(e, c.t, signal(6, 8, 0), nil)
+	for c.t.Before(time.Date(2020, 1, 2, 7, 0, 0, 0, time.UTC)) {
+		poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	if got, want := r.outcomes(), []string{QuietHours, Rebooted}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes got %q, want %q", got, want)
+	}
+	if got, want := r.actions[1].Time, time.Date(2020, 1, 2, 7, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Rebooted at %v, want %v", got, want)
+	}
+}
+
+func TestUncorrectableRateDryRunAndFailure(t *testing.T) {
+	m := &fakeModem{}
+	p := &Policy{
+		Conditions: []Condition{{Name: "Errors", Kind: UncorrectableRate, Threshold: 100, For: alert.Duration(10 * time.Minute)}},
+		DryRun:     true,
+	}
+	e, r := newEngine(t, p, m)
+	c := &clock{start}
+	// 8 channels each growing 50 a minute is 400 per minute.
+	for i := 0; i <= 2; i++ {
+		poll(e, c.advance(5*time.Minute), signal(8, 8, float64(i*250)), nil)
+	}
+	// Unreachable polls don't tell either way.
+	poll(e, c.advance(5*time.Minute), nil, errors.New("unreachable"))
+	poll(e, c.advance(5*time.Minute), signal(8, 8, 1000), nil)
+	e.Close()
+	if got, want := r.outcomes(), []string{DryRun}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes got %q, want %q", got, want)
+	}
+	if m.reboots != 0 {
+		t.Errorf("Dry run rebooted %d times", m.reboots)
+	}
+
+	// Dry runs don't count towards the cooldown or daily limit.
+	p2 := lockedPolicy()
+	p2.DryRun = true
+	p2.MaxPerDay = 1
+	e, r = newEngine(t, p2, m)
+	c2 := &clock{start}
+	poll(e, c2.t, signal(6, 8, 0), nil)
+	for i := 0; i < 7; i++ {
+		poll(e, c2.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	if got, want := r.outcomes(), []string{DryRun, DryRun}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Repeated dry run outcomes got %q, want %q", got, want)
+	}
+
+	m.err = errors.New("refused")
+	p.DryRun = false
+	e, r = newEngine(t, p, m)
+	for i := 0; i <= 3; i++ {
+		poll(e, c.advance(5*time.Minute), signal(8, 8, float64(i*250)), nil)
+	}
+	e.Close()
+	if got, want := r.outcomes(), []string{RebootFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes got %q, want %q", got, want)
+	}
+	if r.actions[0].Error != "refused" {
+		t.Errorf("Error got %q, want refused", r.actions[0].Error)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := lockedPolicy()
+	p.MaxPerDay = 1
+	p.AuditLog = filepath.Join(dir, "audit.jsonl")
+
+	m := &fakeModem{}
+	e, _ := newEngine(t, p, m)
+	c := &clock{start}
+	for i := 0; i <= 3; i++ {
+		poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	if m.reboots != 1 {
+		t.Fatalf("Rebooted %d times, want 1", m.reboots)
+	}
+
+	// After a restart, the reboot in the audit log still counts.
+	e, r := newEngine(t, p, m)
+	for i := 0; i <= 30; i++ {
+		poll(e, c.advance(5*time.Minute), signal(6, 8, 0), nil)
+	}
+	e.Close()
+	if got, want := r.outcomes(), []string{Cooldown, DailyLimit}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes after restart got %q, want %q", got, want)
+	}
+	b, err := ioutil.ReadFile(p.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(splitLines(b)), 3; got != want {
+		t.Errorf("Audit log has %d lines, want %d:\n%s", got, want, b)
+	}
+}
+
+func splitLines(b []byte) []string {
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, p := range []*Policy{
+		{},
+		{Conditions: []Condition{{Kind: "bogus"}}},
+		{Conditions: []Condition{{Kind: SNRBelow}, {Kind: SNRBelow}}},
+		{Conditions: []Condition{{Kind: SNRBelow}}, QuietHours: []string{"22:00"}},
+		{Conditions: []Condition{{Kind: SNRBelow}}, QuietHours: []string{"22:00-25:00"}},
+		{Conditions: []Condition{{Kind: SNRBelow}}, Timezone: "Nowhere/Special"},
+	} {
+		if err := p.validate(); err == nil {
+			t.Errorf("%+v validated, want error", p)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Label is a name and value pair of a TimeSeries.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a value at a time in milliseconds since the epoch.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a series of samples identified by its labels, which include
+// the metric name as __name__.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Series converts metric families to time series with a single sample at
+// timestamp ms.  external labels are added to every series, without
+// overriding labels of the metric itself.  Histograms and summaries are
+// expanded as Prometheus does when scraping them.
+func Series(mfs []*dto.MetricFamily, external map[string]string, ms int64) []TimeSeries {
+	var ts []TimeSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			add := func(suffix string, v float64, extra ...Label) {
+				ls := []Label{{"__name__", name + suffix}}
+				seen := map[string]bool{}
+				for _, lp := range m.GetLabel() {
+					ls = append(ls, Label{lp.GetName(), lp.GetValue()})
+					seen[lp.GetName()] = true
+				}
+				for _, l := range extra {
+					ls = append(ls, l)
+					seen[l.Name] = true
+				}
+				for k, v := range external {
+					if !seen[k] {
+						ls = append(ls, Label{k, v})
+					}
+				}
+				sort.Slice(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+				ts = append(ts, TimeSeries{Labels: ls, Samples: []Sample{{v, ms}}})
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), Label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), Label{"le", formatFloat(b.GetUpperBound())})
+				}
+				add("_bucket", float64(h.GetSampleCount()), Label{"le", "+Inf"})
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return ts
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// Marshal encodes ts as a prometheus.WriteRequest protobuf:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func Marshal(ts []TimeSeries) []byte {
+	var req, series, msg []byte
+	for _, s := range ts {
+		series = series[:0]
+		for _, l := range s.Labels {
+			msg = appendBytes(msg[:0], 1, []byte(l.Name))
+			msg = appendBytes(msg, 2, []byte(l.Value))
+			series = appendBytes(series, 1, msg)
+		}
+		for _, sm := range s.Samples {
+			msg = appendTag(msg[:0], 1, wireFixed64)
+			var f [8]byte
+			binary.LittleEndian.PutUint64(f[:], math.Float64bits(sm.Value))
+			msg = append(msg, f[:]...)
+			msg = appendTag(msg, 2, wireVarint)
+			msg = appendVarint(msg, uint64(sm.Timestamp))
+			series = appendBytes(series, 2, msg)
+		}
+		req = appendBytes(req, 1, series)
+	}
+	return req
+}
@@ -0,0 +1,238 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite sends metrics to a Prometheus remote write endpoint,
+// such as Prometheus itself, Mimir or VictoriaMetrics, queueing them on disk
+// while the endpoint is unreachable.
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures a Writer.
+type Options struct {
+	// URL is the remote write endpoint, e.g.
+	// http://prometheus:9090/api/v1/write.
+	URL string
+	// Username and Password set basic auth, BearerToken sets a bearer token.
+	Username, Password string
+	BearerToken        string
+	// ExternalLabels are added to every series, typically job and instance.
+	ExternalLabels map[string]string
+
+	// WALDir, if set, is where requests are queued until sent, so they
+	// survive both outages and restarts.  Otherwise up to MemoryQueue
+	// requests are queued in memory.
+	WALDir string
+	// MaxWALSize is the most bytes kept in WALDir, the oldest requests are
+	// dropped past it.  Zero defaults to 256 MiB.
+	MaxWALSize int64
+	// MemoryQueue is the number of requests queued without a WALDir.  Zero
+	// defaults to 1000.
+	MemoryQueue int
+
+	// Timeout bounds each request.  Zero defaults to 30 seconds.
+	Timeout time.Duration
+	// MaxBackoff bounds the wait between retries.  Zero defaults to 5
+	// minutes.
+	MaxBackoff time.Duration
+}
+
+// DefaultOptions holds the defaults used for zero Options fields.
+var DefaultOptions = Options{
+	MaxWALSize:  256 << 20,
+	MemoryQueue: 1000,
+	Timeout:     30 * time.Second,
+	MaxBackoff:  5 * time.Minute,
+}
+
+// Writer sends every appended sample in order, retrying with exponential
+// backoff while the endpoint is unreachable.
+type Writer struct {
+	opts    Options
+	client  *http.Client
+	q       queue
+	backoff time.Duration
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+// New returns a Writer sending to opts.URL.  Requests left in opts.WALDir by
+// a previous run are sent first.
+func New(opts Options) (*Writer, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("remotewrite: no URL")
+	}
+	if opts.MaxWALSize <= 0 {
+		opts.MaxWALSize = DefaultOptions.MaxWALSize
+	}
+	if opts.MemoryQueue <= 0 {
+		opts.MemoryQueue = DefaultOptions.MemoryQueue
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	var q queue = &memQueue{max: opts.MemoryQueue}
+	if opts.WALDir != "" {
+		segment := opts.MaxWALSize / 16
+		if segment > 8<<20 {
+			segment = 8 << 20
+		}
+		var err error
+		if q, err = openWAL(opts.WALDir, segment, opts.MaxWALSize); err != nil {
+			return nil, err
+		}
+	}
+	w := &Writer{
+		opts:    opts,
+		client:  &http.Client{Timeout: opts.Timeout},
+		q:       q,
+		backoff: time.Second,
+		wake:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Append queues the current values of the metrics in g, timestamped t.
+func (w *Writer) Append(t time.Time, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	ts := Series(mfs, w.opts.ExternalLabels, t.UnixNano()/int64(time.Millisecond))
+	if len(ts) == 0 {
+		return nil
+	}
+	if err := w.q.append(snappy.Encode(nil, Marshal(ts))); err != nil {
+		return err
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops sending.  Anything unsent stays in the WAL for the next run.
+func (w *Writer) Close() error {
+	close(w.quit)
+	<-w.done
+	return w.q.Close()
+}
+
+func (w *Writer) loop() {
+	defer close(w.done)
+	var backoff time.Duration
+	for {
+		rec, err := w.q.peek()
+		if err != nil {
+			glog.Errorf("Failed to read remote write queue: %v", err)
+		}
+		if rec == nil {
+			select {
+			case <-w.quit:
+				return
+			case <-w.wake:
+			}
+			continue
+		}
+		if err := w.send(rec); err != nil {
+			if isRetryable(err) {
+				if backoff == 0 {
+					backoff = w.backoff
+				}
+				glog.Errorf("Failed to remote write to %s, retrying in %s: %v", w.opts.URL, backoff, err)
+				select {
+				case <-w.quit:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > w.opts.MaxBackoff {
+					backoff = w.opts.MaxBackoff
+				}
+				continue
+			}
+			glog.Errorf("Dropping remote write request rejected by %s: %v", w.opts.URL, err)
+		}
+		backoff = 0
+		if err := w.q.pop(); err != nil {
+			glog.Errorf("Failed to update remote write queue: %v", err)
+		}
+	}
+}
+
+// httpError is a non-2xx response from the endpoint.
+type httpError struct {
+	status int
+	body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.status, e.body)
+}
+
+// isRetryable returns false for requests the endpoint rejected and will
+// reject again.  As the remote write spec asks, only 5xx and 429 responses
+// are retried.
+func isRetryable(err error) bool {
+	if he, ok := err.(*httpError); ok {
+		return he.status >= 500 || he.status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (w *Writer) send(rec []byte) error {
+	req, err := http.NewRequest("POST", w.opts.URL, bytes.NewReader(rec))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "surfer")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case w.opts.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.opts.BearerToken)
+	case w.opts.Username != "":
+		req.SetBasicAuth(w.opts.Username, w.opts.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &httpError{status: resp.StatusCode, body: string(bytes.TrimSpace(body))}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The prometheus.WriteRequest messages, decoded by golang/protobuf to check
+// Marshal independently.
+type pbWriteRequest struct {
+	Timeseries []*pbTimeSeries `protobuf:"bytes,1,rep,name=timeseries"`
+}
+
+func (m *pbWriteRequest) Reset()         { *m = pbWriteRequest{} }
+func (m *pbWriteRequest) String() string { return proto.CompactTextString(m) }
+func (*pbWriteRequest) ProtoMessage()    {}
+
+type pbTimeSeries struct {
+	Labels  []*pbLabel  `protobuf:"bytes,1,rep,name=labels"`
+	Samples []*pbSample `protobuf:"bytes,2,rep,name=samples"`
+}
+
+func (m *pbTimeSeries) Reset()         { *m = pbTimeSeries{} }
+func (m *pbTimeSeries) String() string { return proto.CompactTextString(m) }
+func (*pbTimeSeries) ProtoMessage()    {}
+
+type pbLabel struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *pbLabel) Reset()         { *m = pbLabel{} }
+func (m *pbLabel) String() string { return proto.CompactTextString(m) }
+func (*pbLabel) ProtoMessage()    {}
+
+type pbSample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *pbSample) Reset()         { *m = pbSample{} }
+func (m *pbSample) String() string { return proto.CompactTextString(m) }
+func (*pbSample) ProtoMessage()    {}
+
+func decode(t *testing.T, b []byte) []TimeSeries {
+	t.Helper()
+	var req pbWriteRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	var ts []TimeSeries
+	for _, s := range req.Timeseries {
+		var out TimeSeries
+		for _, l := range s.Labels {
+			out.Labels = append(out.Labels, Label{l.Name, l.Value})
+		}
+		for _, sm := range s.Samples {
+			out.Samples = append(out.Samples, Sample{sm.Value, sm.Timestamp})
+		}
+		ts = append(ts, out)
+	}
+	return ts
+}
+
+func testRegistry() (*prometheus.Registry, *prometheus.GaugeVec) {
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "downstream_snr", Help: "test"}, []string{"channel"})
+	g.WithLabelValues("1").Set(40)
+	r.MustRegister(g)
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "fetch_errors", Help: "test"})
+	c.Add(2)
+	r.MustRegister(c)
+	return r, g
+}
+
+func TestMarshal(t *testing.T) {
+	r, _ := testRegistry()
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := Series(mfs, map[string]string{"job": "surfer", "channel": "ignored"}, 1577836800000)
+	want := []TimeSeries{
+		{
+			Labels:  []Label{{"__name__", "downstream_snr"}, {"channel", "1"}, {"job", "surfer"}},
+			Samples: []Sample{{40, 1577836800000}},
+		},
+		{
+			// channel is added from the external labels.
+			Labels:  []Label{{"__name__", "fetch_errors"}, {"channel", "ignored"}, {"job", "surfer"}},
+			Samples: []Sample{{2, 1577836800000}},
+		},
+	}
+	if !reflect.DeepEqual(ts, want) {
+		t.Errorf("Series got %+v, want %+v", ts, want)
+	}
+	if got := decode(t, Marshal(ts)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoded got %+v, want %+v", got, want)
+	}
+}
+
+// receiver is a stand-in remote write endpoint.
+type receiver struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	reqs   []*http.Request
+	series [][]TimeSeries
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rc := &receiver{status: http.StatusNoContent}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.reqs = append(rc.reqs, r)
+		if rc.status/100 == 2 {
+			d, err := snappy.Decode(nil, b)
+			if err != nil {
+				t.Errorf("Snappy decode: %v", err)
+			}
+			rc.series = append(rc.series, decode(t, d))
+		}
+		w.WriteHeader(rc.status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) setStatus(code int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.status = code
+}
+
+// wait returns the series received once there are n requests of them.
+func (rc *receiver) wait(t *testing.T, n int) [][]TimeSeries {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		rc.mu.Lock()
+		got := append([][]TimeSeries(nil), rc.series...)
+		rc.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d requests", n)
+	return nil
+}
+
+func newTestWriter(t *testing.T, opts Options) *Writer {
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.backoff = time.Millisecond
+	return w
+}
+
+func TestWriter(t *testing.T) {
+	rc := newReceiver(t)
+	reg, _ := testRegistry()
+	w := newTestWriter(t, Options{URL: rc.URL, BearerToken: "secret", ExternalLabels: map[string]string{"instance": "cabin"}})
+	defer w.Close()
+	now := time.Unix(1577836800, 0)
+	if err := w.Append(now, reg); err != nil {
+		t.Fatal(err)
+	}
+	got := rc.wait(t, 1)
+	if len(got[0]) != 2 || got[0][0].Samples[0].Timestamp != 1577836800000 {
+		t.Errorf("Got %+v", got[0])
+	}
+	r := rc.reqs[0]
+	for h, want := range map[string]string{
+		"Authorization":                     "Bearer secret",
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := r.Header.Get(h); got != want {
+			t.Errorf("%s got %q, want %q", h, got, want)
+		}
+	}
+}
+
+func TestWriterBasicAuth(t *testing.T) {
+	rc := newReceiver(t)
+	reg, _ := testRegistry()
+	w := newTestWriter(t, Options{URL: rc.URL, Username: "user", Password: "pass"})
+	defer w.Close()
+	w.Append(time.Now(), reg)
+	rc.wait(t, 1)
+	if u, p, ok := rc.reqs[0].BasicAuth(); !ok || u != "user" || p != "pass" {
+		t.Errorf("Basic auth got %q %q %v", u, p, ok)
+	}
+}
+
+func TestWriterOutage(t *testing.T) {
+	rc := newReceiver(t)
+	rc.setStatus(http.StatusServiceUnavailable)
+	reg, g := testRegistry()
+	dir := t.TempDir()
+	w := newTestWriter(t, Options{URL: rc.URL, WALDir: dir, MaxBackoff: 5 * time.Millisecond})
+	start := time.Unix(1577836800, 0)
+	for i := 0; i < 3; i++ {
+		g.WithLabelValues("1").Set(float64(30 + i))
+		if err := w.Append(start.Add(time.Duration(i)*time.Minute), reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The WAN is still down when surfer restarts.
+	time.Sleep(20 * time.Millisecond)
+	w.Close()
+	w = newTestWriter(t, Options{URL: rc.URL, WALDir: dir, MaxBackoff: 5 * time.Millisecond})
+	defer w.Close()
+	g.WithLabelValues("1").Set(33)
+	w.Append(start.Add(3*time.Minute), reg)
+
+	// Once it recovers everything is sent, in order.
+	rc.setStatus(http.StatusOK)
+	got := rc.wait(t, 4)
+	if len(got) != 4 {
+		t.Fatalf("Got %d requests, want 4", len(got))
+	}
+	for i, ts := range got {
+		if v := ts[0].Samples[0].Value; v != float64(30+i) {
+			t.Errorf("Request %d has value %v, want %v", i, v, 30+i)
+		}
+	}
+}
+
+func TestWriterBadRequestDropped(t *testing.T) {
+	rc := newReceiver(t)
+	rc.setStatus(http.StatusBadRequest)
+	reg, _ := testRegistry()
+	w := newTestWriter(t, Options{URL: rc.URL, WALDir: t.TempDir()})
+	defer w.Close()
+	w.Append(time.Now(), reg)
+	w.Append(time.Now(), reg)
+	for i := 0; i < 200; i++ {
+		rc.mu.Lock()
+		n := len(rc.reqs)
+		rc.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Each rejected request is sent once and dropped.
+	time.Sleep(20 * time.Millisecond)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.reqs) != 2 {
+		t.Errorf("Got %d requests, want 2", len(rc.reqs))
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// queue holds requests waiting to be sent, in order.
+type queue interface {
+	// append adds a request to the end of the queue.
+	append(rec []byte) error
+	// peek returns the request at the head of the queue, or nil if the
+	// queue is empty.
+	peek() ([]byte, error)
+	// pop removes the request returned by the last peek.
+	pop() error
+	Close() error
+}
+
+// memQueue is a queue of up to max requests kept in memory, dropping the
+// oldest when full.
+type memQueue struct {
+	mu   sync.Mutex
+	max  int
+	recs [][]byte
+}
+
+func (q *memQueue) append(rec []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.recs) >= q.max {
+		q.recs = q.recs[1:]
+	}
+	q.recs = append(q.recs, rec)
+	return nil
+}
+
+func (q *memQueue) peek() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.recs) == 0 {
+		return nil, nil
+	}
+	return q.recs[0], nil
+}
+
+func (q *memQueue) pop() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.recs) > 0 {
+		q.recs = q.recs[1:]
+	}
+	return nil
+}
+
+func (q *memQueue) Close() error { return nil }
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// position is a record's location in the WAL.
+type position struct {
+	seg    int
+	offset int64
+}
+
+// wal is an on-disk queue.  Requests are appended as records to numbered
+// segment files, each record a 4 byte length and 4 byte CRC-32C of the
+// request followed by the request.  The position of the next unsent record is
+// kept in a separate file, and segments before it are deleted.  A torn or
+// corrupt record, such as one left by a crash, ends its segment.
+type wal struct {
+	dir        string
+	maxSegment int64
+	maxSize    int64
+
+	mu sync.Mutex
+	// w is the segment being appended to.
+	w     *os.File
+	wseg  int
+	wsize int64
+	// read is the position of the head of the queue, next is the position
+	// after the record last peeked.
+	read, next position
+}
+
+const positionFile = "position"
+
+func segmentName(seg int) string {
+	return fmt.Sprintf("%08d.wal", seg)
+}
+
+// openWAL opens the WAL in dir, creating it if needed.  Segments are started
+// after maxSegment bytes and the oldest are dropped once all segments total
+// more than maxSize bytes.
+func openWAL(dir string, maxSegment, maxSize int64) (*wal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &wal{dir: dir, maxSegment: maxSegment, maxSize: maxSize}
+	segs, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, positionFile)); err == nil {
+		if _, err := fmt.Sscanf(string(b), "%d %d", &w.read.seg, &w.read.offset); err != nil {
+			glog.Errorf("Ignoring corrupt WAL position %q: %v", b, err)
+			w.read = position{}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(segs) > 0 && w.read.seg < segs[0] {
+		w.read = position{seg: segs[0]}
+	}
+	// Always append to a new segment, so a torn record at the end of the last
+	// one stays at the end of its segment.
+	w.wseg = 1
+	if len(segs) > 0 {
+		w.wseg = segs[len(segs)-1] + 1
+	}
+	if len(segs) == 0 || w.read.seg > w.wseg {
+		w.read = position{seg: w.wseg}
+	}
+	if err := w.create(); err != nil {
+		return nil, err
+	}
+	w.next = w.read
+	return w, nil
+}
+
+// segments returns the segment numbers in dir in order.
+func (w *wal) segments() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(w.dir, "*.wal"))
+	if err != nil {
+		return nil, err
+	}
+	var segs []int
+	for _, n := range names {
+		seg, err := strconv.Atoi(filepath.Base(n[:len(n)-len(".wal")]))
+		if err != nil {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	sort.Ints(segs)
+	return segs, nil
+}
+
+func (w *wal) create() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(w.wseg)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.w, w.wsize = f, fi.Size()
+	return nil
+}
+
+// append adds rec to the end of the WAL.  Records bigger than maxSegment get
+// a segment of their own, and those the WAL could never hold are dropped.
+func (w *wal) append(rec []byte) error {
+	if int64(len(rec))+8 > w.maxSize {
+		glog.Errorf("Dropping %d byte remote write request, over the %d byte WAL limit", len(rec), w.maxSize)
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wsize > 0 && w.wsize+int64(len(rec))+8 > w.maxSegment {
+		if err := w.w.Close(); err != nil {
+			return err
+		}
+		w.wseg++
+		if err := w.create(); err != nil {
+			return err
+		}
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint32(h[:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(h[4:], crc32.Checksum(rec, castagnoli))
+	if _, err := w.w.Write(append(h[:], rec...)); err != nil {
+		return err
+	}
+	w.wsize += int64(len(rec)) + 8
+	if err := w.w.Sync(); err != nil {
+		return err
+	}
+	return w.truncate()
+}
+
+// truncate drops the oldest segments while the WAL is over maxSize.  It must
+// be called with w.mu held.
+func (w *wal) truncate() error {
+	segs, err := w.segments()
+	if err != nil {
+		return err
+	}
+	var sizes []int64
+	var total int64
+	for _, seg := range segs {
+		fi, err := os.Stat(filepath.Join(w.dir, segmentName(seg)))
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, fi.Size())
+		total += fi.Size()
+	}
+	for i := 0; total > w.maxSize && segs[i] != w.wseg; i++ {
+		glog.Warningf("Remote write WAL over %d bytes, dropping unsent segment %d", w.maxSize, segs[i])
+		if err := os.Remove(filepath.Join(w.dir, segmentName(segs[i]))); err != nil {
+			return err
+		}
+		total -= sizes[i]
+		if w.read.seg <= segs[i] {
+			w.read = position{seg: segs[i+1]}
+			w.next = w.read
+			if err := w.savePosition(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var errTorn = errors.New("torn or corrupt record")
+
+// readAt reads the record at p.
+func (w *wal) readAt(p position) ([]byte, error) {
+	f, err := os.Open(filepath.Join(w.dir, segmentName(p.seg)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(p.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var h [8]byte
+	if _, err := io.ReadFull(f, h[:]); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errTorn
+	}
+	n := binary.BigEndian.Uint32(h[:4])
+	// Records can be bigger than maxSegment, but never than maxSize.
+	if int64(n) > w.maxSize {
+		return nil, errTorn
+	}
+	rec := make([]byte, n)
+	if _, err := io.ReadFull(f, rec); err != nil {
+		return nil, errTorn
+	}
+	if crc32.Checksum(rec, castagnoli) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, errTorn
+	}
+	return rec, nil
+}
+
+func (w *wal) peek() ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		rec, err := w.readAt(w.read)
+		if err == nil {
+			w.next = position{w.read.seg, w.read.offset + int64(len(rec)) + 8}
+			return rec, nil
+		}
+		if w.read.seg == w.wseg {
+			// The segment being written is never torn.
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if err == errTorn {
+			glog.Warningf("Skipping torn or corrupt record at the end of WAL segment %d", w.read.seg)
+		} else if err != io.EOF && !os.IsNotExist(err) {
+			return nil, err
+		}
+		// Move on to the next segment.
+		if err := w.advance(position{seg: w.read.seg + 1}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (w *wal) pop() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.advance(w.next)
+}
+
+// advance moves the head of the queue to p, deleting any segments left
+// behind.  It must be called with w.mu held.
+func (w *wal) advance(p position) error {
+	for seg := w.read.seg; seg < p.seg; seg++ {
+		if err := os.Remove(filepath.Join(w.dir, segmentName(seg))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.read, w.next = p, p
+	return w.savePosition()
+}
+
+// savePosition must be called with w.mu held.
+func (w *wal) savePosition() error {
+	tmp := filepath.Join(w.dir, positionFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", w.read.seg, w.read.offset)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.dir, positionFile))
+}
+
+func (w *wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Close()
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// drain pops every record in q.
+func drain(t *testing.T, q queue) []string {
+	t.Helper()
+	var got []string
+	for {
+		rec, err := q.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec == nil {
+			return got
+		}
+		got = append(got, string(rec))
+		if err := q.pop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func appendN(t *testing.T, q queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := q.append([]byte(fmt.Sprintf("record %02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWALReopen(t *testing.T) {
+	dir := t.TempDir()
+	// Segments hold 3 of the 17 byte records.
+	w, err := openWAL(dir, 60, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, w, 0, 10)
+	// Send 4, then "crash" with one peeked but not popped.
+	for i := 0; i < 4; i++ {
+		w.peek()
+		w.pop()
+	}
+	if rec, _ := w.peek(); string(rec) != "record 04" {
+		t.Errorf("peek got %q, want record 04", rec)
+	}
+	w.Close()
+
+	w, err = openWAL(dir, 60, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	appendN(t, w, 10, 12)
+	got := drain(t, w)
+	if len(got) != 8 || got[0] != "record 04" || got[7] != "record 11" {
+		t.Errorf("After reopening got %q, want records 04 to 11", got)
+	}
+	// Only the segment being written is left.
+	if segs, _ := w.segments(); len(segs) != 1 || segs[0] != w.wseg {
+		t.Errorf("Got segments %v, want only %d", segs, w.wseg)
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, w, 0, 2)
+	w.Close()
+	// A crash in the middle of writing a record.
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(1)), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 20, 1, 2})
+	f.Close()
+
+	w, err = openWAL(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	appendN(t, w, 2, 3)
+	got := drain(t, w)
+	if want := []string{"record 00", "record 01", "record 02"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestWALMaxSize(t *testing.T) {
+	// Segments hold 2 records, the WAL 8 records.
+	w, err := openWAL(t.TempDir(), 50, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	appendN(t, w, 0, 10)
+	got := drain(t, w)
+	if len(got) != 8 || got[0] != "record 02" {
+		t.Errorf("Got %q, want the newest 8 records", got)
+	}
+}
+
+func TestWALOversizedRecord(t *testing.T) {
+	w, err := openWAL(t.TempDir(), 60, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	big := strings.Repeat("b", 100)
+	huge := strings.Repeat("h", 300)
+	appendN(t, w, 0, 1)
+	for _, rec := range []string{big, huge} {
+		if err := w.append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendN(t, w, 1, 2)
+	// A record bigger than a segment is sent, one bigger than the whole WAL
+	// is dropped, and neither holds up the records after them.
+	got := drain(t, w)
+	want := []string{"record 00", big, "record 01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestMemQueue(t *testing.T) {
+	q := &memQueue{max: 3}
+	appendN(t, q, 0, 5)
+	got := drain(t, q)
+	if want := []string{"record 02", "record 03", "record 04"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay implements a modem.Modem that plays back a series of
+// recorded status pages, so dashboards and alerts can be tested against a
+// realistic history instead of a single static page.
+package replay
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+)
+
+// dirTimeFormat matches the scrape directories written by package record,
+// which are named for the time and kind of the scrape.
+const dirTimeFormat = "20060102T150405.000Z"
+
+// parseScrapeDir returns the time and kind of a scrape directory written by
+// package record.  ok is false for other directories.
+func parseScrapeDir(name string) (t time.Time, kind string, ok bool) {
+	if len(name) < len(dirTimeFormat) {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(dirTimeFormat, name[:len(dirTimeFormat)])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, strings.TrimPrefix(name[len(dirTimeFormat):], "-"), true
+}
+
+type snapshot struct {
+	time time.Time
+	path string
+	m    modem.Modem
+}
+
+// Replay is a modem.Modem that returns the status of each snapshot in turn.
+type Replay struct {
+	name      string
+	snapshots []snapshot
+	speed     float64
+	tmpDir    string
+	now       func() time.Time
+
+	mu    sync.Mutex
+	next  int
+	start time.Time
+}
+
+// Replayable reports whether path is a directory or an archive that should
+// be replayed rather than loaded as a single status page.
+func Replayable(path string) bool {
+	if path == "" {
+		return false
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return true
+	}
+	return archive(path) != ""
+}
+
+func archive(path string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// New returns a Replay of every status page found in path, which is either a
+// directory or a .tar, .tar.gz, .tgz or .zip archive.  Files are recognized
+// by the registered modem probers, so a -record_dir can be replayed as is.
+//
+// Snapshots are ordered by the time they were captured, taken from the scrape
+// directory name when recorded by surfer, or the file's modification time
+// otherwise.  If speed is greater than zero, snapshots are replayed in real
+// time scaled by speed, looping back to the first once the last is reached.
+// If speed is zero, each call to Status advances to the next snapshot.
+func New(ctx context.Context, path string, speed float64) (*Replay, error) {
+	r := &Replay{speed: speed, now: time.Now}
+	dir := path
+	if archive(path) != "" {
+		var err error
+		if r.tmpDir, err = ioutil.TempDir("", "surfer-replay"); err != nil {
+			return nil, err
+		}
+		if err := extract(path, r.tmpDir); err != nil {
+			r.Close()
+			return nil, err
+		}
+		dir = r.tmpDir
+	}
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		switch filepath.Ext(p) {
+		case ".request", ".response", ".error":
+			// Full exchanges written by package record, the body is also
+			// saved on its own.
+			return nil
+		}
+		if _, kind, ok := parseScrapeDir(filepath.Base(filepath.Dir(p))); ok && kind != "" && kind != "status" {
+			// Info, event log and other pages recorded alongside.
+			return nil
+		}
+		m := modem.New(ctx, p)
+		if m == nil {
+			glog.V(2).Infof("Skipping %q, not a recognized status page", p)
+			return nil
+		}
+		if r.name == "" {
+			r.name = m.Name()
+		}
+		if m.Name() != r.name {
+			glog.Warningf("Skipping %q, %s status page in a %s replay", p, m.Name(), r.name)
+			return nil
+		}
+		r.snapshots = append(r.snapshots, snapshot{time: captureTime(p, fi), path: p, m: m})
+		return nil
+	})
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if len(r.snapshots) == 0 {
+		r.Close()
+		return nil, fmt.Errorf("no status pages found in %q", path)
+	}
+	sort.SliceStable(r.snapshots, func(i, j int) bool {
+		return r.snapshots[i].time.Before(r.snapshots[j].time)
+	})
+	glog.Infof("Replaying %d %s snapshots from %q", len(r.snapshots), r.name, path)
+	return r, nil
+}
+
+func captureTime(p string, fi os.FileInfo) time.Time {
+	if t, _, ok := parseScrapeDir(filepath.Base(filepath.Dir(p))); ok {
+		return t
+	}
+	return fi.ModTime()
+}
+
+// Name returns the name of the modem the snapshots were captured from.
+func (r *Replay) Name() string { return r.name }
+
+// Status returns the signal data of the current snapshot.
+func (r *Replay) Status(ctx context.Context) (*modem.Signal, error) {
+	s := r.current()
+	glog.V(2).Infof("Replaying %q", s.path)
+	return s.m.Status(ctx)
+}
+
+func (r *Replay) current() snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.speed <= 0 {
+		s := r.snapshots[r.next]
+		r.next = (r.next + 1) % len(r.snapshots)
+		return s
+	}
+
+	now := r.now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	first := r.snapshots[0].time
+	last := r.snapshots[len(r.snapshots)-1].time
+	elapsed := time.Duration(float64(now.Sub(r.start)) * r.speed)
+	// Leave a gap after the last snapshot as long as the average interval so
+	// it is shown for as long as the others before looping.
+	period := last.Sub(first)
+	if n := len(r.snapshots); n > 1 {
+		period += period / time.Duration(n-1)
+	}
+	if period > 0 {
+		elapsed %= period
+	}
+	at := first.Add(elapsed)
+	i := sort.Search(len(r.snapshots), func(i int) bool {
+		return r.snapshots[i].time.After(at)
+	})
+	return r.snapshots[i-1]
+}
+
+// Close removes any files extracted from an archive.
+func (r *Replay) Close() error {
+	if r.tmpDir == "" {
+		return nil
+	}
+	return os.RemoveAll(r.tmpDir)
+}
+
+func extract(path, dir string) error {
+	if archive(path) == ".zip" {
+		return extractZip(path, dir)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rd io.Reader = f
+	if archive(path) != ".tar" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	tr := tar.NewReader(rd)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := writeFile(dir, h.Name, h.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(path, dir string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(dir, zf.Name, zf.Modified, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile creates name under dir with the contents of r, keeping the
+// archived modification time so snapshots sort correctly.
+func writeFile(dir, name string, mtime time.Time, r io.Reader) error {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(p, filepath.Clean(dir)+string(filepath.Separator)) {
+		return fmt.Errorf("archive entry %q escapes extraction directory", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(p, mtime, mtime)
+}
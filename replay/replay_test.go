@@ -0,0 +1,176 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/wathiede/surfer/modem/sb8200"
+)
+
+// snapshots writes three scrapes a minute apart in the layout of
+// -record_dir.  The downstream power of channel 1 is set to 1, 2 and 3 dBmV.
+func snapshots(t *testing.T) string {
+	b, err := ioutil.ReadFile("../modem/sb8200/testdata/SB8200.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, power := range []string{"1.0", "2.0", "3.0"} {
+		d := filepath.Join(dir, start.Add(time.Duration(i)*time.Minute).Format(dirTimeFormat)+"-status")
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		page := bytes.Replace(b, []byte("<td>2.4 dBmV</td>"), []byte("<td>"+power+" dBmV</td>"), 1)
+		// Pages of other kinds of scrape aren't replayed, even if they
+		// look like a status page.
+		info := filepath.Join(dir, start.Add(time.Duration(i)*time.Minute).Format(dirTimeFormat)+"-info")
+		if err := os.Mkdir(info, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(info, "01-cmswinfo.html"), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "01-cmconnectionstatus.html"), page, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// The full response dump must not be replayed as a second snapshot.
+		resp := append([]byte("HTTP/1.1 200 OK\r\n\r\n"), page...)
+		if err := ioutil.WriteFile(filepath.Join(d, "01-cmconnectionstatus.response"), resp, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func power(t *testing.T, r *Replay) float64 {
+	s, err := r.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	return s.Downstream["1"].PowerLevel
+}
+
+func TestStep(t *testing.T) {
+	dir := snapshots(t)
+	defer os.RemoveAll(dir)
+
+	r, err := New(context.Background(), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Name(), "SB8200"; got != want {
+		t.Errorf("Name got %q, want %q", got, want)
+	}
+	for i, want := range []float64{1, 2, 3, 1} {
+		if got := power(t, r); got != want {
+			t.Errorf("Status %d got power %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestRealTime(t *testing.T) {
+	dir := snapshots(t)
+	defer os.RemoveAll(dir)
+
+	r, err := New(context.Background(), dir, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	for _, tc := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		// At 60x, snapshots a minute apart are replayed a second apart.
+		{0, 1},
+		{500 * time.Millisecond, 1},
+		{time.Second, 2},
+		{2500 * time.Millisecond, 3},
+		// Loops after the last snapshot has been shown for a second.
+		{3 * time.Second, 1},
+		{4 * time.Second, 2},
+	} {
+		now = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).Add(tc.elapsed)
+		if got := power(t, r); got != tc.want {
+			t.Errorf("After %v got power %v, want %v", tc.elapsed, got, tc.want)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir := snapshots(t)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		h := &tar.Header{Name: filepath.ToSlash(rel), Mode: 0644, Size: int64(len(b)), ModTime: fi.ModTime(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+	p := filepath.Join(dir, "capture.tar.gz")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !Replayable(p) {
+		t.Fatalf("Replayable(%q) = false", p)
+	}
+
+	r, err := New(context.Background(), p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{1, 2, 3} {
+		if got := power(t, r); got != want {
+			t.Errorf("Status %d got power %v, want %v", i, got, want)
+		}
+	}
+	tmp := r.tmpDir
+	r.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Close left %q behind: %v", tmp, err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wathiede/surfer/influx"
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// statusCmd implements `surfer status`, which fetches the modem status once
+// and prints it.  It returns the process exit code.
+func statusCmd(args []string) int {
+	return runStatus(args, os.Stdout, os.Stderr)
+}
+
+// runStatus is statusCmd writing to stdout and stderr.
+func runStatus(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "table", "output format, one of table, json, csv or influx (line protocol, for a Telegraf exec input)")
+	fs.StringVar(fakeDataPath, "fake", *fakeDataPath, "path to fake HTML data instead of fetching over HTTP")
+	fs.StringVar(model, "model", *model, "cable modem model to use instead of autodetecting it")
+	fs.DurationVar(timeout, "timeout", *timeout, "timeout for the HTTP GET to cable modem")
+	fs.StringVar(qualityProfile, "quality_profile", *qualityProfile, "signal quality profile used to flag out of spec values")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: surfer status [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Fetches the modem status once and prints every channel.  Values rated bad by\n")
+		fmt.Fprintf(fs.Output(), "-quality_profile are marked with a *.  Exits non-zero if the status can't be\n")
+		fmt.Fprintf(fs.Output(), "fetched.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	var write func(io.Writer, *statusReport) error
+	switch *format {
+	case "influx":
+		// Line protocol is written from the modem.Signal, not a statusReport.
+	case "table":
+		write = writeTable
+	case "json":
+		write = writeJSON
+	case "csv":
+		write = writeCSV
+	default:
+		fmt.Fprintf(stderr, "status: unknown -format %q\n", *format)
+		return 2
+	}
+
+	profile, err := quality.Load(*qualityProfile)
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	m, err := newModem(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	if m == nil {
+		fmt.Fprintln(stderr, "status: no modem found")
+		return 1
+	}
+	defer closeModem(m)
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	s, err := m.Status(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "status: failed to fetch %s status: %v\n", m.Name(), err)
+		return 1
+	}
+	if *format == "influx" {
+		err = influx.Write(stdout, time.Now(), m.Name(), s)
+	} else {
+		err = write(stdout, newStatusReport(m.Name(), s, profile.Evaluate(s)))
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+type downstreamReport struct {
+	Channel       modem.Channel `json:"channel"`
+	Frequency     string        `json:"frequency"`
+	Modulation    string        `json:"modulation"`
+	PowerLevel    float64       `json:"power_dbmv"`
+	SNR           float64       `json:"snr_db"`
+	Unerrored     float64       `json:"unerrored"`
+	Correctable   float64       `json:"correctable"`
+	Uncorrectable float64       `json:"uncorrectable"`
+	Quality       quality.Level `json:"quality"`
+	OutOfSpec     []string      `json:"out_of_spec,omitempty"`
+}
+
+type upstreamReport struct {
+	Channel    modem.Channel `json:"channel"`
+	Frequency  string        `json:"frequency"`
+	Modulation string        `json:"modulation"`
+	Status     string        `json:"status"`
+	SymbolRate float64       `json:"symbol_rate"`
+	PowerLevel float64       `json:"power_dbmv"`
+	Quality    quality.Level `json:"quality"`
+	OutOfSpec  []string      `json:"out_of_spec,omitempty"`
+}
+
+// statusReport is a modem.Signal with channels in display order and out of
+// spec values noted.
+type statusReport struct {
+	Model       string             `json:"model"`
+	Profile     string             `json:"quality_profile"`
+	HealthScore float64            `json:"health_score"`
+	Downstream  []downstreamReport `json:"downstream"`
+	Upstream    []upstreamReport   `json:"upstream"`
+}
+
+// outOfSpec returns the fields of res rated bad, in a stable order.
+func outOfSpec(res quality.Result) []string {
+	var fields []string
+	for _, f := range []string{quality.Power, quality.SNR, quality.CodewordErrorRatio} {
+		if l, ok := res.Fields[f]; ok && l == quality.Bad {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func newStatusReport(name string, s *modem.Signal, q *quality.Report) *statusReport {
+	r := &statusReport{Model: name, Profile: q.Profile, HealthScore: q.Score}
+	for _, ch := range s.DownstreamChannels() {
+		d := s.Downstream[ch]
+		dr := downstreamReport{
+			Channel:       ch,
+			Frequency:     d.Frequency,
+			Modulation:    d.Modulation,
+			PowerLevel:    d.PowerLevel,
+			SNR:           d.SNR,
+			Unerrored:     d.Unerrored,
+			Correctable:   d.Correctable,
+			Uncorrectable: d.Uncorrectable,
+			Quality:       q.Downstream[ch].Level,
+			OutOfSpec:     outOfSpec(q.Downstream[ch]),
+		}
+		r.Downstream = append(r.Downstream, dr)
+	}
+	for _, ch := range s.UpstreamChannels() {
+		u := s.Upstream[ch]
+		ur := upstreamReport{
+			Channel:    ch,
+			Frequency:  u.Frequency,
+			Modulation: u.Modulation,
+			Status:     u.Status,
+			SymbolRate: u.SymbolRate,
+			PowerLevel: u.PowerLevel,
+			Quality:    q.Upstream[ch].Level,
+			OutOfSpec:  outOfSpec(q.Upstream[ch]),
+		}
+		r.Upstream = append(r.Upstream, ur)
+	}
+	return r
+}
+
+func flagged(v string, outOfSpec []string, field string) string {
+	for _, f := range outOfSpec {
+		if f == field {
+			return v + "*"
+		}
+	}
+	return v
+}
+
+func fmtFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeTable(w io.Writer, r *statusReport) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Model: %s\nHealth: %.0f/100 (%s profile)\n\nDownstream\n", r.Model, r.HealthScore, r.Profile)
+	fmt.Fprintln(tw, "Channel\tFrequency\tModulation\tPower (dBmV)\tSNR (dB)\tUnerrored\tCorrectable\tUncorrectable\tQuality\t")
+	for _, d := range r.Downstream {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			d.Channel, d.Frequency, d.Modulation,
+			flagged(fmtFloat(d.PowerLevel), d.OutOfSpec, quality.Power),
+			flagged(fmtFloat(d.SNR), d.OutOfSpec, quality.SNR),
+			fmtFloat(d.Unerrored), fmtFloat(d.Correctable),
+			flagged(fmtFloat(d.Uncorrectable), d.OutOfSpec, quality.CodewordErrorRatio),
+			d.Quality)
+	}
+	fmt.Fprintln(tw, "\nUpstream")
+	fmt.Fprintln(tw, "Channel\tFrequency\tModulation\tStatus\tSymbol Rate\tPower (dBmV)\tQuality\t")
+	for _, u := range r.Upstream {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			u.Channel, u.Frequency, u.Modulation, u.Status, fmtFloat(u.SymbolRate),
+			flagged(fmtFloat(u.PowerLevel), u.OutOfSpec, quality.Power),
+			u.Quality)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, r *statusReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func writeCSV(w io.Writer, r *statusReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"model", "direction", "channel", "frequency", "modulation", "status", "power_dbmv", "snr_db", "symbol_rate", "unerrored", "correctable", "uncorrectable", "quality", "out_of_spec"})
+	for _, d := range r.Downstream {
+		cw.Write([]string{r.Model, "downstream", string(d.Channel), d.Frequency, d.Modulation, "",
+			fmtFloat(d.PowerLevel), fmtFloat(d.SNR), "",
+			fmtFloat(d.Unerrored), fmtFloat(d.Correctable), fmtFloat(d.Uncorrectable),
+			d.Quality.String(), strings.Join(d.OutOfSpec, " ")})
+	}
+	for _, u := range r.Upstream {
+		cw.Write([]string{r.Model, "upstream", string(u.Channel), u.Frequency, u.Modulation, u.Status,
+			fmtFloat(u.PowerLevel), "", fmtFloat(u.SymbolRate), "", "", "",
+			u.Quality.String(), strings.Join(u.OutOfSpec, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
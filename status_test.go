@@ -0,0 +1,133 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusCmd(t *testing.T) {
+	defer func(fake, m, q string, d time.Duration) {
+		*fakeDataPath, *model, *qualityProfile, *timeout = fake, m, q, d
+	}(*fakeDataPath, *model, *qualityProfile, *timeout)
+
+	const (
+		sb8200 = "modem/sb8200/testdata/SB8200.html"
+		sb6121 = "modem/sb6121/testdata/SB6121-signal.html"
+	)
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		wantCode int
+		// check verifies stdout.
+		check func(t *testing.T, out string)
+	}{
+		{
+			name: "table",
+			args: []string{"-fake", sb8200},
+			check: func(t *testing.T, out string) {
+				for _, s := range []string{"Model: SB8200", "Health: 100/100 (docsis profile)", "Downstream", "Upstream"} {
+					if !strings.Contains(out, s) {
+						t.Errorf("Missing %q in:\n%s", s, out)
+					}
+				}
+				if strings.Contains(out, "*") {
+					t.Errorf("Unexpected out of spec value in:\n%s", out)
+				}
+			},
+		},
+		{
+			name: "table out of spec",
+			args: []string{"-fake", sb6121, "-quality_profile", "strict"},
+			check: func(t *testing.T, out string) {
+				if !strings.Contains(out, "  10*  ") {
+					t.Errorf("Downstream power 10 not flagged in:\n%s", out)
+				}
+			},
+		},
+		{
+			name: "json",
+			args: []string{"-fake", sb8200, "-format", "json"},
+			check: func(t *testing.T, out string) {
+				var r statusReport
+				if err := json.Unmarshal([]byte(out), &r); err != nil {
+					t.Fatalf("Failed to decode %q: %v", out, err)
+				}
+				if r.Model != "SB8200" || r.Profile != "docsis" || r.HealthScore != 100 || len(r.Downstream) == 0 || len(r.Upstream) == 0 {
+					t.Errorf("Got %+v", r)
+				}
+			},
+		},
+		{
+			name: "json out of spec",
+			args: []string{"-fake", sb6121, "-format", "json", "-quality_profile", "strict"},
+			check: func(t *testing.T, out string) {
+				var r statusReport
+				if err := json.Unmarshal([]byte(out), &r); err != nil {
+					t.Fatalf("Failed to decode %q: %v", out, err)
+				}
+				if got := r.Downstream[0].OutOfSpec; len(got) != 1 || got[0] != "power" {
+					t.Errorf("Downstream %s out of spec got %v, want [power]", r.Downstream[0].Channel, got)
+				}
+			},
+		},
+		{
+			name: "csv",
+			args: []string{"-fake", sb6121, "-format", "csv", "-quality_profile", "strict"},
+			check: func(t *testing.T, out string) {
+				rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+				if err != nil {
+					t.Fatalf("Failed to read %q: %v", out, err)
+				}
+				if len(rows) < 2 {
+					t.Fatalf("Got %d rows, want a header and channels", len(rows))
+				}
+				if got, want := strings.Join(rows[1], ","), "SB6121,downstream,9,603000000,QAM256,,10,37,,46834465469,21163,111242,bad,power"; got != want {
+					t.Errorf("First row got %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name: "influx",
+			args: []string{"-fake", sb8200, "-format", "influx"},
+			check: func(t *testing.T, out string) {
+				if !strings.Contains(out, "model=SB8200") {
+					t.Errorf("Missing model tag in:\n%s", out)
+				}
+			},
+		},
+		{name: "unknown format", args: []string{"-fake", sb8200, "-format", "xml"}, wantCode: 2},
+		{name: "unknown flag", args: []string{"-colour"}, wantCode: 2},
+		{name: "unknown profile", args: []string{"-fake", sb8200, "-quality_profile", "testdata/missing.json"}, wantCode: 2},
+		{name: "wrong model", args: []string{"-fake", sb8200, "-model", "SB6183"}, wantCode: 1},
+		{name: "no modem", args: []string{"-fake", "testdata/missing.html"}, wantCode: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			*fakeDataPath, *model, *qualityProfile, *timeout = "", "", "docsis", 5*time.Second
+			var stdout, stderr bytes.Buffer
+			if got := runStatus(tc.args, &stdout, &stderr); got != tc.wantCode {
+				t.Fatalf("runStatus got %d, want %d; stderr:\n%s", got, tc.wantCode, stderr.String())
+			}
+			if tc.check != nil {
+				tc.check(t, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stream pushes live modem readings and change events to browsers
+// as Server-Sent Events.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// Event names.
+const (
+	// Data is the status after every successful fetch.
+	Signal = "signal"
+	// Data is a Change.
+	ChangeEvent = "change"
+	// Data is a FetchError.
+	Error = "error"
+)
+
+// FetchError is sent when the modem status couldn't be fetched.
+type FetchError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+type message struct {
+	id    uint64
+	event string
+	data  []byte
+}
+
+func (m *message) bytes() []byte {
+	return []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", m.id, m.event, m.data))
+}
+
+type client struct {
+	ch chan *message
+}
+
+// Broker fans messages out to every connected client.  Each client has a
+// bounded queue, a client that falls so far behind that its queue is full is
+// disconnected rather than blocking Publish.  Browsers reconnect on their own
+// and are sent the latest Signal first.
+type Broker struct {
+	buffer    int
+	heartbeat time.Duration
+
+	mu         sync.Mutex
+	clients    map[*client]bool
+	id         uint64
+	lastSignal *message
+	dropped    int
+}
+
+// NewBroker returns a Broker queueing up to buffer messages per client.
+func NewBroker(buffer int) *Broker {
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &Broker{
+		buffer:    buffer,
+		heartbeat: 15 * time.Second,
+		clients:   map[*client]bool{},
+	}
+}
+
+// Publish sends data encoded as JSON to every client as event.  It never
+// blocks.
+func (b *Broker) Publish(event string, data interface{}) {
+	d, err := json.Marshal(data)
+	if err != nil {
+		glog.Errorf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.id++
+	m := &message{id: b.id, event: event, data: d}
+	if event == Signal {
+		b.lastSignal = m
+	}
+	for c := range b.clients {
+		select {
+		case c.ch <- m:
+		default:
+			glog.Warningf("Disconnecting slow event stream client")
+			b.remove(c)
+			b.dropped++
+		}
+	}
+}
+
+// Close disconnects every client, such as when the server shuts down.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		b.remove(c)
+	}
+}
+
+// Dropped returns the number of clients disconnected for falling behind.
+func (b *Broker) Dropped() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+func (b *Broker) add() *client {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := &client{ch: make(chan *message, b.buffer)}
+	if b.lastSignal != nil {
+		c.ch <- b.lastSignal
+	}
+	b.clients[c] = true
+	return c
+}
+
+// remove must be called with b.mu held.
+func (b *Broker) remove(c *client) {
+	if b.clients[c] {
+		delete(b.clients, c)
+		close(c.ch)
+	}
+}
+
+// ServeHTTP streams events to a client until it disconnects.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	c := b.add()
+	defer func() {
+		b.mu.Lock()
+		b.remove(c)
+		b.mu.Unlock()
+	}()
+	// Ask browsers to wait a little before reconnecting after a disconnect.
+	fmt.Fprintf(w, "retry: 2000\n\n")
+	f.Flush()
+
+	t := time.NewTicker(b.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-c.ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(m.bytes()); err != nil {
+				return
+			}
+			f.Flush()
+		case <-t.C:
+			// Comments keep proxies from closing an idle connection.
+			if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// QualityChanged is a Change where the quality.Level of a channel changed,
+// i.e. a value crossed a threshold of the quality profile.
+const QualityChanged modem.ChangeType = "quality_changed"
+
+// Change is a difference between two consecutive fetches.
+type Change struct {
+	Time time.Time `json:"time"`
+	modem.Change
+}
+
+// Changes returns diff, the modem.Diff between two fetches, with the time of
+// the latter fetch t, followed by any channel whose quality rating changed
+// from prevQ to curQ.
+func Changes(t time.Time, diff []modem.Change, prevQ, curQ *quality.Report) []Change {
+	var cs []Change
+	for _, c := range diff {
+		cs = append(cs, Change{Time: t, Change: c})
+	}
+	quality := func(dir modem.Direction, prev, cur map[modem.Channel]quality.Result) {
+		var chs []modem.Channel
+		for ch := range cur {
+			chs = append(chs, ch)
+		}
+		modem.SortChannels(chs)
+		for _, ch := range chs {
+			p, ok := prev[ch]
+			if !ok {
+				continue
+			}
+			if from, to := p.Level, cur[ch].Level; from != to {
+				cs = append(cs, Change{Time: t, Change: modem.Change{Type: QualityChanged, Direction: dir, Channel: ch, From: from.String(), To: to.String()}})
+			}
+		}
+	}
+	quality(modem.DirectionDownstream, prevQ.Downstream, curQ.Downstream)
+	quality(modem.DirectionUpstream, prevQ.Upstream, curQ.Upstream)
+	return cs
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wathiede/surfer/modem"
+	"github.com/wathiede/surfer/quality"
+)
+
+// readEvent returns the event and data lines of the next event on r,
+// skipping comments and the retry field.
+func readEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+func waitClients(t *testing.T, b *Broker, n int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		b.mu.Lock()
+		got := len(b.clients)
+		b.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d clients", n)
+}
+
+func TestServeHTTP(t *testing.T) {
+	b := NewBroker(10)
+	b.Publish(Signal, map[string]string{"model": "SB8200"})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Errorf("Content-Type got %q, want %q", got, want)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// The latest signal is sent on connect.
+	if ev, data := readEvent(t, r); ev != Signal || data != `{"model":"SB8200"}` {
+		t.Errorf("Got %s %s", ev, data)
+	}
+	waitClients(t, b, 1)
+	b.Publish(ChangeEvent, Change{Change: modem.Change{Type: modem.ChannelRemoved, Direction: modem.DirectionDownstream, Channel: "3"}})
+	ev, data := readEvent(t, r)
+	if ev != ChangeEvent || !strings.Contains(data, `"type":"channel_removed"`) {
+		t.Errorf("Got %s %s", ev, data)
+	}
+}
+
+func TestSlowClientDisconnected(t *testing.T) {
+	b := NewBroker(2)
+	slow := b.add()
+	fast := b.add()
+	for i := 0; i < 2; i++ {
+		b.Publish(Signal, i)
+		<-fast.ch
+	}
+	// fast keeps up, slow has a full queue when the third message arrives.
+	b.Publish(Signal, 2)
+	if _, ok := b.clients[slow]; ok {
+		t.Errorf("Slow client still connected")
+	}
+	if !b.clients[fast] {
+		t.Errorf("Fast client disconnected")
+	}
+	if got := b.Dropped(); got != 1 {
+		t.Errorf("Dropped got %d, want 1", got)
+	}
+	// The slow client can drain what was queued, then sees the close.
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Slow client got %d queued messages, want 2", n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(2)
+	c := b.add()
+	b.Close()
+	if len(b.clients) != 0 {
+		t.Errorf("Got %d clients after Close, want 0", len(b.clients))
+	}
+	if _, ok := <-c.ch; ok {
+		t.Errorf("Client queue still open after Close")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	prev := &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Modulation: "QAM256", SNR: 40},
+			"2": {Modulation: "QAM256", SNR: 40},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Modulation: "ATDMA", PowerLevel: 45},
+		},
+	}
+	cur := &modem.Signal{
+		Downstream: map[modem.Channel]*modem.Downstream{
+			"1": {Modulation: "QAM256", SNR: 40},
+			"2": {Modulation: "QAM64", SNR: 40},
+			"3": {Modulation: "QAM256", SNR: 31},
+		},
+		Upstream: map[modem.Channel]*modem.Upstream{
+			"1": {Modulation: "ATDMA", PowerLevel: 53},
+		},
+	}
+	p := quality.Profiles["docsis"]
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	got := Changes(now, modem.Differ{}.Diff(prev, cur), p.Evaluate(prev), p.Evaluate(cur))
+	want := []Change{
+		{now, modem.Change{Type: modem.ModulationChanged, Direction: modem.DirectionDownstream, Channel: "2", From: "QAM256", To: "QAM64"}},
+		{now, modem.Change{Type: modem.ChannelAdded, Direction: modem.DirectionDownstream, Channel: "3"}},
+		// Channel 3 is marginal, but has no previous rating to change from.
+		{now, modem.Change{Type: QualityChanged, Direction: modem.DirectionUpstream, Channel: "1", From: "good", To: "marginal"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes got\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -24,26 +24,111 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/golang/groupcache/singleflight"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/wathiede/surfer/alert"
+	"github.com/wathiede/surfer/dashboard"
+	"github.com/wathiede/surfer/eventlog"
+	"github.com/wathiede/surfer/history"
+	"github.com/wathiede/surfer/influx"
 	"github.com/wathiede/surfer/modem"
 	_ "github.com/wathiede/surfer/modem/s33"
 	_ "github.com/wathiede/surfer/modem/sb6121"
 	_ "github.com/wathiede/surfer/modem/sb6183"
 	_ "github.com/wathiede/surfer/modem/sb8200"
+	"github.com/wathiede/surfer/mqtt"
+	"github.com/wathiede/surfer/otlp"
+	"github.com/wathiede/surfer/quality"
+	"github.com/wathiede/surfer/record"
+	"github.com/wathiede/surfer/remediate"
+	"github.com/wathiede/surfer/remotewrite"
+	"github.com/wathiede/surfer/replay"
+	"github.com/wathiede/surfer/stream"
+	"github.com/wathiede/surfer/tlsutil"
 )
 
 var (
-	port         = flag.Int("port", 6666, "port to listen on when serving prometheus metrics")
-	timeout      = flag.Duration("timeout", 1*time.Second, "timeout for the HTTP GET to cable modem")
-	fakeDataPath = flag.String("fake", "", "path to fake HTML data.  (default) fetch over HTTP")
+	port                = flag.Int("port", 6666, "port to listen on when serving prometheus metrics")
+	timeout             = flag.Duration("timeout", 1*time.Second, "timeout for the HTTP GET to cable modem")
+	fakeDataPath        = flag.String("fake", "", "path to fake HTML data, or a directory or archive of recorded snapshots to replay.  (default) fetch over HTTP")
+	replaySpeed         = flag.Float64("replay_speed", 1, "when -fake is a directory or archive, replay snapshots at this multiple of real time.  0 advances one snapshot per fetch")
+	model               = flag.String("model", "", "cable modem model to use instead of autodetecting it, one of "+strings.Join(modem.Models(), ", "))
+	qualityProfile      = flag.String("quality_profile", "docsis", "signal quality profile, one of "+strings.Join(qualityProfiles(), ", ")+" or the path to a JSON profile")
+	recordDir           = flag.String("record_dir", "", "if set, save every raw HTTP exchange with the modem to a new subdirectory per scrape")
+	recordKeep          = flag.Int("record_keep", 20, "number of scrapes of each kind to keep in -record_dir, <= 0 keeps all")
+	pollInterval        = flag.Duration("poll_interval", 0, "if set, poll the modem on this interval in addition to every prometheus scrape.  Alerting, remediation, history and the outputs that need regular fetches poll every minute unless this is set")
+	powerJump           = flag.Float64("power_jump", modem.DefaultPowerJump, "log and count channel power level changes larger than this many dB between fetches, <= 0 disables")
+	streamBuffer        = flag.Int("stream_buffer", 16, "events queued per /api/v1/stream client before a slow client is disconnected")
+	recentSamples       = flag.Int("dashboard_samples", 360, "number of recent fetches kept in memory for the dashboard sparklines")
+	alertConfig         = flag.String("alert_config", "", "path to a JSON alerting config, see README.md")
+	historyDir          = flag.String("history_dir", "", "if set, keep signal history in this directory and serve it at /api/v1/history")
+	historyRetention    = flag.Duration("history_retention", history.DefaultOptions.Retention, "how long to keep history in -history_dir")
+	historyRawRetention = flag.Duration("history_raw_retention", history.DefaultOptions.RawRetention, "how long to keep every sample in -history_dir before downsampling to -history_resolution")
+	historyResolution   = flag.Duration("history_resolution", history.DefaultOptions.Resolution, "interval older history is averaged over")
+	mqttBroker          = flag.String("mqtt_broker", "", "if set, publish every fetch to this MQTT broker, tcp://host:port or ssl://host:port")
+	mqttUsername        = flag.String("mqtt_username", "", "MQTT username")
+	mqttPassword        = flag.String("mqtt_password", "", "MQTT password")
+	mqttClientID        = flag.String("mqtt_client_id", "", "MQTT client ID (default surfer-<node ID>)")
+	mqttTopicPrefix     = flag.String("mqtt_topic_prefix", "surfer", "prefix of the MQTT topics published to")
+	mqttDiscoveryPrefix = flag.String("mqtt_discovery_prefix", "homeassistant", "Home Assistant MQTT discovery prefix, empty disables discovery")
+	mqttNodeID          = flag.String("mqtt_node_id", "", "identifies the modem in Home Assistant (default the modem model)")
+	mqttCAFile          = flag.String("mqtt_ca_file", "", "PEM encoded CA certificates to trust for ssl:// brokers in addition to the system roots")
+	mqttInsecure        = flag.Bool("mqtt_insecure_skip_verify", false, "don't verify the certificate of ssl:// brokers")
+	influxURL           = flag.String("influx_url", "", "if set, write every fetch as line protocol to this InfluxDB server, or to stdout if -")
+	influxBucket        = flag.String("influx_bucket", "", "InfluxDB v2 bucket to write to, selects the v2 write API")
+	influxOrg           = flag.String("influx_org", "", "InfluxDB v2 organization")
+	influxToken         = flag.String("influx_token", "", "InfluxDB v2 API token")
+	influxDatabase      = flag.String("influx_database", "surfer", "InfluxDB v1 database to write to when -influx_bucket isn't set")
+	influxRP            = flag.String("influx_retention_policy", "", "InfluxDB v1 retention policy")
+	influxUsername      = flag.String("influx_username", "", "InfluxDB v1 username")
+	influxPassword      = flag.String("influx_password", "", "InfluxDB v1 password")
+	influxBatchSize     = flag.Int("influx_batch_size", influx.DefaultOptions.BatchSize, "lines written to InfluxDB in one request")
+	influxFlush         = flag.Duration("influx_flush_interval", influx.DefaultOptions.FlushInterval, "longest lines wait before being written to InfluxDB")
+	influxBufferDir     = flag.String("influx_buffer_dir", "", "if set, keep batches that couldn't be written to InfluxDB here and write them once it is reachable")
+	influxBufferSize    = flag.Int64("influx_buffer_max_bytes", influx.DefaultOptions.MaxBufferSize, "most bytes kept in -influx_buffer_dir, the oldest batches are dropped past it")
+	pushURL             = flag.String("push_url", "", "if set, push metrics to the Prometheus Pushgateway at this URL after every poll")
+	pushJob             = flag.String("push_job", "surfer", "job name pushed to -push_url")
+	pushSite            = flag.String("push_site", "", "site grouping label pushed to -push_url (default the hostname)")
+	remoteWriteURL      = flag.String("remote_write_url", "", "if set, send metrics to this Prometheus remote write endpoint after every poll")
+	remoteWriteUsername = flag.String("remote_write_username", "", "basic auth username for -remote_write_url")
+	remoteWritePassword = flag.String("remote_write_password", "", "basic auth password for -remote_write_url")
+	remoteWriteToken    = flag.String("remote_write_bearer_token", "", "bearer token for -remote_write_url")
+	remoteWriteLabels   = flag.String("remote_write_labels", "", "comma separated name=value labels added to every remote write series (default job=surfer,instance=<hostname>)")
+	remoteWriteWALDir   = flag.String("remote_write_wal_dir", "", "if set, queue remote write requests in this directory until sent, surviving outages and restarts")
+	remoteWriteWALSize  = flag.Int64("remote_write_wal_max_bytes", remotewrite.DefaultOptions.MaxWALSize, "most bytes kept in -remote_write_wal_dir, the oldest requests are dropped past it")
+	otlpEndpoint        = flag.String("otlp_endpoint", "", "if set, export metrics after every poll to this OTLP/HTTP receiver, e.g. http://collector:4318")
+	otlpHeaders         = flag.String("otlp_headers", "", "comma separated name=value headers sent to -otlp_endpoint")
+	otlpResource        = flag.String("otlp_resource_attributes", "", "comma separated name=value resource attributes added to the OTLP export, e.g. site=home")
+	syslogAddr          = flag.String("syslog_addr", "", "if set, forward new modem event log entries to this syslog server, udp://, tcp:// or tls://host:port")
+	syslogFacility      = flag.String("syslog_facility", "local0", "syslog facility of forwarded events")
+	syslogCAFile        = flag.String("syslog_ca_file", "", "PEM encoded CA certificates to trust for tls:// syslog servers in addition to the system roots")
+	syslogInsecure      = flag.Bool("syslog_insecure_skip_verify", false, "don't verify the certificate of tls:// syslog servers")
+	lokiURL             = flag.String("loki_url", "", "if set, push new modem event log entries to this Loki server, e.g. http://loki:3100")
+	lokiUsername        = flag.String("loki_username", "", "basic auth username for -loki_url")
+	lokiPassword        = flag.String("loki_password", "", "basic auth password for -loki_url")
+	lokiTenant          = flag.String("loki_tenant", "", "Loki tenant ID sent as X-Scope-OrgID")
+	eventSite           = flag.String("event_site", "", "site label of forwarded events (default the hostname)")
+	eventTimezone       = flag.String("event_timezone", "", "time zone of the times in the modem's event log, an IANA name such as America/Los_Angeles or UTC (default the local time zone)")
+	eventStateFile      = flag.String("event_state_file", "", "file remembering which events were forwarded, so restarts don't forward them again")
+	controlToken        = flag.String("control_token", "", "if set, serve POST /api/v1/control/reboot to requests with this bearer token.  Visible to other local users, prefer -control_token_file or $"+controlTokenEnv)
+	controlTokenFile    = flag.String("control_token_file", "", "path to a file holding -control_token")
+	allowReset          = flag.Bool("allow_reset", false, "also allow resetting the modem to factory defaults, with /api/v1/control/reset or surfer control reset")
+	remediationPolicy   = flag.String("remediation_policy", "", "path to a JSON policy for rebooting the modem on sustained degradation, see README.md")
+	infoInterval        = flag.Duration("info_interval", 5*time.Minute, "how often to fetch the modem's product information and event log, for /api/v1/info and the modem_info, modem_boot_time_seconds and event_log_timeouts metrics.  0 disables")
 
 	downstreamSNRMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "downstream_snr",
@@ -99,6 +184,55 @@ var (
 		Name: "fetch_successes",
 		Help: "Count of successes when fetching metrics from modem.",
 	})
+
+	channelQualityMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "channel_signal_quality",
+		Help: "Channel signal quality against -quality_profile, 0 good, 1 marginal, 2 bad",
+	},
+		[]string{"direction", "channel"},
+	)
+	healthScoreMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "signal_health_score",
+		Help: "Overall signal health against -quality_profile from 0 (all bad) to 100 (all good)",
+	})
+
+	signalChangesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signal_changes",
+		Help: "Count of changes between consecutive fetches, such as a channel dropping or changing modulation",
+	},
+		[]string{"type", "direction"},
+	)
+
+	alertFiringMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alert_firing",
+		Help: "1 if the -alert_config rule is firing, 0 otherwise",
+	},
+		[]string{"rule"},
+	)
+
+	remediationActionsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remediation_actions",
+		Help: "Count of reboots taken or suppressed by -remediation_policy, by outcome",
+	},
+		[]string{"condition", "outcome"},
+	)
+
+	modemInfoMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "modem_info",
+		Help: "Always 1, labeled with the modem's hardware and software (firmware) versions",
+	},
+		[]string{"hardware_version", "software_version"},
+	)
+	modemBootTimeMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modem_boot_time_seconds",
+		Help: "When the modem last booted, in seconds since the epoch, from its reported uptime",
+	})
+	eventLogTimeoutsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "event_log_timeouts",
+		Help: "Count of DOCSIS timer timeouts, such as T3 and T4, in the modem's event log",
+	},
+		[]string{"timer"},
+	)
 )
 
 func init() {
@@ -111,58 +245,634 @@ func init() {
 	prometheus.MustRegister(codewordsUncorrectableMetric)
 	prometheus.MustRegister(fetchErrorsMetric)
 	prometheus.MustRegister(fetchSuccessesMetric)
+	prometheus.MustRegister(channelQualityMetric)
+	prometheus.MustRegister(healthScoreMetric)
+	prometheus.MustRegister(signalChangesMetric)
+	prometheus.MustRegister(alertFiringMetric)
+	prometheus.MustRegister(remediationActionsMetric)
+	prometheus.MustRegister(modemInfoMetric)
+	prometheus.MustRegister(modemBootTimeMetric)
+	prometheus.MustRegister(eventLogTimeoutsMetric)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: surfer [flags] [command [command flags]]\n\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "With no command, serve prometheus metrics.  Commands:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  anonymize  scrub identifiers from captured modem pages\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  check      Nagios/Icinga plugin\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  control    reboot or reset the modem\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  status     print the current modem status once\n\n")
+	flag.PrintDefaults()
+}
+
+func qualityProfiles() []string {
+	var names []string
+	for n := range quality.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newModem returns the modem selected by -fake and -model, autodetecting it if
+// neither is set.  It returns nil and no error if no modem was found.
+func newModem(ctx context.Context) (modem.Modem, error) {
+	if replay.Replayable(*fakeDataPath) {
+		return replay.New(ctx, *fakeDataPath, *replaySpeed)
+	}
+	if *fakeDataPath == "" && *model != "" {
+		m := modem.NewModel(*model)
+		if m == nil {
+			return nil, fmt.Errorf("unknown model %q, must be one of %s", *model, strings.Join(modem.Models(), ", "))
+		}
+		return m, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	m := modem.New(ctx, *fakeDataPath)
+	if m != nil && *model != "" && m.Name() != *model {
+		return nil, fmt.Errorf("found a %s, not the -model %s", m.Name(), *model)
+	}
+	return m, nil
+}
+
+// closeModem releases anything m holds, such as the files extracted from a
+// replayed archive.
+func closeModem(m modem.Modem) {
+	if c, ok := m.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			glog.Errorf("Failed to close %s: %v", m.Name(), err)
+		}
+	}
+}
+
+// shutdownTimeout is how long requests in progress are given to finish on
+// SIGINT or SIGTERM.
+const shutdownTimeout = 5 * time.Second
+
+// closers are closed, newest first, when main exits.
+var closers []func()
+
+// atExit registers f to be called when main exits.
+func atExit(f func()) {
+	closers = append(closers, f)
+}
+
+// atExitClose registers closing c, called what in errors, with atExit.
+func atExitClose(what string, c io.Closer) {
+	atExit(func() {
+		if err := c.Close(); err != nil {
+			glog.Errorf("Failed to close %s: %v", what, err)
+		}
+	})
+}
+
+// runClosers calls every function registered with atExit, newest first.
+func runClosers() {
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i]()
+	}
+	closers = nil
+}
+
+// exitf runs the closers registered with atExit, then logs and exits like
+// glog.Exitf.
+func exitf(format string, args ...interface{}) {
+	runClosers()
+	glog.Exitf(format, args...)
+}
+
+// runCommand runs the named subcommand and returns the process exit code.
+func runCommand(cmd string, args []string) int {
+	switch cmd {
+	case "anonymize":
+		return anonymizeCmd(args)
+	case "status":
+		return statusCmd(args)
+	case "check":
+		return checkCmd(args)
+	case "control":
+		return controlCmd(args)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", cmd)
+	flag.Usage()
+	return 2
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	defer glog.Flush()
 
-	ctx := context.Background()
+	if flag.NArg() > 0 {
+		code := runCommand(flag.Arg(0), flag.Args()[1:])
+		glog.Flush()
+		os.Exit(code)
+	}
+
+	if *eventTimezone != "" {
+		loc, err := time.LoadLocation(*eventTimezone)
+		if err != nil {
+			glog.Exitf("Invalid -event_timezone: %v", err)
+		}
+		modem.SetEventLocation(loc)
+	}
+
+	var rec *record.Recorder
+	var err error
+	if *recordDir != "" {
+		rec, err = record.New(*recordDir, *recordKeep)
+		if err != nil {
+			glog.Exitf("Failed to create recorder: %v", err)
+		}
+		modem.SetTransportWrapper(rec.Wrap)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// polling tracks the goroutines fetching from the modem until ctx is
+	// cancelled.
+	var polling sync.WaitGroup
 	var m modem.Modem
 	for {
-		ctx, cancel := context.WithTimeout(ctx, *timeout)
-		m = modem.New(ctx, *fakeDataPath)
-		cancel()
+		m, err = newModem(ctx)
+		if err != nil {
+			glog.Exitf("Failed to create modem: %v", err)
+		}
 		if m != nil {
 			break
 		}
 		glog.Infof("Failed to find modem, sleeping")
 		time.Sleep(5 * time.Second)
 	}
+	atExit(func() { closeModem(m) })
 	glog.Infof("Found modem %q", m.Name())
 
-	g := &singleflight.Group{}
+	profile, err := quality.Load(*qualityProfile)
+	if err != nil {
+		exitf("Failed to load quality profile: %v", err)
+	}
+
+	p := newPoller(m, *timeout)
+	p.subscribe(func(r *poll) {
+		if r.Err != nil {
+			fetchErrorsMetric.Inc()
+			return
+		}
+		updateMetrics(r.Signal, profile)
+		fetchSuccessesMetric.Inc()
+	})
+
+	interval := *pollInterval
+	if *alertConfig != "" {
+		cfg, err := alert.LoadConfig(*alertConfig)
+		if err != nil {
+			exitf("Failed to load alert config: %v", err)
+		}
+		am := alert.NewManager(cfg, m.Name())
+		atExitClose("alerting", am)
+		p.subscribe(func(r *poll) {
+			am.Evaluate(r.Time, r.Signal, r.Err)
+			for rule, firing := range am.Firing() {
+				v := 0.0
+				if firing {
+					v = 1
+				}
+				alertFiringMetric.WithLabelValues(rule).Set(v)
+			}
+		})
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	// guard keeps the control API and remediation from rebooting the modem
+	// while it is already rebooting.
+	guard := newControlGuard()
+	if *remediationPolicy != "" {
+		c, ok := m.(modem.Controller)
+		if !ok {
+			exitf("-remediation_policy set, but the %s can't be rebooted", m.Name())
+		}
+		policy, err := remediate.LoadPolicy(*remediationPolicy)
+		if err != nil {
+			exitf("Failed to load remediation policy: %v", err)
+		}
+		re, err := remediate.New(policy, m.Name(), guardedController{c, guard}, func(a remediate.Action) {
+			remediationActionsMetric.WithLabelValues(a.Condition, a.Outcome).Inc()
+		})
+		if err != nil {
+			exitf("Failed to start remediation: %v", err)
+		}
+		atExitClose("remediation", re)
+		p.subscribe(func(r *poll) {
+			re.Evaluate(r.Time, r.Signal, r.Err)
+		})
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	if *historyDir != "" {
+		hs, err := history.Open(*historyDir, history.Options{Retention: *historyRetention, RawRetention: *historyRawRetention, Resolution: *historyResolution})
+		if err != nil {
+			exitf("Failed to open history: %v", err)
+		}
+		atExitClose("history", hs)
+		p.subscribe(func(r *poll) {
+			if r.Err != nil {
+				return
+			}
+			if err := hs.Add(r.Time, r.Signal); err != nil {
+				glog.Errorf("Failed to add to history: %v", err)
+			}
+		})
+		http.Handle("/api/v1/history", hs)
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	if *mqttBroker != "" {
+		tc, err := tlsutil.Config(*mqttCAFile, *mqttInsecure)
+		if err != nil {
+			exitf("Failed to load MQTT CA certificates: %v", err)
+		}
+		mp := mqtt.NewPublisher(mqtt.Config{
+			Options: mqtt.Options{
+				Broker:    *mqttBroker,
+				ClientID:  *mqttClientID,
+				Username:  *mqttUsername,
+				Password:  *mqttPassword,
+				TLSConfig: tc,
+			},
+			TopicPrefix:     *mqttTopicPrefix,
+			DiscoveryPrefix: *mqttDiscoveryPrefix,
+			NodeID:          *mqttNodeID,
+			Model:           m.Name(),
+		})
+		atExit(mp.Close)
+		p.subscribe(func(r *poll) {
+			var rep *quality.Report
+			if r.Err == nil {
+				rep = profile.Evaluate(r.Signal)
+			}
+			mp.Publish(r.Time, r.Signal, rep, r.Err)
+		})
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	if *influxURL == "-" {
+		p.subscribe(func(r *poll) {
+			if r.Err != nil {
+				return
+			}
+			if err := influx.Write(os.Stdout, r.Time, m.Name(), r.Signal); err != nil {
+				glog.Errorf("Failed to write line protocol: %v", err)
+			}
+		})
+	} else if *influxURL != "" {
+		ic, err := influx.NewClient(influx.Options{
+			URL:             *influxURL,
+			Org:             *influxOrg,
+			Bucket:          *influxBucket,
+			Token:           *influxToken,
+			Database:        *influxDatabase,
+			RetentionPolicy: *influxRP,
+			Username:        *influxUsername,
+			Password:        *influxPassword,
+			BatchSize:       *influxBatchSize,
+			FlushInterval:   *influxFlush,
+			BufferDir:       *influxBufferDir,
+			MaxBufferSize:   *influxBufferSize,
+		})
+		if err != nil {
+			exitf("Failed to create InfluxDB client: %v", err)
+		}
+		atExitClose("InfluxDB client", ic)
+		p.subscribe(func(r *poll) {
+			if r.Err == nil {
+				ic.Add(r.Time, m.Name(), r.Signal)
+			}
+		})
+	}
+	if *influxURL != "" && interval == 0 {
+		interval = time.Minute
+	}
+	if *remoteWriteURL != "" {
+		labels, err := parseLabels(*remoteWriteLabels)
+		if err != nil {
+			exitf("Invalid -remote_write_labels: %v", err)
+		}
+		rw, err := remotewrite.New(remotewrite.Options{
+			URL:            *remoteWriteURL,
+			Username:       *remoteWriteUsername,
+			Password:       *remoteWritePassword,
+			BearerToken:    *remoteWriteToken,
+			ExternalLabels: labels,
+			WALDir:         *remoteWriteWALDir,
+			MaxWALSize:     *remoteWriteWALSize,
+		})
+		if err != nil {
+			exitf("Failed to start remote write: %v", err)
+		}
+		atExitClose("remote write", rw)
+		p.subscribe(func(r *poll) {
+			if err := rw.Append(r.Time, exportedGatherer); err != nil {
+				glog.Errorf("Failed to queue remote write: %v", err)
+			}
+		})
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	if *otlpEndpoint != "" {
+		headers := map[string]string{}
+		if err := parseKeyValues(headers, *otlpHeaders); err != nil {
+			exitf("Invalid -otlp_headers: %v", err)
+		}
+		res := map[string]string{"modem.model": m.Name()}
+		if serial := modemSerial(ctx, m); serial != "" {
+			res["modem.serial"] = serial
+		}
+		if err := parseKeyValues(res, *otlpResource); err != nil {
+			exitf("Invalid -otlp_resource_attributes: %v", err)
+		}
+		oe, err := otlp.NewExporter(otlp.Options{Endpoint: *otlpEndpoint, Headers: headers, Resource: res})
+		if err != nil {
+			exitf("Failed to create OTLP exporter: %v", err)
+		}
+		atExit(oe.Close)
+		p.subscribe(func(r *poll) {
+			if r.Err == nil {
+				oe.Export(r.Time, r.Signal, profile.Evaluate(r.Signal))
+			}
+		})
+		if interval == 0 {
+			interval = time.Minute
+		}
+	}
+	if *syslogAddr != "" || *lokiURL != "" {
+		if fwd := newEventForwarder(m); fwd != nil {
+			atExitClose("event forwarding", fwd)
+			p.subscribe(func(*poll) { fwd.Poll() })
+			if interval == 0 {
+				interval = time.Minute
+			}
+		}
+	}
+	var pu *pusher
+	if *pushURL != "" {
+		if interval == 0 {
+			interval = time.Minute
+		}
+		site := *pushSite
+		if site == "" {
+			site, _ = os.Hostname()
+		}
+		if site == "" || strings.Contains(site, "/") {
+			exitf("Invalid -push_site %q", site)
+		}
+		pu = newPusher(*pushURL, *pushJob, map[string]string{"site": site, "modem": m.Name()}, exportedGatherer, interval)
+		p.subscribe(func(*poll) { pu.push() })
+	}
+
 	ph := prometheus.Handler()
 	// Refresh data every prometheus poll.
 	http.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only make one query to the cable modem if concurrent requests come in.
-		if _, err := g.Do("get", func() (interface{}, error) {
-			ctx, cancel := context.WithTimeout(ctx, *timeout)
-			defer cancel()
-			s, err := m.Status(ctx)
-			if err != nil {
-				fetchErrorsMetric.Inc()
-				return nil, err
+		if res := p.fetch(ctx); res.Err != nil {
+			http.Error(w, res.Err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ph.ServeHTTP(w, r)
+	}))
+	ring := dashboard.NewRing(*recentSamples)
+	p.subscribe(func(r *poll) {
+		ring.Add(r.Time, r.Signal, r.Err)
+	})
+	(&apiHandler{p: p, profile: profile}).register(http.DefaultServeMux)
+	token, err := readControlToken()
+	if err != nil {
+		exitf("Failed to read -control_token_file: %v", err)
+	}
+	if token != "" {
+		(&controlHandler{m: m, token: token, allowReset: *allowReset, g: guard}).register(http.DefaultServeMux)
+	}
+	http.Handle("/api/v1/recent", ring)
+	if *infoInterval > 0 && *fakeDataPath == "" {
+		if inf := newInfoFetcher(m, *timeout); inf != nil {
+			polling.Add(1)
+			go func() {
+				defer polling.Done()
+				inf.run(ctx, *infoInterval)
+			}()
+			http.Handle("/api/v1/info", inf)
+		}
+	}
+
+	broker := stream.NewBroker(*streamBuffer)
+	differ := modem.Differ{PowerJump: *powerJump}
+	var prevOK *poll
+	var prevReport *quality.Report
+	p.subscribe(func(r *poll) {
+		if r.Err != nil {
+			broker.Publish(stream.Error, stream.FetchError{Time: r.Time, Error: r.Err.Error()})
+			return
+		}
+		rep := profile.Evaluate(r.Signal)
+		if prevOK != nil {
+			diff := differ.Diff(prevOK.Signal, r.Signal)
+			for _, c := range diff {
+				glog.Infof("%s %s", m.Name(), c)
+				signalChangesMetric.WithLabelValues(string(c.Type), string(c.Direction)).Inc()
 			}
-			for ch, d := range s.Downstream {
-				downstreamSNRMetric.WithLabelValues(string(ch), d.Frequency, d.Modulation).Set(d.SNR)
-				downstreamPowerLevelMetric.WithLabelValues(string(ch), d.Frequency, d.Modulation).Set(d.PowerLevel)
-				codewordsUnerroredMetric.WithLabelValues(string(ch)).Set(d.Unerrored)
-				codewordsCorrectableMetric.WithLabelValues(string(ch)).Set(d.Correctable)
-				codewordsUncorrectableMetric.WithLabelValues(string(ch)).Set(d.Uncorrectable)
+			for _, c := range stream.Changes(r.Time, diff, prevReport, rep) {
+				broker.Publish(stream.ChangeEvent, c)
 			}
+		}
+		broker.Publish(stream.Signal, newAPIStatus(m.Name(), r, r, profile))
+		prevOK, prevReport = r, rep
+	})
+	http.Handle("/api/v1/stream", broker)
+	http.Handle("/", dashboard.Handler())
+	if rec != nil {
+		http.Handle("/debug/last-response", rec)
+	}
 
-			for ch, u := range s.Upstream {
-				upstreamSymbolRateMetric.WithLabelValues(string(ch), u.Frequency, u.Modulation, u.Status).Set(u.SymbolRate)
-				upstreamPowerLevelMetric.WithLabelValues(string(ch), u.Frequency, u.Modulation, u.Status).Set(u.PowerLevel)
-			}
-			fetchSuccessesMetric.Inc()
-			return nil, nil
-		}); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Start polling only once every subscriber is registered, so none misses
+	// the first poll.
+	if interval > 0 {
+		polling.Add(1)
+		go func() {
+			defer polling.Done()
+			p.run(ctx, interval)
+		}()
+	}
+
+	srv := &http.Server{Addr: ":" + strconv.Itoa(*port)}
+	srv.RegisterOnShutdown(broker.Close)
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		glog.Infof("Got %s, shutting down", s)
+		sctx, scancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := srv.Shutdown(sctx); err != nil {
+			glog.Errorf("Failed to stop serving: %v", err)
+		}
+		scancel()
+		err = nil
+	case err = <-served:
+	}
+
+	// Stop polling, waiting for any fetch in progress so no sink is handed a
+	// poll once closed.
+	cancel()
+	polling.Wait()
+	runClosers()
+	if pu != nil {
+		glog.Infof("Deleting Pushgateway group")
+		if err := pu.close(); err != nil {
+			glog.Errorf("Failed to delete Pushgateway group: %v", err)
+		}
+	}
+	if err != nil {
+		glog.Fatalf("Listener returned: %v", err)
+	}
+}
+
+// modemSerial returns the serial number of m, or "" if it can't be fetched.
+func modemSerial(ctx context.Context, m modem.Modem) string {
+	d, ok := m.(modem.Describer)
+	if !ok {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(record.Scrape(ctx, "info"), *timeout)
+	defer cancel()
+	info, err := d.Info(ctx)
+	if err != nil {
+		if err != modem.ErrFakeData {
+			glog.Warningf("Failed to fetch the %s serial number: %v", m.Name(), err)
+		}
+		return ""
+	}
+	return info.SerialNumber
+}
+
+// recordedEvents records every fetch of the event log as a scrape of its own.
+type recordedEvents struct {
+	modem.EventLogger
+}
+
+func (l recordedEvents) Events(ctx context.Context) ([]modem.Event, error) {
+	return l.EventLogger.Events(record.Scrape(ctx, "events"))
+}
+
+// newEventForwarder returns a forwarder of m's event log to -syslog_addr and
+// -loki_url, or nil if m can't fetch its event log.
+func newEventForwarder(m modem.Modem) *eventlog.Forwarder {
+	l, ok := m.(modem.EventLogger)
+	if !ok {
+		glog.Warningf("Not forwarding events, the %s event log isn't supported", m.Name())
+		return nil
+	}
+	site := *eventSite
+	if site == "" {
+		site, _ = os.Hostname()
+	}
+	var sinks []eventlog.Sink
+	if *syslogAddr != "" {
+		facility, err := eventlog.ParseFacility(*syslogFacility)
+		if err != nil {
+			exitf("Invalid -syslog_facility: %v", err)
+		}
+		tc, err := tlsutil.Config(*syslogCAFile, *syslogInsecure)
+		if err != nil {
+			exitf("Failed to load syslog CA certificates: %v", err)
+		}
+		s, err := eventlog.NewSyslog(eventlog.SyslogOptions{Addr: *syslogAddr, TLSConfig: tc, Facility: facility, Model: m.Name(), Site: site})
+		if err != nil {
+			exitf("Invalid -syslog_addr: %v", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if *lokiURL != "" {
+		lk, err := eventlog.NewLoki(eventlog.LokiOptions{
+			URL:      *lokiURL,
+			Username: *lokiUsername,
+			Password: *lokiPassword,
+			Tenant:   *lokiTenant,
+			Labels:   map[string]string{"job": "surfer", "model": m.Name(), "site": site},
+		})
+		if err != nil {
+			exitf("Invalid -loki_url: %v", err)
+		}
+		sinks = append(sinks, lk)
+	}
+	if *eventStateFile == "" {
+		glog.Warningf("-event_state_file isn't set, events will be forwarded again after a restart")
+	}
+	fwd, err := eventlog.New(recordedEvents{l}, *eventStateFile, *timeout, sinks...)
+	if err != nil {
+		exitf("Failed to start event forwarding: %v", err)
+	}
+	return fwd
+}
+
+// parseKeyValues parses comma separated name=value pairs into m.
+func parseKeyValues(m map[string]string, s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 1 {
+			return fmt.Errorf("%q isn't name=value", kv)
+		}
+		m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return nil
+}
+
+// parseLabels parses comma separated name=value pairs.  job defaults to
+// surfer and instance to the hostname.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{"job": "surfer"}
+	if h, err := os.Hostname(); err == nil {
+		labels["instance"] = h
+	}
+	return labels, parseKeyValues(labels, s)
+}
+
+// updateMetrics sets the prometheus metrics from s.
+func updateMetrics(s *modem.Signal, profile *quality.Profile) {
+	for ch, d := range s.Downstream {
+		downstreamSNRMetric.WithLabelValues(string(ch), d.Frequency, d.Modulation).Set(d.SNR)
+		downstreamPowerLevelMetric.WithLabelValues(string(ch), d.Frequency, d.Modulation).Set(d.PowerLevel)
+		codewordsUnerroredMetric.WithLabelValues(string(ch)).Set(d.Unerrored)
+		codewordsCorrectableMetric.WithLabelValues(string(ch)).Set(d.Correctable)
+		codewordsUncorrectableMetric.WithLabelValues(string(ch)).Set(d.Uncorrectable)
+	}
+
+	for ch, u := range s.Upstream {
+		upstreamSymbolRateMetric.WithLabelValues(string(ch), u.Frequency, u.Modulation, u.Status).Set(u.SymbolRate)
+		upstreamPowerLevelMetric.WithLabelValues(string(ch), u.Frequency, u.Modulation, u.Status).Set(u.PowerLevel)
+	}
+
+	rep := profile.Evaluate(s)
+	setQuality := func(dir string, ch modem.Channel, res quality.Result) {
+		// Channels with nothing to rate aren't exported rather than looking
+		// good.
+		if res.Level == quality.Unrated {
+			channelQualityMetric.DeleteLabelValues(dir, string(ch))
 			return
 		}
-		ph.ServeHTTP(w, r)
-	}))
-	glog.Fatalf("Listener returned: %v", http.ListenAndServe(":"+strconv.Itoa(*port), nil))
+		channelQualityMetric.WithLabelValues(dir, string(ch)).Set(float64(res.Level))
+	}
+	for ch, res := range rep.Downstream {
+		setQuality("downstream", ch, res)
+	}
+	for ch, res := range rep.Upstream {
+		setQuality("upstream", ch, res)
+	}
+	healthScoreMetric.Set(rep.Score)
 }
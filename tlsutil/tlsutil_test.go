@@ -0,0 +1,77 @@
+// Copyright 2020 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsutil
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		caFile   string
+		insecure bool
+		wantErr  bool
+		// wantGet is whether the config trusts the test server.
+		wantGet bool
+	}{
+		{caFile: "", wantGet: false},
+		{caFile: "", insecure: true, wantGet: true},
+		{caFile: caFile, wantGet: true},
+		{caFile: empty, wantErr: true},
+		{caFile: filepath.Join(dir, "missing.pem"), wantErr: true},
+	} {
+		cfg, err := Config(tc.caFile, tc.insecure)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Config(%q, %v) got error %v, want error %v", tc.caFile, tc.insecure, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// The test certificate is for example.com.
+		cfg.ServerName = "example.com"
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := c.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if got := err == nil; got != tc.wantGet {
+			t.Errorf("Config(%q, %v) trusts test server got %v, want %v: %v", tc.caFile, tc.insecure, got, tc.wantGet, err)
+		}
+	}
+}